@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ton-empire/backend/internal/common/cache"
 	"github.com/ton-empire/backend/internal/common/config"
 	"github.com/ton-empire/backend/internal/common/middleware"
 	"github.com/ton-empire/backend/internal/common/proxy"
@@ -34,10 +35,23 @@ func main() {
 	wsHub := websocket.NewHub()
 	go wsHub.Run()
 
-	wsHandler := websocket.NewHandler(wsHub)
-
 	serviceProxy := proxy.NewServiceProxy(cfg)
 
+	// Connecting users join the room of their guild, as told by the user
+	// service
+	wsHandler := websocket.NewHandler(wsHub, serviceProxy)
+
+	redisCache, err := cache.NewRedisCache(cfg.Redis)
+	if err != nil {
+		logger.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	defer redisCache.Close()
+
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	defer stopRelay()
+
+	go wsHandler.RelayEvents(relayCtx, redisCache)
+
 	router := setupRouter(cfg, serviceProxy, wsHandler)
 
 	srv := &http.Server{
@@ -60,6 +74,8 @@ func main() {
 
 	logger.Info("Shutting down server...")
 
+	stopRelay()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
@@ -148,6 +164,13 @@ func setupRouter(cfg *config.Config, serviceProxy *proxy.ServiceProxy, wsHandler
 		game := v1.Group("/game")
 		game.Use(middleware.Auth())
 		{
+			game.GET("/catalog", func(c *gin.Context) {
+				serviceProxy.ProxyToGame(c, "/catalog")
+			})
+			game.GET("/inventory", func(c *gin.Context) {
+				serviceProxy.ProxyToGame(c, "/inventory")
+			})
+
 			districts := game.Group("/districts")
 			{
 				districts.GET("/mine", func(c *gin.Context) {
@@ -162,9 +185,69 @@ func setupRouter(cfg *config.Config, serviceProxy *proxy.ServiceProxy, wsHandler
 				districts.PUT("/buildings/:id/upgrade", func(c *gin.Context) {
 					serviceProxy.ProxyToGame(c, "/districts/buildings/"+c.Param("id")+"/upgrade")
 				})
+				districts.PUT("/buildings/:id/move", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/districts/buildings/"+c.Param("id")+"/move")
+				})
+				districts.DELETE("/buildings/:id", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/districts/buildings/"+c.Param("id"))
+				})
+				districts.POST("/buildings/:id/speedup", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/districts/buildings/"+c.Param("id")+"/speedup")
+				})
+				districts.PUT("/buildings/:id/workers", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/districts/buildings/"+c.Param("id")+"/workers")
+				})
+				districts.POST("/buildings/:id/repair", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/districts/buildings/"+c.Param("id")+"/repair")
+				})
+				districts.GET("/mine/population", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/districts/mine/population")
+				})
+				districts.GET("/mine/terrain", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/districts/mine/terrain")
+				})
+				districts.GET("/mine/placement", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/districts/mine/placement?"+c.Request.URL.RawQuery)
+				})
+				districts.GET("/mine/expansion", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/districts/mine/expansion")
+				})
+				districts.POST("/mine/expansion", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/districts/mine/expansion")
+				})
+				districts.GET("/mine/ledger", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/districts/mine/ledger?"+c.Request.URL.RawQuery)
+				})
+				districts.POST("/mine/relocate", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/districts/mine/relocate")
+				})
 				districts.POST("/collect", func(c *gin.Context) {
 					serviceProxy.ProxyToGame(c, "/districts/collect")
 				})
+				districts.GET("/mine/queue", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/districts/mine/queue")
+				})
+				districts.POST("/mine/queue", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/districts/mine/queue")
+				})
+				districts.PUT("/mine/queue/order", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/districts/mine/queue/order")
+				})
+				districts.DELETE("/mine/queue/:id", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/districts/mine/queue/"+c.Param("id"))
+				})
+				districts.GET("/mine/army", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/districts/mine/army")
+				})
+				districts.POST("/mine/army/train", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/districts/mine/army/train")
+				})
+				districts.GET("/mine/shield", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/districts/mine/shield")
+				})
+				districts.POST("/mine/shield", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/districts/mine/shield")
+				})
 			}
 
 			guilds := game.Group("/guilds")
@@ -184,6 +267,103 @@ func setupRouter(cfg *config.Config, serviceProxy *proxy.ServiceProxy, wsHandler
 				guilds.POST("/leave", func(c *gin.Context) {
 					serviceProxy.ProxyToGame(c, "/guilds/leave")
 				})
+				guilds.GET("/:id/cities", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/guilds/"+c.Param("id")+"/cities")
+				})
+				guilds.POST("/:id/cities", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/guilds/"+c.Param("id")+"/cities")
+				})
+				guilds.GET("/:id/wars", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/guilds/"+c.Param("id")+"/wars")
+				})
+				guilds.POST("/:id/wars", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/guilds/"+c.Param("id")+"/wars")
+				})
+			}
+
+			cities := game.Group("/cities")
+			{
+				cities.GET("/:id", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/cities/"+c.Param("id"))
+				})
+				cities.POST("/:id/upgrade", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/cities/"+c.Param("id")+"/upgrade")
+				})
+				cities.GET("/:id/wave", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/cities/"+c.Param("id")+"/wave")
+				})
+				cities.POST("/:id/reinforcements", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/cities/"+c.Param("id")+"/reinforcements")
+				})
+			}
+
+			game.GET("/world", func(c *gin.Context) {
+				serviceProxy.ProxyToGame(c, "/world?"+c.Request.URL.RawQuery)
+			})
+
+			battles := game.Group("/battles")
+			{
+				battles.GET("", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/battles")
+				})
+				battles.POST("/search", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/battles/search")
+				})
+				battles.POST("/:id/attack", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/battles/"+c.Param("id")+"/attack")
+				})
+				battles.GET("/:id/replay", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/battles/"+c.Param("id")+"/replay")
+				})
+			}
+
+			market := game.Group("/market")
+			{
+				market.GET("/book/:resource", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/market/book/"+c.Param("resource"))
+				})
+				market.GET("/orders", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/market/orders")
+				})
+				market.POST("/orders", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/market/orders")
+				})
+				market.DELETE("/orders/:id", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/market/orders/"+c.Param("id"))
+				})
+			}
+
+			exchange := game.Group("/exchange")
+			{
+				exchange.GET("", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/exchange")
+				})
+				exchange.POST("/trades", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/exchange/trades")
+				})
+				exchange.GET("/history/:resource", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/exchange/history/"+c.Param("resource"))
+				})
+			}
+
+			caravans := game.Group("/caravans")
+			{
+				caravans.GET("", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/caravans")
+				})
+				caravans.POST("", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/caravans")
+				})
+			}
+
+			admin := game.Group("/admin")
+			{
+				admin.GET("/ledger", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/admin/ledger?"+c.Request.URL.RawQuery)
+				})
+				admin.POST("/users/:id/grants", func(c *gin.Context) {
+					serviceProxy.ProxyToGame(c, "/admin/users/"+c.Param("id")+"/grants")
+				})
 			}
 		}
 	}
@@ -2,19 +2,25 @@ package main
 
 import (
 	"context"
+	"errors"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/ton-empire/backend/internal/common/cache"
 	"github.com/ton-empire/backend/internal/common/config"
 	"github.com/ton-empire/backend/internal/common/database"
+	"github.com/ton-empire/backend/internal/common/events"
 	"github.com/ton-empire/backend/internal/common/middleware"
 	"github.com/ton-empire/backend/internal/game"
 	"github.com/ton-empire/backend/pkg/logger"
+	"github.com/ton-empire/backend/pkg/models"
 )
 
 func main() {
@@ -33,8 +39,32 @@ func main() {
 	}
 	defer db.Close()
 
+	redisCache, err := cache.NewRedisCache(cfg.Redis)
+	if err != nil {
+		logger.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	defer redisCache.Close()
+
+	catalog, err := game.LoadCatalog(cfg.Game.CatalogPath)
+	if err != nil {
+		logger.Fatalf("Failed to load building catalog: %v", err)
+	}
+	catalog.Watch()
+
 	gameRepo := game.NewRepository(db)
-	gameService := game.NewService(gameRepo)
+	gameService := game.NewService(gameRepo, catalog, events.NewPublisher(redisCache))
+
+	if granted, err := gameService.GrantBeginnerShields(context.Background()); err != nil {
+		logger.Errorf("Failed to grant beginner shields: %v", err)
+	} else if granted > 0 {
+		logger.Infof("Granted beginner shields to %d districts", granted)
+	}
+
+	schedulerCtx, stopScheduler := context.WithCancel(context.Background())
+	defer stopScheduler()
+
+	scheduler := game.NewScheduler(gameService, cfg.Game.Scheduler)
+	go scheduler.Run(schedulerCtx)
 
 	if cfg.App.Env == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -62,6 +92,8 @@ func main() {
 
 	logger.Info("Shutting down server...")
 
+	stopScheduler()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
@@ -89,21 +121,87 @@ func setupRouter(cfg *config.Config, gameService *game.Service) *gin.Engine {
 
 	router.Use(middleware.Auth())
 
+	router.GET("/catalog", handleGetCatalog(gameService))
+
 	router.GET("/districts/mine", handleGetMyDistrict(gameService))
 	router.GET("/districts/:id/buildings", handleGetDistrictBuildings(gameService))
 	router.POST("/districts/buildings", handleCreateBuilding(gameService))
 	router.PUT("/districts/buildings/:id/upgrade", handleUpgradeBuilding(gameService))
+	router.PUT("/districts/buildings/:id/move", handleMoveBuilding(gameService))
+	router.DELETE("/districts/buildings/:id", handleDemolishBuilding(gameService))
+	router.POST("/districts/buildings/:id/speedup", handleSpeedUpUpgrade(gameService))
+	router.PUT("/districts/buildings/:id/workers", handleAssignWorkers(gameService))
+	router.POST("/districts/buildings/:id/repair", handleRepairBuilding(gameService))
 	router.POST("/districts/collect", handleCollectResources(gameService))
 
+	router.GET("/districts/mine/population", handleGetPopulation(gameService))
+	router.GET("/districts/mine/terrain", handleGetTerrain(gameService))
+	router.GET("/districts/mine/placement", handlePreviewPlacement(gameService))
+	router.GET("/districts/mine/expansion", handleGetExpansion(gameService))
+	router.POST("/districts/mine/expansion", handleExpandDistrict(gameService))
+	router.GET("/districts/mine/ledger", handleGetLedger(gameService))
+	router.POST("/districts/mine/relocate", handleRelocateDistrict(gameService))
+
+	router.GET("/districts/mine/queue", handleGetConstructionQueue(gameService))
+	router.POST("/districts/mine/queue", handleEnqueueUpgrade(gameService))
+	router.PUT("/districts/mine/queue/order", handleReorderQueue(gameService))
+	router.DELETE("/districts/mine/queue/:id", handleCancelQueuedUpgrade(gameService))
+
+	router.GET("/districts/mine/army", handleGetArmy(gameService))
+	router.POST("/districts/mine/army/train", handleTrainTroops(gameService))
+
+	router.GET("/districts/mine/shield", handleGetShield(gameService))
+	router.POST("/districts/mine/shield", handleActivateShield(gameService))
+
+	router.GET("/inventory", handleGetInventory(gameService))
+
 	router.GET("/guilds", handleGetGuilds(gameService))
 	router.POST("/guilds", handleCreateGuild(gameService))
 	router.GET("/guilds/:id", handleGetGuild(gameService))
 	router.POST("/guilds/:id/join", handleJoinGuild(gameService))
 	router.POST("/guilds/leave", handleLeaveGuild(gameService))
+	router.GET("/guilds/:id/cities", handleGetGuildCities(gameService))
+	router.POST("/guilds/:id/cities", handleFoundCity(gameService))
+	router.GET("/guilds/:id/wars", handleGetGuildWars(gameService))
+	router.POST("/guilds/:id/wars", handleDeclareWar(gameService))
+
+	router.GET("/cities/:id", handleGetCity(gameService))
+	router.POST("/cities/:id/upgrade", handleUpgradeCity(gameService))
+	router.GET("/cities/:id/wave", handleGetCityWave(gameService))
+	router.POST("/cities/:id/reinforcements", handleSendReinforcements(gameService))
+
+	router.GET("/world", handleGetWorld(gameService))
+
+	router.GET("/battles", handleGetBattles(gameService))
+	router.POST("/battles/search", handleSearchTargets(gameService))
+	router.POST("/battles/:id/attack", handleAttack(gameService))
+	router.GET("/battles/:id/replay", handleGetBattleReplay(gameService))
+
+	router.GET("/market/book/:resource", handleGetOrderBook(gameService))
+	router.GET("/market/orders", handleGetMarketOrders(gameService))
+	router.POST("/market/orders", handlePlaceMarketOrder(gameService))
+	router.DELETE("/market/orders/:id", handleCancelMarketOrder(gameService))
+
+	router.GET("/exchange", handleGetExchange(gameService))
+	router.POST("/exchange/trades", handleTradeWithExchange(gameService))
+	router.GET("/exchange/history/:resource", handleGetExchangeHistory(gameService))
+
+	router.GET("/caravans", handleGetCaravans(gameService))
+	router.POST("/caravans", handleSendCaravan(gameService))
+
+	admin := router.Group("/admin", middleware.Admin(cfg.Game.AdminUserIDs))
+	admin.GET("/ledger", handleQueryLedger(gameService))
+	admin.POST("/users/:id/grants", handleGrantResources(gameService))
 
 	return router
 }
 
+func handleGetCatalog(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, service.GetCatalog())
+	}
+}
+
 func handleGetMyDistrict(service *game.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, err := middleware.GetUserID(c)
@@ -164,7 +262,7 @@ func handleCreateBuilding(service *game.Service) gin.HandlerFunc {
 		building, err := service.CreateBuilding(c.Request.Context(), userID, req)
 		if err != nil {
 			logger.Errorf("Failed to create building: %v", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.JSON(http.StatusBadRequest, errorResponse(err))
 			return
 		}
 
@@ -190,6 +288,37 @@ func handleUpgradeBuilding(service *game.Service) gin.HandlerFunc {
 		building, err := service.UpgradeBuilding(c.Request.Context(), userID, buildingID)
 		if err != nil {
 			logger.Errorf("Failed to upgrade building: %v", err)
+			c.JSON(http.StatusBadRequest, errorResponse(err))
+			return
+		}
+
+		c.JSON(http.StatusOK, building)
+	}
+}
+
+func handleMoveBuilding(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		buildingID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid building ID"})
+			return
+		}
+
+		var req game.MoveBuildingRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+
+		building, err := service.MoveBuilding(c.Request.Context(), userID, buildingID, req)
+		if err != nil {
+			logger.Errorf("Failed to move building: %v", err)
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
@@ -198,6 +327,31 @@ func handleUpgradeBuilding(service *game.Service) gin.HandlerFunc {
 	}
 }
 
+func handleDemolishBuilding(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		buildingID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid building ID"})
+			return
+		}
+
+		result, err := service.DemolishBuilding(c.Request.Context(), userID, buildingID)
+		if err != nil {
+			logger.Errorf("Failed to demolish building: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
 func handleCollectResources(service *game.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, err := middleware.GetUserID(c)
@@ -217,16 +371,26 @@ func handleCollectResources(service *game.Service) gin.HandlerFunc {
 	}
 }
 
-func handleGetGuilds(service *game.Service) gin.HandlerFunc {
+func handleGetConstructionQueue(service *game.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"guilds": []interface{}{},
-			"count":  0,
-		})
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		queue, err := service.GetConstructionQueue(c.Request.Context(), userID)
+		if err != nil {
+			logger.Errorf("Failed to get construction queue: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get construction queue"})
+			return
+		}
+
+		c.JSON(http.StatusOK, queue)
 	}
 }
 
-func handleCreateGuild(service *game.Service) gin.HandlerFunc {
+func handleEnqueueUpgrade(service *game.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, err := middleware.GetUserID(c)
 		if err != nil {
@@ -234,38 +398,162 @@ func handleCreateGuild(service *game.Service) gin.HandlerFunc {
 			return
 		}
 
-		var req game.CreateGuildRequest
+		var req game.EnqueueUpgradeRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 			return
 		}
 
-		guild, err := service.CreateGuild(c.Request.Context(), userID, req)
+		queue, err := service.EnqueueUpgrade(c.Request.Context(), userID, req)
 		if err != nil {
-			logger.Errorf("Failed to create guild: %v", err)
+			logger.Errorf("Failed to queue upgrade: %v", err)
+			c.JSON(http.StatusBadRequest, errorResponse(err))
+			return
+		}
+
+		c.JSON(http.StatusCreated, queue)
+	}
+}
+
+func handleGetArmy(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		army, err := service.GetArmy(c.Request.Context(), userID)
+		if err != nil {
+			logger.Errorf("Failed to get army: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get army"})
+			return
+		}
+
+		c.JSON(http.StatusOK, army)
+	}
+}
+
+func handleTrainTroops(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var req game.TrainTroopsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+
+		army, err := service.TrainTroops(c.Request.Context(), userID, req)
+		if err != nil {
+			logger.Errorf("Failed to train troops: %v", err)
+			c.JSON(http.StatusBadRequest, errorResponse(err))
+			return
+		}
+
+		c.JSON(http.StatusCreated, army)
+	}
+}
+
+func handleGetShield(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		shield, err := service.GetShield(c.Request.Context(), userID)
+		if err != nil {
+			logger.Errorf("Failed to get shield: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get shield"})
+			return
+		}
+
+		c.JSON(http.StatusOK, shield)
+	}
+}
+
+func handleActivateShield(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var req game.ActivateShieldRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+
+		shield, err := service.ActivateShield(c.Request.Context(), userID, req)
+		if err != nil {
+			logger.Errorf("Failed to activate shield: %v", err)
+			c.JSON(http.StatusBadRequest, errorResponse(err))
+			return
+		}
+
+		c.JSON(http.StatusOK, shield)
+	}
+}
+
+func handleReorderQueue(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var req game.ReorderQueueRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+
+		queue, err := service.ReorderQueue(c.Request.Context(), userID, req)
+		if err != nil {
+			logger.Errorf("Failed to reorder construction queue: %v", err)
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(http.StatusCreated, guild)
+		c.JSON(http.StatusOK, queue)
 	}
 }
 
-func handleGetGuild(service *game.Service) gin.HandlerFunc {
+func handleCancelQueuedUpgrade(service *game.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		guildIDStr := c.Param("id")
-		guildID, err := uuid.Parse(guildIDStr)
+		userID, err := middleware.GetUserID(c)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid guild ID"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 			return
 		}
 
-		_ = guildID
-		c.JSON(http.StatusNotImplemented, gin.H{"error": "not implemented"})
+		entryID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid queue entry ID"})
+			return
+		}
+
+		result, err := service.CancelQueuedUpgrade(c.Request.Context(), userID, entryID)
+		if err != nil {
+			logger.Errorf("Failed to cancel queued upgrade: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
 	}
 }
 
-func handleJoinGuild(service *game.Service) gin.HandlerFunc {
+func handleSpeedUpUpgrade(service *game.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, err := middleware.GetUserID(c)
 		if err != nil {
@@ -273,24 +561,31 @@ func handleJoinGuild(service *game.Service) gin.HandlerFunc {
 			return
 		}
 
-		guildIDStr := c.Param("id")
-		guildID, err := uuid.Parse(guildIDStr)
+		buildingID, err := uuid.Parse(c.Param("id"))
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid guild ID"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid building ID"})
 			return
 		}
 
-		if err := service.JoinGuild(c.Request.Context(), userID, guildID); err != nil {
-			logger.Errorf("Failed to join guild: %v", err)
+		// An empty body finishes the upgrade for resources
+		var req game.SpeedUpRequest
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+
+		result, err := service.SpeedUpUpgrade(c.Request.Context(), userID, buildingID, req)
+		if err != nil {
+			logger.Errorf("Failed to speed up upgrade: %v", err)
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "successfully joined guild"})
+		c.JSON(http.StatusOK, result)
 	}
 }
 
-func handleLeaveGuild(service *game.Service) gin.HandlerFunc {
+func handleGetTerrain(service *game.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, err := middleware.GetUserID(c)
 		if err != nil {
@@ -298,12 +593,931 @@ func handleLeaveGuild(service *game.Service) gin.HandlerFunc {
 			return
 		}
 
-		if err := service.LeaveGuild(c.Request.Context(), userID); err != nil {
-			logger.Errorf("Failed to leave guild: %v", err)
+		tiles, err := service.GetTerrain(c.Request.Context(), userID)
+		if err != nil {
+			logger.Errorf("Failed to get terrain: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get terrain"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"tiles": tiles})
+	}
+}
+
+func handlePreviewPlacement(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		req := game.PlacementPreviewRequest{
+			Type: models.BuildingType(c.Query("type")),
+		}
+		if id := c.Query("building_id"); id != "" {
+			if req.BuildingID, err = uuid.Parse(id); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid building ID"})
+				return
+			}
+		}
+		if req.Type == "" && req.BuildingID == uuid.Nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "type or building_id required"})
+			return
+		}
+
+		x, errX := strconv.Atoi(c.Query("x"))
+		y, errY := strconv.Atoi(c.Query("y"))
+		if errX != nil || errY != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid position"})
+			return
+		}
+		req.Position = models.Position{X: x, Y: y}
+
+		preview, err := service.PreviewPlacement(c.Request.Context(), userID, req)
+		if err != nil {
+			logger.Errorf("Failed to preview placement: %v", err)
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "successfully left guild"})
+		c.JSON(http.StatusOK, preview)
+	}
+}
+
+func handleGetExpansion(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		status, err := service.GetExpansion(c.Request.Context(), userID)
+		if err != nil {
+			logger.Errorf("Failed to get expansion: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get expansion"})
+			return
+		}
+
+		c.JSON(http.StatusOK, status)
 	}
-}
\ No newline at end of file
+}
+
+func handleExpandDistrict(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		result, err := service.ExpandDistrict(c.Request.Context(), userID)
+		if err != nil {
+			logger.Errorf("Failed to expand district: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func handleGetPopulation(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		population, err := service.GetPopulation(c.Request.Context(), userID)
+		if err != nil {
+			logger.Errorf("Failed to get population: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get population"})
+			return
+		}
+
+		c.JSON(http.StatusOK, population)
+	}
+}
+
+func handleAssignWorkers(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		buildingID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid building ID"})
+			return
+		}
+
+		var req game.AssignWorkersRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+
+		population, err := service.AssignWorkers(c.Request.Context(), userID, buildingID, req)
+		if err != nil {
+			logger.Errorf("Failed to assign workers: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, population)
+	}
+}
+
+func handleRepairBuilding(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		buildingID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid building ID"})
+			return
+		}
+
+		result, err := service.RepairBuilding(c.Request.Context(), userID, buildingID)
+		if err != nil {
+			logger.Errorf("Failed to repair building: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func handleGetInventory(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		items, err := service.GetInventory(c.Request.Context(), userID)
+		if err != nil {
+			logger.Errorf("Failed to get inventory: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get inventory"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"items": items})
+	}
+}
+
+func handleGetLedger(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		before, limit, err := parseLedgerPage(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		page, err := service.GetLedger(c.Request.Context(), userID, before, limit)
+		if err != nil {
+			logger.Errorf("Failed to get ledger: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get ledger"})
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+func handleQueryLedger(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.Query("user_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		query := game.LedgerQuery{UserID: userID}
+		if from := c.Query("from"); from != "" {
+			if query.From, err = time.Parse(time.RFC3339, from); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from time"})
+				return
+			}
+		}
+		if to := c.Query("to"); to != "" {
+			if query.To, err = time.Parse(time.RFC3339, to); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to time"})
+				return
+			}
+		}
+		if query.Before, query.Limit, err = parseLedgerPage(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		page, err := service.QueryLedger(c.Request.Context(), query)
+		if err != nil {
+			logger.Errorf("Failed to query ledger: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+func handleGrantResources(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		var req game.GrantResourcesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+
+		resources, err := service.GrantResources(c.Request.Context(), adminID, userID, req)
+		if err != nil {
+			logger.Errorf("Failed to grant resources: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		logger.Infof("Admin %s granted %v to user %s", adminID, req.Resources, userID)
+		c.JSON(http.StatusOK, gin.H{"total_resources": resources})
+	}
+}
+
+func handleGetGuilds(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"guilds": []interface{}{},
+			"count":  0,
+		})
+	}
+}
+
+func handleCreateGuild(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var req game.CreateGuildRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+
+		guild, err := service.CreateGuild(c.Request.Context(), userID, req)
+		if err != nil {
+			logger.Errorf("Failed to create guild: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, guild)
+	}
+}
+
+func handleGetGuild(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		guildIDStr := c.Param("id")
+		guildID, err := uuid.Parse(guildIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid guild ID"})
+			return
+		}
+
+		_ = guildID
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "not implemented"})
+	}
+}
+
+func handleJoinGuild(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		guildIDStr := c.Param("id")
+		guildID, err := uuid.Parse(guildIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid guild ID"})
+			return
+		}
+
+		if err := service.JoinGuild(c.Request.Context(), userID, guildID); err != nil {
+			logger.Errorf("Failed to join guild: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "successfully joined guild"})
+	}
+}
+
+func handleLeaveGuild(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		if err := service.LeaveGuild(c.Request.Context(), userID); err != nil {
+			logger.Errorf("Failed to leave guild: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "successfully left guild"})
+	}
+}
+
+func handleGetGuildCities(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		guildID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid guild ID"})
+			return
+		}
+
+		cities, err := service.GetGuildCities(c.Request.Context(), guildID)
+		if err != nil {
+			logger.Errorf("Failed to get guild cities: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get cities"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"cities": cities,
+			"count":  len(cities),
+		})
+	}
+}
+
+func handleFoundCity(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		guildID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid guild ID"})
+			return
+		}
+
+		var req game.FoundCityRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+
+		city, err := service.FoundCity(c.Request.Context(), userID, guildID, req)
+		if err != nil {
+			logger.Errorf("Failed to found city: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, city)
+	}
+}
+
+func handleGetGuildWars(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		guildID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid guild ID"})
+			return
+		}
+
+		wars, err := service.GetGuildWars(c.Request.Context(), guildID)
+		if err != nil {
+			logger.Errorf("Failed to get guild wars: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get wars"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"wars":  wars,
+			"count": len(wars),
+		})
+	}
+}
+
+func handleDeclareWar(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		guildID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid guild ID"})
+			return
+		}
+
+		var req game.DeclareWarRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+
+		war, err := service.DeclareWar(c.Request.Context(), userID, guildID, req)
+		if err != nil {
+			logger.Errorf("Failed to declare war: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, war)
+	}
+}
+
+func handleGetCity(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cityID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid city ID"})
+			return
+		}
+
+		city, err := service.GetCity(c.Request.Context(), cityID)
+		if err != nil {
+			logger.Errorf("Failed to get city: %v", err)
+			c.JSON(http.StatusNotFound, gin.H{"error": "city not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, city)
+	}
+}
+
+func handleUpgradeCity(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		cityID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid city ID"})
+			return
+		}
+
+		city, err := service.UpgradeCity(c.Request.Context(), userID, cityID)
+		if err != nil {
+			logger.Errorf("Failed to upgrade city: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, city)
+	}
+}
+
+func handleGetCityWave(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cityID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid city ID"})
+			return
+		}
+
+		wave, err := service.GetCityWave(c.Request.Context(), cityID)
+		if err != nil {
+			logger.Errorf("Failed to get city wave: %v", err)
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, wave)
+	}
+}
+
+func handleSendReinforcements(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		cityID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid city ID"})
+			return
+		}
+
+		var req game.ReinforceRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+
+		wave, err := service.SendReinforcements(c.Request.Context(), userID, cityID, req)
+		if err != nil {
+			logger.Errorf("Failed to send reinforcements: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, wave)
+	}
+}
+
+func handleGetWorld(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		x, errX := strconv.Atoi(c.Query("x"))
+		y, errY := strconv.Atoi(c.Query("y"))
+		w, errW := strconv.Atoi(c.Query("w"))
+		h, errH := strconv.Atoi(c.Query("h"))
+		if errX != nil || errY != nil || errW != nil || errH != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid viewport"})
+			return
+		}
+
+		world, err := service.GetWorld(c.Request.Context(), game.WorldViewport{X: x, Y: y, W: w, H: h})
+		if err != nil {
+			logger.Errorf("Failed to get world: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, world)
+	}
+}
+
+func handleGetBattles(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		battles, err := service.GetBattles(c.Request.Context(), userID)
+		if err != nil {
+			logger.Errorf("Failed to get battles: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get battles"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"battles": battles})
+	}
+}
+
+func handleSearchTargets(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var req game.SearchTargetsRequest
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+
+		targets, err := service.SearchTargets(c.Request.Context(), userID, req)
+		if err != nil {
+			logger.Errorf("Failed to search battle targets: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"targets": targets})
+	}
+}
+
+func handleAttack(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		targetID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid target user ID"})
+			return
+		}
+
+		battle, err := service.Attack(c.Request.Context(), userID, targetID)
+		if err != nil {
+			logger.Errorf("Failed to attack: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, battle)
+	}
+}
+
+func handleGetBattleReplay(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		battleID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid battle ID"})
+			return
+		}
+
+		replay, err := service.GetBattleReplay(c.Request.Context(), userID, battleID)
+		if err != nil {
+			logger.Errorf("Failed to get battle replay: %v", err)
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, replay)
+	}
+}
+
+func handleGetOrderBook(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		resourceType := models.ResourceType(c.Param("resource"))
+		book, err := service.GetOrderBook(c.Request.Context(), userID, resourceType)
+		if err != nil {
+			logger.Errorf("Failed to get order book: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, book)
+	}
+}
+
+func handleGetMarketOrders(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		orders, err := service.GetMarketOrders(c.Request.Context(), userID)
+		if err != nil {
+			logger.Errorf("Failed to get market orders: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get market orders"})
+			return
+		}
+
+		c.JSON(http.StatusOK, orders)
+	}
+}
+
+func handlePlaceMarketOrder(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var req game.PlaceOrderRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+
+		order, err := service.PlaceMarketOrder(c.Request.Context(), userID, req)
+		if err != nil {
+			logger.Errorf("Failed to place market order: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, order)
+	}
+}
+
+func handleCancelMarketOrder(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		orderID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid order ID"})
+			return
+		}
+
+		order, err := service.CancelMarketOrder(c.Request.Context(), userID, orderID)
+		if err != nil {
+			logger.Errorf("Failed to cancel market order: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, order)
+	}
+}
+
+func handleGetExchange(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		exchange, err := service.GetExchange(c.Request.Context(), userID)
+		if err != nil {
+			logger.Errorf("Failed to get exchange: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get exchange"})
+			return
+		}
+
+		c.JSON(http.StatusOK, exchange)
+	}
+}
+
+func handleTradeWithExchange(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var req game.ExchangeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+
+		trade, err := service.TradeWithExchange(c.Request.Context(), userID, req)
+		if err != nil {
+			logger.Errorf("Failed to trade with exchange: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, trade)
+	}
+}
+
+func handleGetExchangeHistory(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		resourceType := models.ResourceType(c.Param("resource"))
+		history, err := service.GetExchangeHistory(c.Request.Context(), resourceType)
+		if err != nil {
+			logger.Errorf("Failed to get exchange history: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, history)
+	}
+}
+
+func handleGetCaravans(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		caravans, err := service.GetCaravans(c.Request.Context(), userID)
+		if err != nil {
+			logger.Errorf("Failed to get caravans: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get caravans"})
+			return
+		}
+
+		c.JSON(http.StatusOK, caravans)
+	}
+}
+
+func handleSendCaravan(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var req game.SendCaravanRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+
+		caravan, err := service.SendCaravan(c.Request.Context(), userID, req)
+		if err != nil {
+			logger.Errorf("Failed to send caravan: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, caravan)
+	}
+}
+
+func handleRelocateDistrict(service *game.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := middleware.GetUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var req game.RelocateDistrictRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+
+		city, err := service.RelocateDistrict(c.Request.Context(), userID, req)
+		if err != nil {
+			logger.Errorf("Failed to relocate district: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, city)
+	}
+}
+
+// parseLedgerPage reads the before cursor and limit of a ledger page
+func parseLedgerPage(c *gin.Context) (int64, int, error) {
+	var before int64
+	var limit int
+	var err error
+	if b := c.Query("before"); b != "" {
+		if before, err = strconv.ParseInt(b, 10, 64); err != nil {
+			return 0, 0, errors.New("invalid before cursor")
+		}
+	}
+	if l := c.Query("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil {
+			return 0, 0, errors.New("invalid limit")
+		}
+	}
+	return before, limit, nil
+}
+
+// errorResponse renders a game rule violation with its code and details, and
+// any other error as a plain message
+func errorResponse(err error) interface{} {
+	var gameErr *game.Error
+	if errors.As(err, &gameErr) {
+		return gameErr
+	}
+	return gin.H{"error": err.Error()}
+}
@@ -138,6 +138,16 @@ func (c *RedisCache) Unlock(ctx context.Context, key string) error {
 	return c.client.Del(ctx, key).Err()
 }
 
+// Publish posts a message to a pub/sub channel
+func (c *RedisCache) Publish(ctx context.Context, channel string, message interface{}) error {
+	return c.client.Publish(ctx, channel, message).Err()
+}
+
+// Subscribe subscribes to a pub/sub channel
+func (c *RedisCache) Subscribe(ctx context.Context, channel string) *redis.PubSub {
+	return c.client.Subscribe(ctx, channel)
+}
+
 // Cache key builders
 func UserCacheKey(userID string) string {
 	return fmt.Sprintf("user:%s", userID)
@@ -16,6 +16,7 @@ type Config struct {
 	JWT      JWTConfig      `mapstructure:"jwt"`
 	RateLimiter RateLimiterConfig `mapstructure:"rate_limiter"`
 	Logging  LoggingConfig  `mapstructure:"logging"`
+	Game     GameConfig     `mapstructure:"game"`
 }
 
 type AppConfig struct {
@@ -102,6 +103,18 @@ type FileLogConfig struct {
 	MaxAge     int    `mapstructure:"max_age"`
 }
 
+type GameConfig struct {
+	CatalogPath string          `mapstructure:"catalog_path"`
+	Scheduler   SchedulerConfig `mapstructure:"scheduler"`
+	// Users allowed to call the admin endpoints
+	AdminUserIDs []string `mapstructure:"admin_user_ids"`
+}
+
+type SchedulerConfig struct {
+	Interval  time.Duration `mapstructure:"interval"`
+	BatchSize int           `mapstructure:"batch_size"`
+}
+
 func Load() (*Config, error) {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
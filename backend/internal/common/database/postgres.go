@@ -43,7 +43,13 @@ func (db *DB) Close() error {
 }
 
 func (db *DB) Transaction(fn func(*sqlx.Tx) error) error {
-	tx, err := db.Beginx()
+	return db.TransactionContext(context.Background(), fn)
+}
+
+// TransactionContext runs fn in a transaction that is rolled back if ctx is
+// cancelled or its deadline passes before it commits
+func (db *DB) TransactionContext(ctx context.Context, fn func(*sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,65 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/ton-empire/backend/internal/common/cache"
+)
+
+// Channel is the Redis pub/sub channel game events are published on
+const Channel = "ton-empire:events"
+
+// WebSocket room IDs start with the prefix of the kind of room
+const (
+	// RegionRoomPrefix starts the rooms of world map regions
+	RegionRoomPrefix = "region:"
+	// GuildRoomPrefix starts the rooms of guilds
+	GuildRoomPrefix = "guild:"
+)
+
+// Event is a game event relayed by the API gateway to WebSocket clients
+type Event struct {
+	Type   string          `json:"type"`
+	UserID uuid.UUID       `json:"user_id,omitempty"`
+	RoomID string          `json:"room_id,omitempty"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// Publisher publishes game events from backend services
+type Publisher struct {
+	cache *cache.RedisCache
+}
+
+func NewPublisher(cache *cache.RedisCache) *Publisher {
+	return &Publisher{
+		cache: cache,
+	}
+}
+
+// PublishToUser publishes an event addressed to a single user
+func (p *Publisher) PublishToUser(ctx context.Context, userID uuid.UUID, eventType string, data interface{}) error {
+	return p.publish(ctx, &Event{Type: eventType, UserID: userID}, data)
+}
+
+// PublishToRoom publishes an event addressed to a WebSocket room
+func (p *Publisher) PublishToRoom(ctx context.Context, roomID string, eventType string, data interface{}) error {
+	return p.publish(ctx, &Event{Type: eventType, RoomID: roomID}, data)
+}
+
+func (p *Publisher) publish(ctx context.Context, event *Event, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event data: %w", err)
+	}
+	event.Data = payload
+
+	message, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	return p.cache.Publish(ctx, Channel, message)
+}
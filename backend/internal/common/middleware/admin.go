@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ton-empire/backend/pkg/logger"
+)
+
+// Admin only lets through the users listed in adminIDs. It must run after Auth.
+func Admin(adminIDs []string) gin.HandlerFunc {
+	admins := make(map[uuid.UUID]bool, len(adminIDs))
+	for _, id := range adminIDs {
+		adminID, err := uuid.Parse(id)
+		if err != nil {
+			logger.Errorf("Ignoring invalid admin user ID %q: %v", id, err)
+			continue
+		}
+		admins[adminID] = true
+	}
+
+	return func(c *gin.Context) {
+		userID, err := GetUserID(c)
+		if err != nil || !admins[userID] {
+			c.JSON(http.StatusForbidden, gin.H{"error": "admin access required"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
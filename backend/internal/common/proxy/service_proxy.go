@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,6 +12,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/ton-empire/backend/internal/common/config"
 	"github.com/ton-empire/backend/pkg/logger"
+	"github.com/ton-empire/backend/pkg/models"
 )
 
 type ServiceProxy struct {
@@ -142,4 +144,32 @@ func (s *ServiceProxy) CallService(serviceAddr string, method string, path strin
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// GetCurrentUser fetches the user an authorization header belongs to from
+// the user service
+func (s *ServiceProxy) GetCurrentUser(ctx context.Context, authorization string) (*models.User, error) {
+	url := fmt.Sprintf("http://%s/users/me", s.config.Server.UserService.Address())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", authorization)
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("service returned error: %d - %s", resp.StatusCode, string(body))
+	}
+
+	var user models.User
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &user, nil
+}
@@ -0,0 +1,228 @@
+package game
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// defaultGridSize is the width and height of a district grid before any
+// land expansion
+const defaultGridSize = 10
+
+// starterTownHallPosition is where every district's Town Hall starts. It
+// never gets terrain.
+var starterTownHallPosition = models.Position{X: 5, Y: 5}
+
+// Adjacency operations
+
+// PreviewPlacement returns whether a building of a type could be placed at a
+// position and the adjacency bonus it would get there. Passing a building ID
+// previews moving that building instead.
+func (s *Service) PreviewPlacement(ctx context.Context, userID uuid.UUID, req PlacementPreviewRequest) (*PlacementPreview, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	buildings, err := s.repo.GetBuildingsByDistrict(ctx, district.ID)
+	if err != nil {
+		return nil, err
+	}
+	gridSize, err := s.repo.GetDistrictGridSize(ctx, district.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := s.catalog.Get()
+	buildingType := req.Type
+	if req.BuildingID != uuid.Nil {
+		building := findBuilding(buildings, req.BuildingID)
+		if building == nil {
+			return nil, fmt.Errorf("building not found")
+		}
+		buildingType = building.Type
+	}
+	if _, err := catalog.Building(buildingType); err != nil {
+		return nil, err
+	}
+
+	preview := &PlacementPreview{
+		Type:     buildingType,
+		Position: req.Position,
+		Valid:    true,
+	}
+	if err := s.validateBuildingPlacement(ctx, district.ID, req.Position); err != nil {
+		preview.Valid = false
+		preview.Error = err.Error()
+	}
+
+	preview.Bonus, preview.Matches = catalog.adjacencyBonus(district.ID, gridSize, buildingType, req.Position, buildings, req.BuildingID)
+	return preview, nil
+}
+
+// GetTerrain returns the terrain cells of a user's district
+func (s *Service) GetTerrain(ctx context.Context, userID uuid.UUID) ([]*TerrainTile, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	gridSize, err := s.repo.GetDistrictGridSize(ctx, district.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := s.catalog.Get()
+	tiles := []*TerrainTile{}
+	for y := 0; y < gridSize; y++ {
+		for x := 0; x < gridSize; x++ {
+			position := models.Position{X: x, Y: y}
+			if terrain := catalog.terrainAt(district.ID, gridSize, position); terrain != "" {
+				tiles = append(tiles, &TerrainTile{Position: position, Terrain: terrain})
+			}
+		}
+	}
+
+	return tiles, nil
+}
+
+// terrainAt returns the terrain of a district cell, or an empty string for
+// open ground and cells outside the district's grid. Terrain is derived from
+// the district ID and the cell, so it never has to be stored and is the same
+// on every replica.
+func (c *Catalog) terrainAt(districtID uuid.UUID, gridSize int, position models.Position) string {
+	if !inGrid(position, gridSize) || position == starterTownHallPosition || len(c.Terrain) == 0 {
+		return ""
+	}
+
+	h := fnv.New64a()
+	h.Write(districtID[:])
+	binary.Write(h, binary.BigEndian, int32(position.X))
+	binary.Write(h, binary.BigEndian, int32(position.Y))
+	roll := float64(h.Sum64()%10000) / 10000
+
+	terrains := make([]string, 0, len(c.Terrain))
+	for terrain := range c.Terrain {
+		terrains = append(terrains, terrain)
+	}
+	sort.Strings(terrains)
+
+	for _, terrain := range terrains {
+		if roll < c.Terrain[terrain] {
+			return terrain
+		}
+		roll -= c.Terrain[terrain]
+	}
+	return ""
+}
+
+// adjacencyBonus returns the production bonus of a building type at a
+// position from the buildings and terrain around it, with the rules that
+// matched. Only neighbours inside the district's grid count. The building
+// with ID self is ignored, so moves can be previewed.
+func (c *Catalog) adjacencyBonus(districtID uuid.UUID, gridSize int, buildingType models.BuildingType, position models.Position, buildings []*models.Building, self uuid.UUID) (float64, []*AdjacencyMatch) {
+	var total float64
+	matches := []*AdjacencyMatch{}
+
+	for i := range c.Adjacency {
+		rule := &c.Adjacency[i]
+		if rule.Building != buildingType {
+			continue
+		}
+
+		count := 0
+		for _, neighbor := range neighborPositions(position, gridSize) {
+			if rule.Terrain != "" {
+				if c.terrainAt(districtID, gridSize, neighbor) == rule.Terrain && buildingAt(buildings, neighbor, self) == nil {
+					count++
+				}
+				continue
+			}
+			if building := buildingAt(buildings, neighbor, self); building != nil && building.IsActive && building.Type == rule.Neighbor {
+				count++
+			}
+		}
+		if count == 0 {
+			continue
+		}
+
+		bonus := rule.Bonus * float64(count)
+		if rule.MaxBonus > 0 {
+			bonus = math.Min(bonus, rule.MaxBonus)
+		}
+		total += bonus
+		matches = append(matches, &AdjacencyMatch{
+			Neighbor: rule.Neighbor,
+			Terrain:  rule.Terrain,
+			Count:    count,
+			Bonus:    bonus,
+		})
+	}
+
+	return total, matches
+}
+
+// neighborPositions returns the up to 8 cells around a position that lie
+// inside a grid
+func neighborPositions(position models.Position, gridSize int) []models.Position {
+	neighbors := make([]models.Position, 0, 8)
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			neighbor := models.Position{X: position.X + dx, Y: position.Y + dy}
+			if (dx == 0 && dy == 0) || !inGrid(neighbor, gridSize) {
+				continue
+			}
+			neighbors = append(neighbors, neighbor)
+		}
+	}
+	return neighbors
+}
+
+// inGrid reports whether a position lies on a district grid of a size
+func inGrid(position models.Position, gridSize int) bool {
+	return position.X >= 0 && position.X < gridSize && position.Y >= 0 && position.Y < gridSize
+}
+
+func buildingAt(buildings []*models.Building, position models.Position, skip uuid.UUID) *models.Building {
+	for _, building := range buildings {
+		if building.Position == position && building.ID != skip {
+			return building
+		}
+	}
+	return nil
+}
+
+type PlacementPreviewRequest struct {
+	Type       models.BuildingType
+	BuildingID uuid.UUID
+	Position   models.Position
+}
+
+type PlacementPreview struct {
+	Type     models.BuildingType `json:"type"`
+	Position models.Position     `json:"position"`
+	Valid    bool                `json:"valid"`
+	Error    string              `json:"error,omitempty"`
+	// Production bonus, e.g. 0.15 for +15%
+	Bonus   float64           `json:"bonus"`
+	Matches []*AdjacencyMatch `json:"matches"`
+}
+
+type AdjacencyMatch struct {
+	Neighbor models.BuildingType `json:"neighbor,omitempty"`
+	Terrain  string              `json:"terrain,omitempty"`
+	Count    int                 `json:"count"`
+	Bonus    float64             `json:"bonus"`
+}
+
+type TerrainTile struct {
+	Position models.Position `json:"position"`
+	Terrain  string          `json:"terrain"`
+}
@@ -0,0 +1,248 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/ton-empire/backend/pkg/logger"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// Army operations
+
+const trainingBatchSize = 100
+
+// GetArmy returns the troops of a user's district and its training queue
+func (s *Service) GetArmy(ctx context.Context, userID uuid.UUID) (*Army, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	// Complete finished training the scheduler hasn't picked up yet
+	if _, err := s.completeTraining(ctx, district.ID, trainingBatchSize); err != nil {
+		logger.Errorf("Failed to complete training for district %s: %v", district.ID, err)
+	}
+
+	return s.getArmy(ctx, district.ID)
+}
+
+// TrainTroops pays for troops and queues their training at the Barracks.
+// Orders are trained one after another, so an order starts when the
+// previous one completes.
+func (s *Service) TrainTroops(ctx context.Context, userID uuid.UUID, req TrainTroopsRequest) (*Army, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	catalog := s.catalog.Get()
+	spec, err := catalog.Troop(req.TroopType)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+
+		buildings, err := s.repo.GetBuildingsByDistrictTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		if barracksLevel(buildings) < spec.BarracksLevel {
+			return fmt.Errorf("%s needs a working Barracks at level %d", spec.Name, spec.BarracksLevel)
+		}
+
+		queue, err := s.repo.GetTrainingQueueTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		if len(queue) >= catalog.Army.MaxQueueLength {
+			return fmt.Errorf("training queue is full")
+		}
+
+		troops, err := s.repo.GetTroopsTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		capacity := catalog.ArmyCapacity(buildings)
+		if used := armyUsed(troops, queue); used+req.Count > capacity {
+			return fmt.Errorf("your barracks hold %d more troops", max(capacity-used, 0))
+		}
+
+		cost := make(map[models.ResourceType]int64, len(spec.Cost))
+		for resourceType, amount := range spec.Cost {
+			cost[resourceType] = amount * int64(req.Count)
+		}
+
+		now := time.Now()
+		order := &TrainingOrder{
+			ID:         uuid.New(),
+			DistrictID: district.ID,
+			TroopType:  req.TroopType,
+			Count:      req.Count,
+			Cost:       cost,
+			StartedAt:  now,
+			CreatedAt:  now,
+		}
+		if len(queue) > 0 && queue[len(queue)-1].CompletesAt.After(now) {
+			order.StartedAt = queue[len(queue)-1].CompletesAt
+		}
+		order.CompletesAt = order.StartedAt.Add(spec.Duration * time.Duration(req.Count))
+
+		if _, err := s.spendResourcesTx(ctx, tx, district.ID, cost, LedgerReasonTraining,
+			LedgerSource{Type: LedgerSourceTrainingOrder, ID: order.ID}); err != nil {
+			return err
+		}
+		if err := s.repo.AddTrainingOrderTx(ctx, tx, order); err != nil {
+			return fmt.Errorf("failed to queue training: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getArmy(ctx, district.ID)
+}
+
+// CompleteDueTraining adds up to limit finished training orders across all
+// districts to their armies and returns how many were completed
+func (s *Service) CompleteDueTraining(ctx context.Context, limit int) (int, error) {
+	return s.completeTraining(ctx, uuid.Nil, limit)
+}
+
+func (s *Service) completeTraining(ctx context.Context, districtID uuid.UUID, limit int) (int, error) {
+	var completed []*DueTraining
+
+	err := s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		due, err := s.repo.LockDueTraining(ctx, tx, districtID, time.Now(), limit)
+		if err != nil {
+			return err
+		}
+
+		for _, training := range due {
+			order := training.Order
+			if err := s.repo.AddTroopsTx(ctx, tx, order.DistrictID, order.TroopType, order.Count); err != nil {
+				return fmt.Errorf("failed to add troops of training order %s: %w", order.ID, err)
+			}
+			if err := s.repo.DeleteTrainingOrderTx(ctx, tx, order.ID); err != nil {
+				return fmt.Errorf("failed to complete training order %s: %w", order.ID, err)
+			}
+		}
+
+		completed = due
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, training := range completed {
+		if err := s.events.PublishToUser(ctx, training.OwnerID, EventTrainingComplete, training.Order); err != nil {
+			logger.Errorf("Failed to publish training completion for order %s: %v", training.Order.ID, err)
+		}
+	}
+
+	return len(completed), nil
+}
+
+func (s *Service) getArmy(ctx context.Context, districtID uuid.UUID) (*Army, error) {
+	buildings, err := s.repo.GetBuildingsByDistrict(ctx, districtID)
+	if err != nil {
+		return nil, err
+	}
+	troops, err := s.repo.GetTroops(ctx, districtID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get troops: %w", err)
+	}
+	training, err := s.repo.GetTrainingQueue(ctx, districtID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get training queue: %w", err)
+	}
+
+	catalog := s.catalog.Get()
+	attack, _ := catalog.BattlePower(nil, troops)
+	army := &Army{
+		Troops:         troops,
+		Training:       training,
+		Capacity:       catalog.ArmyCapacity(buildings),
+		Used:           armyUsed(troops, training),
+		MaxQueueLength: catalog.Army.MaxQueueLength,
+		Attack:         attack,
+		FoodUpkeep:     catalog.ArmyFoodUpkeep(troops),
+	}
+	if army.Training == nil {
+		army.Training = []*TrainingOrder{}
+	}
+
+	return army, nil
+}
+
+// barracksLevel returns the level of a district's highest working Barracks
+func barracksLevel(buildings []*models.Building) int {
+	level := 0
+	for _, building := range buildings {
+		if building.Type == models.BuildingBarracks && building.IsActive {
+			level = max(level, building.Level)
+		}
+	}
+	return level
+}
+
+// armyUsed returns how much of a district's troop capacity its trained and
+// training troops take up
+func armyUsed(troops map[string]int, queue []*TrainingOrder) int {
+	used := 0
+	for _, count := range troops {
+		used += count
+	}
+	for _, order := range queue {
+		used += order.Count
+	}
+	return used
+}
+
+// DueTraining is a training order whose timer has run out
+type DueTraining struct {
+	Order   *TrainingOrder
+	OwnerID uuid.UUID
+}
+
+// TrainingOrder is a batch of troops in training. Its cost is paid when the
+// order is placed.
+type TrainingOrder struct {
+	ID          uuid.UUID                     `json:"id"`
+	DistrictID  uuid.UUID                     `json:"district_id"`
+	TroopType   string                        `json:"troop_type"`
+	Count       int                           `json:"count"`
+	Cost        map[models.ResourceType]int64 `json:"cost"`
+	StartedAt   time.Time                     `json:"started_at"`
+	CompletesAt time.Time                     `json:"completes_at"`
+	CreatedAt   time.Time                     `json:"created_at"`
+}
+
+// Army is the roster of a district: its trained troops by type and the
+// troops in training
+type Army struct {
+	Troops   map[string]int   `json:"troops"`
+	Training []*TrainingOrder `json:"training"`
+	// Troops the district's buildings hold, and how many of them are
+	// trained or in training
+	Capacity       int `json:"capacity"`
+	Used           int `json:"used"`
+	MaxQueueLength int `json:"max_queue_length"`
+	// Attack of the trained troops and the food they eat per hour
+	Attack     float64 `json:"attack"`
+	FoodUpkeep int64   `json:"food_upkeep"`
+}
+
+type TrainTroopsRequest struct {
+	TroopType string `json:"troop_type" binding:"required"`
+	Count     int    `json:"count" binding:"required,min=1,max=10000"`
+}
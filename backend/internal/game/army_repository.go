@@ -0,0 +1,175 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// Army operations
+
+// GetTroops returns how many troops of each type a district has
+func (r *Repository) GetTroops(ctx context.Context, districtID uuid.UUID) (map[string]int, error) {
+	return getTroops(ctx, r.db, districtID)
+}
+
+// GetTroopsTx reads the troops of a district as part of a transaction
+func (r *Repository) GetTroopsTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID) (map[string]int, error) {
+	return getTroops(ctx, tx, districtID)
+}
+
+func getTroops(ctx context.Context, q sqlx.QueryerContext, districtID uuid.UUID) (map[string]int, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT troop_type, count FROM district_troops WHERE district_id = $1 AND count > 0`,
+		districtID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	troops := make(map[string]int)
+	for rows.Next() {
+		var troopType string
+		var count int
+		if err := rows.Scan(&troopType, &count); err != nil {
+			return nil, err
+		}
+		troops[troopType] = count
+	}
+
+	return troops, rows.Err()
+}
+
+// AddTroopsTx adds trained troops to a district as part of a transaction
+func (r *Repository) AddTroopsTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID, troopType string, count int) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO district_troops (district_id, troop_type, count)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (district_id, troop_type) DO UPDATE
+		SET count = district_troops.count + EXCLUDED.count`,
+		districtID, troopType, count)
+	return err
+}
+
+// RemoveTroopsTx takes the given troops out of a district's army as part of
+// a transaction. Counts are lowered rather than overwritten, so troops that
+// finish training meanwhile aren't lost.
+func (r *Repository) RemoveTroopsTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID, troops map[string]int) error {
+	for troopType, count := range troops {
+		if count == 0 {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, `
+			UPDATE district_troops SET count = count - $3
+			WHERE district_id = $1 AND troop_type = $2`,
+			districtID, troopType, count)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repository) GetTrainingQueue(ctx context.Context, districtID uuid.UUID) ([]*TrainingOrder, error) {
+	return getTrainingQueue(ctx, r.db, districtID)
+}
+
+// GetTrainingQueueTx reads the training queue as part of a transaction
+func (r *Repository) GetTrainingQueueTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID) ([]*TrainingOrder, error) {
+	return getTrainingQueue(ctx, tx, districtID)
+}
+
+func getTrainingQueue(ctx context.Context, q sqlx.QueryerContext, districtID uuid.UUID) ([]*TrainingOrder, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, district_id, troop_type, count, cost, started_at, completes_at, created_at
+		FROM troop_training
+		WHERE district_id = $1
+		ORDER BY completes_at`,
+		districtID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []*TrainingOrder
+	for rows.Next() {
+		order, err := scanTrainingOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, rows.Err()
+}
+
+func (r *Repository) AddTrainingOrderTx(ctx context.Context, tx *sqlx.Tx, order *TrainingOrder) error {
+	cost, err := json.Marshal(order.Cost)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO troop_training (id, district_id, troop_type, count, cost, started_at, completes_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		order.ID, order.DistrictID, order.TroopType, order.Count, cost,
+		order.StartedAt, order.CompletesAt, order.CreatedAt)
+	return err
+}
+
+func (r *Repository) DeleteTrainingOrderTx(ctx context.Context, tx *sqlx.Tx, orderID uuid.UUID) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM troop_training WHERE id = $1`, orderID)
+	return err
+}
+
+// LockDueTraining returns training orders that finished before now, locked
+// until tx ends. Rows locked by another game-service replica are skipped, so
+// every order is completed exactly once. Passing uuid.Nil as districtID
+// selects orders from all districts.
+func (r *Repository) LockDueTraining(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID, now time.Time, limit int) ([]*DueTraining, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT t.id, t.district_id, t.troop_type, t.count, t.cost,
+		       t.started_at, t.completes_at, t.created_at, d.owner_id
+		FROM troop_training t
+		JOIN districts d ON d.id = t.district_id
+		WHERE t.completes_at <= $1
+		  AND ($2 = '00000000-0000-0000-0000-000000000000'::uuid OR t.district_id = $2)
+		ORDER BY t.completes_at
+		LIMIT $3
+		FOR UPDATE OF t SKIP LOCKED`,
+		now, districtID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []*DueTraining
+	for rows.Next() {
+		var ownerID uuid.UUID
+		order, err := scanTrainingOrder(rows, &ownerID)
+		if err != nil {
+			return nil, err
+		}
+		due = append(due, &DueTraining{Order: order, OwnerID: ownerID})
+	}
+
+	return due, rows.Err()
+}
+
+func scanTrainingOrder(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*TrainingOrder, error) {
+	var order TrainingOrder
+	var cost []byte
+	dest := append([]interface{}{&order.ID, &order.DistrictID, &order.TroopType, &order.Count,
+		&cost, &order.StartedAt, &order.CompletesAt, &order.CreatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(cost, &order.Cost); err != nil {
+		return nil, fmt.Errorf("failed to decode training order cost: %w", err)
+	}
+	return &order, nil
+}
@@ -0,0 +1,515 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/ton-empire/backend/internal/common/metrics"
+	"github.com/ton-empire/backend/internal/game/battlesim"
+	"github.com/ton-empire/backend/pkg/logger"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// Battle operations
+
+const (
+	battleHistoryLimit = 20
+	maxPlayerLevel     = 100
+)
+
+// SearchTargets finds players to attack whose power is within the catalog's
+// power band of the attacker's, narrowed down by the request. Shielded
+// players are never offered.
+func (s *Service) SearchTargets(ctx context.Context, userID uuid.UUID, req SearchTargetsRequest) ([]*BattleTarget, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+	buildings, err := s.repo.GetBuildingsByDistrict(ctx, district.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get buildings: %w", err)
+	}
+	troops, err := s.repo.GetTroops(ctx, district.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get troops: %w", err)
+	}
+	guildID, err := s.repo.GetUserGuildID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get guild: %w", err)
+	}
+
+	catalog := s.catalog.Get()
+	minPower, maxPower := catalog.powerBand(buildings, troops)
+
+	query := TargetQuery{
+		UserID:        userID,
+		GuildID:       guildID,
+		Weights:       catalog.Battle.Defense,
+		TroopWeights:  catalog.troopWeights(),
+		MinPower:      minPower,
+		MaxPower:      maxPower,
+		MinLevel:      max(req.MinLevel, 1),
+		MaxLevel:      maxPlayerLevel,
+		AttackedAfter: time.Now().Add(-catalog.Battle.Cooldown),
+		ShieldedAt:    time.Now(),
+		Limit:         catalog.Battle.SearchLimit,
+	}
+	if req.MaxLevel > 0 {
+		query.MaxLevel = req.MaxLevel
+	}
+	query.MinPower = max(query.MinPower, req.MinPower)
+	if req.MaxPower > 0 {
+		query.MaxPower = min(query.MaxPower, req.MaxPower)
+	}
+
+	targets, err := s.repo.FindBattleTargets(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search targets: %w", err)
+	}
+	if targets == nil {
+		targets = []*BattleTarget{}
+	}
+	return targets, nil
+}
+
+// Attack resolves an attack with the attacker's whole army on another player
+// right away. Fallen troops are lost and the defender's buildings take the
+// damage dealt; if the attacker wins, they also loot the defender's resources.
+func (s *Service) Attack(ctx context.Context, attackerID, defenderID uuid.UUID) (*Battle, error) {
+	if attackerID == defenderID {
+		return nil, fmt.Errorf("you can't attack yourself")
+	}
+
+	attackerDistrict, err := s.repo.GetDistrictByUserID(ctx, attackerID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+	defenderDistrict, err := s.repo.GetDistrictByUserID(ctx, defenderID)
+	if err != nil {
+		return nil, fmt.Errorf("target district not found: %w", err)
+	}
+
+	attackerGuild, err := s.repo.GetUserGuildID(ctx, attackerID)
+	if err != nil {
+		return nil, err
+	}
+	defenderGuild, err := s.repo.GetUserGuildID(ctx, defenderID)
+	if err != nil {
+		return nil, err
+	}
+	if attackerGuild != nil && defenderGuild != nil && *attackerGuild == *defenderGuild {
+		return nil, fmt.Errorf("you can't attack a member of your guild")
+	}
+
+	catalog := s.catalog.Get()
+	battle := &Battle{
+		ID:                 uuid.New(),
+		AttackerID:         attackerID,
+		DefenderID:         defenderID,
+		AttackerDistrictID: attackerDistrict.ID,
+		DefenderDistrictID: defenderDistrict.ID,
+		Loot:               map[models.ResourceType]int64{},
+		Seed:               rand.Int63(),
+		CreatedAt:          time.Now(),
+	}
+
+	var damaged []*models.Building
+	var war *GuildWar
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.lockDistrictsTx(ctx, tx, attackerDistrict.ID, defenderDistrict.ID); err != nil {
+			return err
+		}
+
+		shield, err := s.repo.GetShieldTx(ctx, tx, defenderDistrict.ID)
+		if err != nil {
+			return err
+		}
+		if isShielded(shield, battle.CreatedAt) {
+			return fmt.Errorf("this player is protected by a shield until %s", shield.Format(time.RFC3339))
+		}
+
+		last, err := s.repo.GetLastAttackTx(ctx, tx, attackerID, defenderID)
+		if err != nil {
+			return err
+		}
+		if last != nil && battle.CreatedAt.Sub(*last) < catalog.Battle.Cooldown {
+			wait := catalog.Battle.Cooldown - battle.CreatedAt.Sub(*last)
+			return fmt.Errorf("you can attack this player again in %s", wait.Round(time.Second))
+		}
+
+		attackerBuildings, err := s.repo.GetBuildingsByDistrictTx(ctx, tx, attackerDistrict.ID)
+		if err != nil {
+			return err
+		}
+		defenderBuildings, err := s.repo.GetBuildingsByDistrictTx(ctx, tx, defenderDistrict.ID)
+		if err != nil {
+			return err
+		}
+		troops, err := s.repo.GetTroopsTx(ctx, tx, attackerDistrict.ID)
+		if err != nil {
+			return err
+		}
+		defenderTroops, err := s.repo.GetTroopsTx(ctx, tx, defenderDistrict.ID)
+		if err != nil {
+			return err
+		}
+
+		// Only targets a search could offer may be attacked, whatever the
+		// powers were when the attacker searched
+		minPower, maxPower := catalog.powerBand(attackerBuildings, troops)
+		if attack, defense := catalog.BattlePower(defenderBuildings, defenderTroops); attack+defense < minPower || attack+defense > maxPower {
+			return fmt.Errorf("this player's power is out of your range of %.0f to %.0f", minPower, maxPower)
+		}
+
+		battle.Attack, _ = catalog.BattlePower(nil, troops)
+		_, battle.Defense = catalog.BattlePower(defenderBuildings, nil)
+
+		input := catalog.battleInput(battle.Seed, troops, defenderBuildings)
+		if armySize(input.Army) == 0 {
+			return fmt.Errorf("you have no troops to attack with")
+		}
+		result, err := battlesim.Run(input)
+		if err != nil {
+			return fmt.Errorf("failed to simulate battle: %w", err)
+		}
+		battle.Ticks = result.Ticks
+		battle.Destruction = result.Destruction
+
+		survivors := make(map[string]int, len(result.Army))
+		for _, unit := range result.Army {
+			survivors[unit.Type] = unit.Count
+		}
+		if err := s.repo.RemoveTroopsTx(ctx, tx, attackerDistrict.ID, fallenTroops(troops, survivors)); err != nil {
+			return fmt.Errorf("failed to update troops: %w", err)
+		}
+
+		if damaged, err = s.applyBattleDamageTx(ctx, tx, battle, input.Structures, result.Structures); err != nil {
+			return err
+		}
+		battle.WinnerID = defenderID
+		if result.AttackerWon {
+			battle.WinnerID = attackerID
+			if err := s.lootTx(ctx, tx, battle, attackerBuildings); err != nil {
+				return err
+			}
+		}
+
+		// Attacking drops the attacker's shield, being attacked shields the
+		// defender for a while. The dropped shield expires rather than being
+		// cleared, so the district isn't given a beginner shield again.
+		if err := s.repo.SetShieldTx(ctx, tx, attackerDistrict.ID, &battle.CreatedAt); err != nil {
+			return fmt.Errorf("failed to drop shield: %w", err)
+		}
+		if _, err := s.grantShieldTx(ctx, tx, defenderDistrict.ID, battle.CreatedAt.Add(catalog.Shield.AfterAttack)); err != nil {
+			return err
+		}
+
+		// Battles between guilds at war score points for the winner's guild
+		if attackerGuild != nil && defenderGuild != nil {
+			if war, err = s.scoreGuildWarTx(ctx, tx, battle, *attackerGuild, *defenderGuild); err != nil {
+				return err
+			}
+		}
+
+		if err := s.repo.CreateBattleTx(ctx, tx, battle, &input, battlesim.EncodeLog(result.Events)); err != nil {
+			return fmt.Errorf("failed to record battle: %w", err)
+		}
+		if err := s.repo.AddBattleStatsTx(ctx, tx, attackerID, battle.WinnerID == attackerID); err != nil {
+			return fmt.Errorf("failed to update stats: %w", err)
+		}
+		if err := s.repo.AddBattleStatsTx(ctx, tx, defenderID, battle.WinnerID == defenderID); err != nil {
+			return fmt.Errorf("failed to update stats: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.BattlesTotal.Inc()
+	logger.Infof("Battle %s: %s attacked %s, winner %s", battle.ID, attackerID, defenderID, battle.WinnerID)
+
+	s.notifyBattle(ctx, battle)
+	for _, building := range damaged {
+		s.notifyBuildingUpdate(ctx, defenderID, building)
+	}
+	if war != nil {
+		s.notifyGuildWar(ctx, EventGuildWarScore, war)
+	}
+
+	return battle, nil
+}
+
+// powerBand returns the range of power a player with the given buildings and
+// troops may attack
+func (c *Catalog) powerBand(buildings []*models.Building, troops map[string]int) (minPower, maxPower float64) {
+	attack, defense := c.BattlePower(buildings, troops)
+	power := attack + defense
+	return power * (1 - c.Battle.PowerBand), power * (1 + c.Battle.PowerBand)
+}
+
+// GetBattles returns the latest battles of a user, newest first
+func (s *Service) GetBattles(ctx context.Context, userID uuid.UUID) ([]*Battle, error) {
+	battles, err := s.repo.GetUserBattles(ctx, userID, battleHistoryLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get battles: %w", err)
+	}
+	if battles == nil {
+		battles = []*Battle{}
+	}
+	return battles, nil
+}
+
+// GetBattleReplay returns the input and the tick-by-tick events of a battle,
+// so one of its sides can watch it again
+func (s *Service) GetBattleReplay(ctx context.Context, userID, battleID uuid.UUID) (*BattleReplay, error) {
+	battle, input, log, err := s.repo.GetBattle(ctx, battleID)
+	if err != nil {
+		return nil, err
+	}
+	if userID != battle.AttackerID && userID != battle.DefenderID {
+		return nil, fmt.Errorf("battle not found")
+	}
+	if input == nil || log == nil {
+		return nil, fmt.Errorf("battle has no replay")
+	}
+
+	events, err := battlesim.DecodeLog(log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode battle log: %w", err)
+	}
+
+	return &BattleReplay{
+		Battle:     battle,
+		Army:       input.Army,
+		Structures: input.Structures,
+		Events:     events,
+	}, nil
+}
+
+// lootTx moves the loot of a won battle from the defender to the attacker,
+// as much as the attacker can store
+func (s *Service) lootTx(ctx context.Context, tx *sqlx.Tx, battle *Battle, attackerBuildings []*models.Building) error {
+	catalog := s.catalog.Get()
+
+	defenderResources, err := s.repo.GetDistrictResourcesTx(ctx, tx, battle.DefenderDistrictID)
+	if err != nil {
+		return err
+	}
+	attackerResources, err := s.repo.GetDistrictResourcesTx(ctx, tx, battle.AttackerDistrictID)
+	if err != nil {
+		return err
+	}
+	capacity := catalog.StorageCapacity(attackerBuildings)
+
+	for _, resourceType := range resourceTypes {
+		amount := int64(math.Floor(float64(defenderResources[resourceType]) * catalog.Battle.LootRatio))
+		amount = min(amount, max(capacity[resourceType]-attackerResources[resourceType], 0))
+		if amount > 0 {
+			battle.Loot[resourceType] = amount
+		}
+	}
+
+	source := battleSource(battle.ID)
+	if _, err := s.spendResourcesTx(ctx, tx, battle.DefenderDistrictID, battle.Loot, LedgerReasonBattleLoot, source); err != nil {
+		return err
+	}
+	_, err = s.changeResourcesTx(ctx, tx, battle.AttackerDistrictID, battle.Loot, LedgerReasonBattleLoot, source)
+	return err
+}
+
+// applyBattleDamageTx stores the health the defender's buildings have left
+// after a battle and returns the buildings that took damage
+func (s *Service) applyBattleDamageTx(ctx context.Context, tx *sqlx.Tx, battle *Battle, before, after []battlesim.Structure) ([]*models.Building, error) {
+	var damaged []*models.Building
+	for i := range before {
+		lost := before[i].Health - after[i].Health
+		if lost == 0 {
+			continue
+		}
+
+		// Health is simulated in whole points, so a destroyed building
+		// takes whatever fraction it had left on top
+		damage := float64(lost)
+		if after[i].Health == 0 {
+			damage = float64(before[i].MaxHealth)
+		}
+		building, err := s.damageBuildingTx(ctx, tx, battle.DefenderDistrictID, before[i].ID, damage)
+		if err != nil {
+			return nil, err
+		}
+
+		if building.Health == 0 {
+			battle.BuildingsDestroyed++
+		} else {
+			battle.BuildingsDamaged++
+		}
+		damaged = append(damaged, building)
+	}
+
+	return damaged, nil
+}
+
+// lockDistrictsTx locks several districts in a fixed order, so transactions
+// locking the same districts can't deadlock
+func (s *Service) lockDistrictsTx(ctx context.Context, tx *sqlx.Tx, districtIDs ...uuid.UUID) error {
+	sorted := append([]uuid.UUID(nil), districtIDs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].String() < sorted[j].String()
+	})
+
+	for _, districtID := range sorted {
+		if err := s.repo.LockDistrictTx(ctx, tx, districtID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// battleInput sets up the simulation of an attack. Every troop of the
+// attacker marches and every building left standing defends.
+func (c *Catalog) battleInput(seed int64, troops map[string]int, defenderBuildings []*models.Building) battlesim.Input {
+	input := battlesim.Input{
+		Seed:           seed,
+		Army:           []battlesim.Unit{},
+		Structures:     []battlesim.Structure{},
+		MaxTicks:       c.Battle.MaxTicks,
+		Variance:       c.Battle.Variance,
+		WinDestruction: c.Battle.WinDestruction,
+	}
+
+	// Units are sorted by type, so the same army always sets up the same
+	// simulation
+	troopTypes := make([]string, 0, len(troops))
+	for troopType, count := range troops {
+		if _, ok := c.Troops[troopType]; ok && count > 0 {
+			troopTypes = append(troopTypes, troopType)
+		}
+	}
+	sort.Strings(troopTypes)
+	for _, troopType := range troopTypes {
+		spec := c.Troops[troopType]
+		input.Army = append(input.Army, battlesim.Unit{
+			Type:   troopType,
+			Count:  troops[troopType],
+			Attack: spec.Attack,
+			Health: spec.Health,
+		})
+	}
+
+	for _, building := range defenderBuildings {
+		if building.Health <= 0 {
+			continue
+		}
+
+		structure := battlesim.Structure{
+			ID:        building.ID,
+			Type:      string(building.Type),
+			Position:  building.Position,
+			Health:    int(math.Ceil(building.Health)),
+			MaxHealth: int(math.Ceil(building.MaxHealth)),
+			Wall:      building.Type == models.BuildingWall,
+		}
+		if building.IsActive {
+			structure.Defense = int(math.Round(c.Battle.Defense[building.Type] * float64(building.Level)))
+		}
+		input.Structures = append(input.Structures, structure)
+	}
+
+	return input
+}
+
+func armySize(army []battlesim.Unit) int {
+	size := 0
+	for _, unit := range army {
+		size += unit.Count
+	}
+	return size
+}
+
+// troopWeights returns the power every troop of a type adds
+func (c *Catalog) troopWeights() map[string]float64 {
+	weights := make(map[string]float64, len(c.Troops))
+	for troopType, spec := range c.Troops {
+		weights[troopType] = float64(spec.Attack)
+	}
+	return weights
+}
+
+func battleSource(battleID uuid.UUID) LedgerSource {
+	return LedgerSource{Type: LedgerSourceBattle, ID: battleID}
+}
+
+// Battle is the outcome of an attack
+type Battle struct {
+	ID                 uuid.UUID                     `json:"id"`
+	AttackerID         uuid.UUID                     `json:"attacker_id"`
+	DefenderID         uuid.UUID                     `json:"defender_id"`
+	AttackerDistrictID uuid.UUID                     `json:"attacker_district_id"`
+	DefenderDistrictID uuid.UUID                     `json:"defender_district_id"`
+	Attack             float64                       `json:"attack"`
+	Defense            float64                       `json:"defense"`
+	WinnerID           uuid.UUID                     `json:"winner_id"`
+	Loot               map[models.ResourceType]int64 `json:"loot"`
+	BuildingsDamaged   int                           `json:"buildings_damaged"`
+	BuildingsDestroyed int                           `json:"buildings_destroyed"`
+	// Seed of the simulation, how many ticks it ran and the share of the
+	// defender's building health destroyed
+	Seed        int64   `json:"seed"`
+	Ticks       int     `json:"ticks"`
+	Destruction float64 `json:"destruction"`
+	// War between the guilds of both sides the battle scored points in
+	GuildWarID *uuid.UUID `json:"guild_war_id,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// BattleReplay is everything the battle page needs to animate a battle.
+// Event sources and targets index into Army and Structures.
+type BattleReplay struct {
+	Battle     *Battle               `json:"battle"`
+	Army       []battlesim.Unit      `json:"army"`
+	Structures []battlesim.Structure `json:"structures"`
+	Events     []battlesim.Event     `json:"events"`
+}
+
+// BattleStarted announces a battle to both sides
+type BattleStarted struct {
+	BattleID uuid.UUID    `json:"battle_id"`
+	Attacker BattlePlayer `json:"attacker"`
+	Defender BattlePlayer `json:"defender"`
+}
+
+type BattlePlayer struct {
+	ID       uuid.UUID `json:"id"`
+	Username string    `json:"username"`
+}
+
+// BattleTarget is a player offered by a target search
+type BattleTarget struct {
+	UserID       uuid.UUID          `json:"user_id"`
+	Username     string             `json:"username"`
+	Level        int                `json:"level"`
+	Power        float64            `json:"power"`
+	DistrictName string             `json:"district_name"`
+	Guild        *BattleTargetGuild `json:"guild"`
+	LastBattle   *time.Time         `json:"last_battle"`
+}
+
+type BattleTargetGuild struct {
+	Name string `json:"name"`
+	Tag  string `json:"tag"`
+}
+
+// SearchTargetsRequest narrows down a target search. Zero values don't filter.
+type SearchTargetsRequest struct {
+	MinLevel int     `json:"min_level" binding:"omitempty,min=1,max=100"`
+	MaxLevel int     `json:"max_level" binding:"omitempty,min=1,max=100"`
+	MinPower float64 `json:"min_power" binding:"omitempty,min=0"`
+	MaxPower float64 `json:"max_power" binding:"omitempty,min=0"`
+}
@@ -0,0 +1,250 @@
+package game
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/ton-empire/backend/internal/game/battlesim"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// Battle operations
+
+// FindBattleTargets returns up to query.Limit random players matching query.
+// A district's power is the level of each active building weighted by
+// query.Weights plus its troops weighted by query.TroopWeights.
+func (r *Repository) FindBattleTargets(ctx context.Context, query TargetQuery) ([]*BattleTarget, error) {
+	types, weights := powerWeights(query.Weights, query.TroopWeights)
+	rows, err := r.db.QueryContext(ctx, `
+		WITH weights (type, weight) AS (
+		    SELECT * FROM unnest($1::text[], $2::float8[])
+		), power AS (
+		    SELECT district_id, SUM(power) AS power
+		    FROM (
+		        SELECT b.district_id, b.level * w.weight AS power
+		        FROM buildings b
+		        JOIN weights w ON w.type = 'building:' || b.type
+		        WHERE b.is_active
+		        UNION ALL
+		        SELECT t.district_id, t.count * w.weight
+		        FROM district_troops t
+		        JOIN weights w ON w.type = 'troop:' || t.troop_type
+		    ) p
+		    GROUP BY district_id
+		)
+		SELECT u.id, u.username, u.level, d.name, COALESCE(p.power, 0),
+		       g.name, g.tag,
+		       (SELECT MAX(bt.created_at) FROM battles bt WHERE bt.defender_id = u.id)
+		FROM districts d
+		JOIN users u ON u.id = d.owner_id
+		LEFT JOIN power p ON p.district_id = d.id
+		LEFT JOIN guilds g ON g.id = u.guild_id
+		WHERE u.id <> $3
+		  AND ($4::uuid IS NULL OR u.guild_id IS DISTINCT FROM $4)
+		  AND COALESCE(p.power, 0) BETWEEN $5 AND $6
+		  AND u.level BETWEEN $7 AND $8
+		  AND NOT EXISTS (
+		      SELECT 1 FROM battles bt
+		      WHERE bt.attacker_id = $3 AND bt.defender_id = u.id AND bt.created_at > $9
+		  )
+		  AND (d.shield_until IS NULL OR d.shield_until <= $11)
+		ORDER BY random()
+		LIMIT $10`,
+		pq.Array(types), pq.Array(weights), query.UserID, query.GuildID,
+		query.MinPower, query.MaxPower, query.MinLevel, query.MaxLevel,
+		query.AttackedAfter, query.Limit, query.ShieldedAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []*BattleTarget
+	for rows.Next() {
+		var target BattleTarget
+		var guildName, guildTag sql.NullString
+		err := rows.Scan(&target.UserID, &target.Username, &target.Level, &target.DistrictName,
+			&target.Power, &guildName, &guildTag, &target.LastBattle)
+		if err != nil {
+			return nil, err
+		}
+		if guildName.Valid {
+			target.Guild = &BattleTargetGuild{Name: guildName.String, Tag: guildTag.String}
+		}
+		targets = append(targets, &target)
+	}
+
+	return targets, rows.Err()
+}
+
+// powerWeights flattens building and troop weights into the type and weight
+// arrays the power queries unnest. Types are prefixed with "building:" or
+// "troop:".
+func powerWeights(buildingWeights map[models.BuildingType]float64, troopWeights map[string]float64) ([]string, []float64) {
+	types := make([]string, 0, len(buildingWeights)+len(troopWeights))
+	weights := make([]float64, 0, len(buildingWeights)+len(troopWeights))
+	for buildingType, weight := range buildingWeights {
+		types = append(types, "building:"+string(buildingType))
+		weights = append(weights, weight)
+	}
+	for troopType, weight := range troopWeights {
+		types = append(types, "troop:"+troopType)
+		weights = append(weights, weight)
+	}
+	return types, weights
+}
+
+// GetLastAttackTx returns when an attacker last attacked a defender, or nil
+func (r *Repository) GetLastAttackTx(ctx context.Context, tx *sqlx.Tx, attackerID, defenderID uuid.UUID) (*time.Time, error) {
+	var at *time.Time
+	err := tx.QueryRowContext(ctx,
+		`SELECT MAX(created_at) FROM battles WHERE attacker_id = $1 AND defender_id = $2`,
+		attackerID, defenderID).Scan(&at)
+	return at, err
+}
+
+const battleColumns = `
+	id, attacker_id, defender_id, attacker_district_id, defender_district_id,
+	attack, defense, winner_id, loot, buildings_damaged, buildings_destroyed,
+	seed, ticks, destruction, guild_war_id, created_at`
+
+// CreateBattleTx stores a battle with the input of its simulation and its
+// encoded event log as part of a transaction
+func (r *Repository) CreateBattleTx(ctx context.Context, tx *sqlx.Tx, battle *Battle, setup *battlesim.Input, log []byte) error {
+	loot, err := json.Marshal(battle.Loot)
+	if err != nil {
+		return err
+	}
+	setupJSON, err := json.Marshal(setup)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO battles (id, attacker_id, defender_id, attacker_district_id, defender_district_id,
+		                     attack, defense, winner_id, loot, buildings_damaged, buildings_destroyed,
+		                     seed, ticks, destruction, guild_war_id, setup, log, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)`,
+		battle.ID, battle.AttackerID, battle.DefenderID, battle.AttackerDistrictID, battle.DefenderDistrictID,
+		battle.Attack, battle.Defense, battle.WinnerID, loot,
+		battle.BuildingsDamaged, battle.BuildingsDestroyed,
+		battle.Seed, battle.Ticks, battle.Destruction, battle.GuildWarID, setupJSON, log, battle.CreatedAt)
+	return err
+}
+
+// GetBattle returns a battle with the input of its simulation and its
+// encoded event log. Battles fought before replays were recorded have neither.
+func (r *Repository) GetBattle(ctx context.Context, battleID uuid.UUID) (*Battle, *battlesim.Input, []byte, error) {
+	var setupJSON, log []byte
+	row := r.db.QueryRowContext(ctx,
+		`SELECT `+battleColumns+`, setup, log FROM battles WHERE id = $1`, battleID)
+	battle, err := scanBattle(row, &setupJSON, &log)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil, fmt.Errorf("battle not found")
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var setup *battlesim.Input
+	if setupJSON != nil {
+		if err := json.Unmarshal(setupJSON, &setup); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to decode battle setup: %w", err)
+		}
+	}
+	return battle, setup, log, nil
+}
+
+// GetUserBattles returns the latest battles a user attacked or defended in,
+// newest first
+func (r *Repository) GetUserBattles(ctx context.Context, userID uuid.UUID, limit int) ([]*Battle, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+battleColumns+`
+		FROM battles
+		WHERE attacker_id = $1 OR defender_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2`,
+		userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var battles []*Battle
+	for rows.Next() {
+		battle, err := scanBattle(rows)
+		if err != nil {
+			return nil, err
+		}
+		battles = append(battles, battle)
+	}
+
+	return battles, rows.Err()
+}
+
+func scanBattle(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*Battle, error) {
+	var battle Battle
+	var loot []byte
+	dest := append([]interface{}{&battle.ID, &battle.AttackerID, &battle.DefenderID,
+		&battle.AttackerDistrictID, &battle.DefenderDistrictID, &battle.Attack, &battle.Defense,
+		&battle.WinnerID, &loot, &battle.BuildingsDamaged, &battle.BuildingsDestroyed,
+		&battle.Seed, &battle.Ticks, &battle.Destruction, &battle.GuildWarID, &battle.CreatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(loot, &battle.Loot); err != nil {
+		return nil, fmt.Errorf("failed to decode battle loot: %w", err)
+	}
+	return &battle, nil
+}
+
+// AddBattleStatsTx counts a battle in a user's stats as part of a transaction
+func (r *Repository) AddBattleStatsTx(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID, won bool) error {
+	wins := 0
+	if won {
+		wins = 1
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO user_stats (user_id, total_battles, battles_won)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET total_battles = user_stats.total_battles + 1,
+		    battles_won = user_stats.battles_won + EXCLUDED.battles_won,
+		    updated_at = CURRENT_TIMESTAMP`,
+		userID, wins)
+	return err
+}
+
+// GetUsername returns the username of a user
+func (r *Repository) GetUsername(ctx context.Context, userID uuid.UUID) (string, error) {
+	var username string
+	err := r.db.QueryRowContext(ctx, `SELECT username FROM users WHERE id = $1`, userID).Scan(&username)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("user not found")
+	}
+	return username, err
+}
+
+// TargetQuery filters the players offered as battle targets
+type TargetQuery struct {
+	UserID uuid.UUID
+	// Members of this guild are left out
+	GuildID      *uuid.UUID
+	Weights      map[models.BuildingType]float64
+	TroopWeights map[string]float64
+	MinPower     float64
+	MaxPower     float64
+	MinLevel     int
+	MaxLevel     int
+	// Players the user attacked after this time are left out
+	AttackedAfter time.Time
+	// Players shielded at this time are left out
+	ShieldedAt time.Time
+	Limit      int
+}
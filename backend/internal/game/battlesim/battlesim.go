@@ -0,0 +1,260 @@
+// Package battlesim simulates battles between an attacking army and the
+// buildings of a district. A simulation only depends on its input, so the
+// same input and seed always produce the same event log and outcome.
+package battlesim
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/google/uuid"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// Unit is a group of identical attacking troops
+type Unit struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+	// Damage a single troop deals per tick
+	Attack int `json:"attack"`
+	// Health of a single troop
+	Health int `json:"health"`
+}
+
+// Structure is a building of the defending district
+type Structure struct {
+	ID        uuid.UUID       `json:"id"`
+	Type      string          `json:"type"`
+	Position  models.Position `json:"position"`
+	Health    int             `json:"health"`
+	MaxHealth int             `json:"max_health"`
+	// Damage the structure deals to the army per tick
+	Defense int `json:"defense"`
+	// Walls are attacked before any other structure
+	Wall bool `json:"wall,omitempty"`
+}
+
+// Input is everything a simulation depends on
+type Input struct {
+	Seed       int64       `json:"seed"`
+	Army       []Unit      `json:"army"`
+	Structures []Structure `json:"structures"`
+	// The battle ends after this many ticks at the latest
+	MaxTicks int `json:"max_ticks"`
+	// Every hit deals between 100-Variance and 100+Variance percent of its
+	// base damage
+	Variance int `json:"variance"`
+	// Share of the structures' starting health the army must destroy to win
+	WinDestruction float64 `json:"win_destruction"`
+}
+
+// Result is the outcome of a simulation
+type Result struct {
+	AttackerWon bool `json:"attacker_won"`
+	Ticks       int  `json:"ticks"`
+	// Share of the structures' starting health destroyed
+	Destruction float64 `json:"destruction"`
+	// Surviving troops and structure health after the battle
+	Army       []Unit      `json:"army"`
+	Structures []Structure `json:"structures"`
+	Events     []Event     `json:"events"`
+}
+
+// Validate checks that an input can be simulated
+func (in *Input) Validate() error {
+	if in.MaxTicks < 1 {
+		return fmt.Errorf("max ticks must be at least 1")
+	}
+	if in.Variance < 0 || in.Variance > 100 {
+		return fmt.Errorf("variance must be between 0 and 100")
+	}
+	if in.WinDestruction < 0 || in.WinDestruction > 1 {
+		return fmt.Errorf("win destruction must be between 0 and 1")
+	}
+	for _, unit := range in.Army {
+		if unit.Count < 0 || unit.Attack < 0 || unit.Health < 1 {
+			return fmt.Errorf("unit %s: count and attack must not be negative and health must be at least 1", unit.Type)
+		}
+	}
+	for _, structure := range in.Structures {
+		if structure.Health < 0 || structure.Health > structure.MaxHealth || structure.Defense < 0 {
+			return fmt.Errorf("structure %s: invalid health or defense", structure.ID)
+		}
+	}
+	return nil
+}
+
+// Run simulates a battle tick by tick. Every tick the army attacks first:
+// each unit group keeps hitting its target until it falls, walls first and
+// then random structures. Then every standing structure hits a random unit
+// group. The battle ends when either side is wiped out or after MaxTicks.
+func Run(in Input) (*Result, error) {
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+
+	sim := &simulation{
+		rng:        rand.New(rand.NewSource(in.Seed)),
+		variance:   in.Variance,
+		army:       append([]Unit(nil), in.Army...),
+		structures: append([]Structure(nil), in.Structures...),
+		targets:    make([]int, len(in.Army)),
+		events:     []Event{},
+	}
+	sim.pool = make([]int, len(sim.army))
+	for i, unit := range sim.army {
+		sim.pool[i] = unit.Count * unit.Health
+		sim.targets[i] = -1
+	}
+
+	startHealth := 0
+	for _, structure := range sim.structures {
+		startHealth += structure.Health
+	}
+
+	ticks := 0
+	for ticks < in.MaxTicks && sim.armyStanding() && sim.structuresStanding() {
+		ticks++
+		sim.attack(ticks)
+		sim.defend(ticks)
+	}
+
+	result := &Result{
+		Ticks:      ticks,
+		Army:       sim.army,
+		Structures: sim.structures,
+		Events:     sim.events,
+	}
+	if startHealth > 0 {
+		endHealth := 0
+		for _, structure := range sim.structures {
+			endHealth += structure.Health
+		}
+		result.Destruction = float64(startHealth-endHealth) / float64(startHealth)
+	}
+	result.AttackerWon = startHealth > 0 && result.Destruction >= in.WinDestruction
+	return result, nil
+}
+
+type simulation struct {
+	rng        *rand.Rand
+	variance   int
+	army       []Unit
+	structures []Structure
+	// Health left in each unit group
+	pool []int
+	// Structure each unit group attacks, or -1
+	targets []int
+	events  []Event
+}
+
+// attack lets every unit group hit its target
+func (s *simulation) attack(tick int) {
+	for i := range s.army {
+		if s.army[i].Count == 0 {
+			continue
+		}
+		if s.targets[i] < 0 || s.structures[s.targets[i]].Health == 0 {
+			if s.targets[i] = s.pickTarget(); s.targets[i] < 0 {
+				return
+			}
+		}
+
+		target := &s.structures[s.targets[i]]
+		damage := min(s.roll(s.army[i].Count*s.army[i].Attack), target.Health)
+		if damage == 0 {
+			continue
+		}
+		target.Health -= damage
+		s.events = append(s.events, Event{Tick: tick, Kind: EventAttack, Source: i, Target: s.targets[i], Value: damage})
+
+		if target.Health == 0 {
+			s.events = append(s.events, Event{Tick: tick, Kind: EventDestroyed, Target: s.targets[i]})
+		}
+	}
+}
+
+// defend lets every standing structure hit a random unit group
+func (s *simulation) defend(tick int) {
+	for i := range s.structures {
+		structure := &s.structures[i]
+		if structure.Health == 0 || structure.Defense == 0 {
+			continue
+		}
+
+		var alive []int
+		for j := range s.army {
+			if s.army[j].Count > 0 {
+				alive = append(alive, j)
+			}
+		}
+		if len(alive) == 0 {
+			return
+		}
+
+		j := alive[s.rng.Intn(len(alive))]
+		unit := &s.army[j]
+		damage := min(s.roll(structure.Defense), s.pool[j])
+		if damage == 0 {
+			continue
+		}
+		s.pool[j] -= damage
+		s.events = append(s.events, Event{Tick: tick, Kind: EventDefend, Source: i, Target: j, Value: damage})
+
+		// Troops fall once the damage they took adds up to their health
+		count := (s.pool[j] + unit.Health - 1) / unit.Health
+		if lost := unit.Count - count; lost > 0 {
+			unit.Count = count
+			s.events = append(s.events, Event{Tick: tick, Kind: EventUnitsLost, Target: j, Value: lost})
+		}
+	}
+}
+
+// pickTarget returns a random standing wall, or a random standing structure
+// once the walls are down, or -1 when everything is destroyed
+func (s *simulation) pickTarget() int {
+	var walls, others []int
+	for i, structure := range s.structures {
+		if structure.Health == 0 {
+			continue
+		}
+		if structure.Wall {
+			walls = append(walls, i)
+		} else {
+			others = append(others, i)
+		}
+	}
+
+	switch {
+	case len(walls) > 0:
+		return walls[s.rng.Intn(len(walls))]
+	case len(others) > 0:
+		return others[s.rng.Intn(len(others))]
+	default:
+		return -1
+	}
+}
+
+// roll scales base damage by a random factor within the variance
+func (s *simulation) roll(base int) int {
+	percent := 100 + s.rng.Intn(2*s.variance+1) - s.variance
+	return base * percent / 100
+}
+
+func (s *simulation) armyStanding() bool {
+	for _, unit := range s.army {
+		if unit.Count > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *simulation) structuresStanding() bool {
+	for _, structure := range s.structures {
+		if structure.Health > 0 {
+			return true
+		}
+	}
+	return false
+}
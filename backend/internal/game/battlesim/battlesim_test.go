@@ -0,0 +1,200 @@
+package battlesim
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+func testInput(seed int64) Input {
+	return Input{
+		Seed: seed,
+		Army: []Unit{
+			{Type: "swordsman", Count: 20, Attack: 6, Health: 30},
+			{Type: "archer", Count: 15, Attack: 9, Health: 15},
+		},
+		Structures: []Structure{
+			{ID: uuid.New(), Type: "town_hall", Position: models.Position{X: 5, Y: 5}, Health: 400, MaxHealth: 400, Defense: 12},
+			{ID: uuid.New(), Type: "wall", Position: models.Position{X: 4, Y: 4}, Health: 300, MaxHealth: 300, Wall: true},
+			{ID: uuid.New(), Type: "farm", Position: models.Position{X: 7, Y: 3}, Health: 150, MaxHealth: 150},
+			{ID: uuid.New(), Type: "wall", Position: models.Position{X: 6, Y: 4}, Health: 300, MaxHealth: 300, Wall: true},
+			{ID: uuid.New(), Type: "tower", Position: models.Position{X: 3, Y: 7}, Health: 200, MaxHealth: 200, Defense: 20},
+		},
+		MaxTicks:       200,
+		Variance:       20,
+		WinDestruction: 0.5,
+	}
+}
+
+func TestRunIsDeterministic(t *testing.T) {
+	for _, seed := range []int64{1, 42, 987654321} {
+		input := testInput(seed)
+		first, err := Run(input)
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		second, err := Run(input)
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		if !reflect.DeepEqual(first, second) {
+			t.Errorf("seed %d: two runs of the same input gave different results", seed)
+		}
+	}
+}
+
+func TestRunDoesNotChangeInput(t *testing.T) {
+	input := testInput(7)
+	army := append([]Unit(nil), input.Army...)
+	structures := append([]Structure(nil), input.Structures...)
+
+	if _, err := Run(input); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(input.Army, army) || !reflect.DeepEqual(input.Structures, structures) {
+		t.Error("Run changed its input")
+	}
+}
+
+func TestRunDependsOnSeed(t *testing.T) {
+	first, err := Run(testInput(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Run(testInput(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(first.Events, second.Events) {
+		t.Error("different seeds gave the same event log")
+	}
+}
+
+func TestRunAttacksWallsFirst(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		input := testInput(seed)
+		result, err := Run(input)
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+
+		walls := 0
+		for _, structure := range input.Structures {
+			if structure.Wall {
+				walls++
+			}
+		}
+		for _, event := range result.Events {
+			if event.Kind == EventDestroyed && input.Structures[event.Target].Wall {
+				walls--
+			}
+			if event.Kind == EventAttack && !input.Structures[event.Target].Wall && walls > 0 {
+				t.Fatalf("seed %d: tick %d attacked %s with %d walls standing",
+					seed, event.Tick, input.Structures[event.Target].Type, walls)
+			}
+		}
+	}
+}
+
+func TestRunValidatesInput(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Input)
+	}{
+		{"no ticks", func(in *Input) { in.MaxTicks = 0 }},
+		{"negative variance", func(in *Input) { in.Variance = -1 }},
+		{"variance above 100", func(in *Input) { in.Variance = 101 }},
+		{"win destruction above 1", func(in *Input) { in.WinDestruction = 1.5 }},
+		{"negative troops", func(in *Input) { in.Army[0].Count = -1 }},
+		{"dead troops", func(in *Input) { in.Army[0].Health = 0 }},
+		{"overhealed structure", func(in *Input) { in.Structures[0].Health = in.Structures[0].MaxHealth + 1 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := testInput(1)
+			tt.modify(&input)
+			if _, err := Run(input); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestLogRoundTrip(t *testing.T) {
+	result, err := Run(testInput(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Events) == 0 {
+		t.Fatal("battle has no events")
+	}
+
+	events, err := DecodeLog(EncodeLog(result.Events))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(events, result.Events) {
+		t.Error("decoded log differs from the encoded events")
+	}
+
+	empty, err := DecodeLog(EncodeLog(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(empty) != 0 {
+		t.Errorf("decoded %d events from an empty log", len(empty))
+	}
+}
+
+func TestDecodeLogRejectsCorruptLogs(t *testing.T) {
+	log := EncodeLog([]Event{
+		{Tick: 1, Kind: EventAttack, Source: 0, Target: 1, Value: 300},
+		{Tick: 1, Kind: EventDestroyed, Target: 1},
+		{Tick: 2, Kind: EventDefend, Source: 4, Target: 1, Value: 18},
+	})
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"unknown version", append([]byte{logVersion + 1}, log[1:]...)},
+		{"unknown event kind", append(append([]byte(nil), log[:2]...), append([]byte{0xff}, log[3:]...)...)},
+		{"truncated event", log[:len(log)-1]},
+		{"truncated varint", append(append([]byte(nil), log...), 0x80)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeLog(tt.data); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestDecodeLogNeverPanics(t *testing.T) {
+	result, err := Run(testInput(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := EncodeLog(result.Events)
+
+	// Every truncation and random garbage either decodes or errors
+	for i := range log {
+		DecodeLog(log[:i])
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		data := make([]byte, rng.Intn(64))
+		rng.Read(data)
+		if len(data) > 0 {
+			data[0] = logVersion
+		}
+		DecodeLog(data)
+	}
+}
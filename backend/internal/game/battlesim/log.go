@@ -0,0 +1,110 @@
+package battlesim
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+// EventKind says what happened in a battle event
+type EventKind uint8
+
+const (
+	// Unit group Source hit structure Target for Value damage
+	EventAttack EventKind = iota + 1
+	// Structure Target was destroyed
+	EventDestroyed
+	// Structure Source hit unit group Target for Value damage
+	EventDefend
+	// Unit group Target lost Value troops
+	EventUnitsLost
+)
+
+var eventKindNames = map[EventKind]string{
+	EventAttack:    "attack",
+	EventDestroyed: "destroyed",
+	EventDefend:    "defend",
+	EventUnitsLost: "units_lost",
+}
+
+func (k EventKind) String() string {
+	return eventKindNames[k]
+}
+
+func (k EventKind) MarshalJSON() ([]byte, error) {
+	return json.Marshal(k.String())
+}
+
+// Event is one step of a battle. Sources and targets are indexes into the
+// army and structures of the simulation input.
+type Event struct {
+	Tick   int       `json:"tick"`
+	Kind   EventKind `json:"kind"`
+	Source int       `json:"source"`
+	Target int       `json:"target"`
+	Value  int       `json:"value,omitempty"`
+}
+
+// logVersion is the first byte of an encoded log
+const logVersion = 1
+
+// EncodeLog packs events into a compact binary log. Every event is stored
+// as varints: the ticks since the previous event, the kind, the source, the
+// target and the value.
+func EncodeLog(events []Event) []byte {
+	buf := make([]byte, 0, 1+len(events)*5)
+	buf = append(buf, logVersion)
+
+	tick := 0
+	for _, event := range events {
+		buf = binary.AppendUvarint(buf, uint64(event.Tick-tick))
+		buf = append(buf, byte(event.Kind))
+		buf = binary.AppendUvarint(buf, uint64(event.Source))
+		buf = binary.AppendUvarint(buf, uint64(event.Target))
+		buf = binary.AppendUvarint(buf, uint64(event.Value))
+		tick = event.Tick
+	}
+	return buf
+}
+
+// DecodeLog unpacks a log written by EncodeLog
+func DecodeLog(data []byte) ([]Event, error) {
+	if len(data) == 0 || data[0] != logVersion {
+		return nil, fmt.Errorf("unsupported battle log version")
+	}
+	data = data[1:]
+
+	events := []Event{}
+	tick := 0
+	for len(data) > 0 {
+		delta, n := binary.Uvarint(data)
+		if n <= 0 || n >= len(data) {
+			return nil, fmt.Errorf("corrupt battle log")
+		}
+		kind := EventKind(data[n])
+		if _, ok := eventKindNames[kind]; !ok {
+			return nil, fmt.Errorf("corrupt battle log: unknown event kind %d", kind)
+		}
+		data = data[n+1:]
+
+		var fields [3]uint64
+		for i := range fields {
+			value, n := binary.Uvarint(data)
+			if n <= 0 {
+				return nil, fmt.Errorf("corrupt battle log")
+			}
+			fields[i] = value
+			data = data[n:]
+		}
+
+		tick += int(delta)
+		events = append(events, Event{
+			Tick:   tick,
+			Kind:   kind,
+			Source: int(fields[0]),
+			Target: int(fields[1]),
+			Value:  int(fields[2]),
+		})
+	}
+	return events, nil
+}
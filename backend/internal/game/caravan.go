@@ -0,0 +1,262 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/ton-empire/backend/pkg/logger"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// Caravan operations
+
+const caravanHistoryLimit = 50
+
+// GetCaravans returns the latest caravans a user sent or receives, those on
+// the road first
+func (s *Service) GetCaravans(ctx context.Context, userID uuid.UUID) ([]*Caravan, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	caravans, err := s.repo.GetCaravans(ctx, district.ID, caravanHistoryLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caravans: %w", err)
+	}
+
+	now := time.Now()
+	for _, caravan := range caravans {
+		caravan.Progress = caravan.progressAt(now)
+	}
+	if caravans == nil {
+		caravans = []*Caravan{}
+	}
+	return caravans, nil
+}
+
+// SendCaravan loads resources from a user's district onto a caravan bound
+// for another player's district. The caravan carries up to the capacity of
+// the sender's Market and takes longer the farther apart their cities are
+// on the world map.
+func (s *Service) SendCaravan(ctx context.Context, userID uuid.UUID, req SendCaravanRequest) (*Caravan, error) {
+	if req.UserID == userID {
+		return nil, fmt.Errorf("a caravan can't be sent to yourself")
+	}
+
+	sender, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+	receiver, err := s.repo.GetDistrictByUserID(ctx, req.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("receiver has no district: %w", err)
+	}
+
+	for resourceType, amount := range req.Cargo {
+		if !isResourceType(resourceType) {
+			return nil, fmt.Errorf("unknown resource %s", resourceType)
+		}
+		if amount < 1 {
+			return nil, fmt.Errorf("%s to send must be at least 1", resourceType)
+		}
+	}
+
+	from, err := s.repo.GetCity(ctx, sender.CityID)
+	if err != nil {
+		return nil, fmt.Errorf("city not found: %w", err)
+	}
+	to, err := s.repo.GetCity(ctx, receiver.CityID)
+	if err != nil {
+		return nil, fmt.Errorf("city not found: %w", err)
+	}
+
+	catalog := s.catalog.Get()
+	now := time.Now()
+	caravan := &Caravan{
+		ID:                 uuid.New(),
+		SenderDistrictID:   sender.ID,
+		ReceiverDistrictID: receiver.ID,
+		Sender:             s.battlePlayer(ctx, userID),
+		Receiver:           s.battlePlayer(ctx, req.UserID),
+		Cargo:              req.Cargo,
+		From:               from.Position,
+		To:                 to.Position,
+		DepartedAt:         now,
+		ArrivesAt:          now.Add(catalog.caravanTravelTime(from.Position, to.Position)),
+	}
+
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockDistrictTx(ctx, tx, sender.ID); err != nil {
+			return err
+		}
+
+		// The Market is checked under the lock, so it can't be demolished
+		// or damaged meanwhile
+		buildings, err := s.repo.GetBuildingsByDistrictTx(ctx, tx, sender.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get buildings: %w", err)
+		}
+		level := marketLevel(buildings)
+		if level == 0 || len(catalog.Caravans.Capacity) == 0 {
+			return fmt.Errorf("sending caravans needs a working Market")
+		}
+		// Markets above a max level lowered by a catalog reload carry what
+		// the highest level does
+		capacity := catalog.Caravans.Capacity[min(level, len(catalog.Caravans.Capacity))-1]
+
+		var load int64
+		for _, amount := range caravan.Cargo {
+			// Checked one resource at a time, so the sum can't overflow
+			if load += amount; amount > capacity || load > capacity {
+				return fmt.Errorf("your caravans carry at most %d resources", capacity)
+			}
+		}
+
+		inFlight, err := s.repo.CountCaravansInFlightTx(ctx, tx, sender.ID)
+		if err != nil {
+			return err
+		}
+		if inFlight >= catalog.Caravans.MaxInFlight {
+			return fmt.Errorf("you already have %d caravans on the road", inFlight)
+		}
+
+		source := LedgerSource{Type: LedgerSourceCaravan, ID: caravan.ID}
+		if _, err := s.spendResourcesTx(ctx, tx, sender.ID, caravan.Cargo, LedgerReasonCaravan, source); err != nil {
+			return err
+		}
+		if err := s.repo.CreateCaravanTx(ctx, tx, caravan); err != nil {
+			return fmt.Errorf("failed to send caravan: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("District %s sent a caravan to district %s, arriving at %s", sender.ID, receiver.ID, caravan.ArrivesAt)
+
+	s.notifyCaravan(ctx, EventCaravanDeparted, caravan)
+	return caravan, nil
+}
+
+// ArriveDueCaravans unloads up to limit caravans that reached their
+// destination into the receiving districts and returns how many arrived.
+// Cargo a receiver has no room to store goes back to the sender.
+func (s *Service) ArriveDueCaravans(ctx context.Context, limit int) (int, error) {
+	var arrived []*Caravan
+
+	err := s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		now := time.Now()
+		due, err := s.repo.LockDueCaravans(ctx, tx, now, limit)
+		if err != nil {
+			return err
+		}
+
+		districtIDs := make([]uuid.UUID, 0, 2*len(due))
+		for _, caravan := range due {
+			districtIDs = append(districtIDs, caravan.ReceiverDistrictID, caravan.SenderDistrictID)
+		}
+		if err := s.lockDistrictsTx(ctx, tx, districtIDs...); err != nil {
+			return err
+		}
+
+		for _, caravan := range due {
+			room, err := s.storageRoomTx(ctx, tx, caravan.ReceiverDistrictID)
+			if err != nil {
+				return err
+			}
+			delivered := make(map[models.ResourceType]int64, len(caravan.Cargo))
+			for resourceType, amount := range caravan.Cargo {
+				delivered[resourceType] = min(amount, room[resourceType])
+				if left := amount - delivered[resourceType]; left > 0 {
+					if caravan.Returned == nil {
+						caravan.Returned = make(map[models.ResourceType]int64)
+					}
+					caravan.Returned[resourceType] = left
+				}
+			}
+
+			source := LedgerSource{Type: LedgerSourceCaravan, ID: caravan.ID}
+			if _, err := s.changeResourcesTx(ctx, tx, caravan.ReceiverDistrictID, delivered, LedgerReasonCaravan, source); err != nil {
+				return fmt.Errorf("failed to unload caravan %s: %w", caravan.ID, err)
+			}
+			if _, err := s.changeResourcesTx(ctx, tx, caravan.SenderDistrictID, caravan.Returned, LedgerReasonCaravan, source); err != nil {
+				return fmt.Errorf("failed to return caravan %s: %w", caravan.ID, err)
+			}
+			caravan.ArrivedAt = &now
+			caravan.Progress = 1
+			if err := s.repo.CompleteCaravanTx(ctx, tx, caravan); err != nil {
+				return fmt.Errorf("failed to complete caravan %s: %w", caravan.ID, err)
+			}
+		}
+
+		arrived = due
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, caravan := range arrived {
+		s.notifyCaravan(ctx, EventCaravanArrived, caravan)
+	}
+
+	return len(arrived), nil
+}
+
+// notifyCaravan sends the state of a caravan to its sender and receiver
+func (s *Service) notifyCaravan(ctx context.Context, eventType string, caravan *Caravan) {
+	for _, userID := range []uuid.UUID{caravan.Sender.ID, caravan.Receiver.ID} {
+		if err := s.events.PublishToUser(ctx, userID, eventType, caravan); err != nil {
+			logger.Errorf("Failed to publish %s for caravan %s: %v", eventType, caravan.ID, err)
+		}
+	}
+}
+
+// caravanTravelTime returns how long a caravan takes between cities at two
+// positions of the world map
+func (c *Catalog) caravanTravelTime(from, to models.Position) time.Duration {
+	distance := math.Hypot(float64(to.X-from.X), float64(to.Y-from.Y))
+	travel := c.Caravans.BaseTime + time.Duration(distance*float64(c.Caravans.TimePerCell))
+	return travel.Round(time.Second)
+}
+
+// Caravan carries resources from one district to another. From and To are
+// the world map positions of the cities it travels between.
+type Caravan struct {
+	ID                 uuid.UUID                     `json:"id"`
+	SenderDistrictID   uuid.UUID                     `json:"sender_district_id"`
+	ReceiverDistrictID uuid.UUID                     `json:"receiver_district_id"`
+	Sender             BattlePlayer                  `json:"sender"`
+	Receiver           BattlePlayer                  `json:"receiver"`
+	Cargo              map[models.ResourceType]int64 `json:"cargo"`
+	// Cargo the receiver had no room to store, brought back to the sender
+	Returned map[models.ResourceType]int64 `json:"returned,omitempty"`
+	From     models.Position               `json:"from"`
+	To       models.Position               `json:"to"`
+	// Share of the way travelled, from 0 to 1
+	Progress   float64    `json:"progress"`
+	DepartedAt time.Time  `json:"departed_at"`
+	ArrivesAt  time.Time  `json:"arrives_at"`
+	ArrivedAt  *time.Time `json:"arrived_at"`
+}
+
+// progressAt returns the share of the way a caravan travelled at a time
+func (c *Caravan) progressAt(now time.Time) float64 {
+	total := c.ArrivesAt.Sub(c.DepartedAt)
+	if c.ArrivedAt != nil || total <= 0 {
+		return 1
+	}
+	return math.Min(math.Max(float64(now.Sub(c.DepartedAt))/float64(total), 0), 1)
+}
+
+type SendCaravanRequest struct {
+	// The receiving player
+	UserID uuid.UUID                     `json:"user_id" binding:"required"`
+	Cargo  map[models.ResourceType]int64 `json:"cargo" binding:"required,min=1"`
+}
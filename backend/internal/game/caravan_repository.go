@@ -0,0 +1,129 @@
+package game
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// Caravan operations
+
+const caravanColumns = `
+	c.id, c.sender_district_id, ds.owner_id, us.username,
+	c.receiver_district_id, dr.owner_id, ur.username, c.cargo,
+	c.from_x, c.from_y, c.to_x, c.to_y, c.departed_at, c.arrives_at, c.arrived_at, c.returned`
+
+const caravanJoins = `
+	JOIN districts ds ON ds.id = c.sender_district_id
+	JOIN users us ON us.id = ds.owner_id
+	JOIN districts dr ON dr.id = c.receiver_district_id
+	JOIN users ur ON ur.id = dr.owner_id`
+
+// CreateCaravanTx stores a caravan on its way as part of a transaction
+func (r *Repository) CreateCaravanTx(ctx context.Context, tx *sqlx.Tx, caravan *Caravan) error {
+	cargo, err := json.Marshal(caravan.Cargo)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO caravans (id, sender_district_id, receiver_district_id, cargo,
+		                      from_x, from_y, to_x, to_y, departed_at, arrives_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		caravan.ID, caravan.SenderDistrictID, caravan.ReceiverDistrictID, cargo,
+		caravan.From.X, caravan.From.Y, caravan.To.X, caravan.To.Y,
+		caravan.DepartedAt, caravan.ArrivesAt)
+	return err
+}
+
+// CountCaravansInFlightTx counts the caravans a district sent that haven't
+// arrived yet as part of a transaction
+func (r *Repository) CountCaravansInFlightTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID) (int, error) {
+	var count int
+	err := tx.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM caravans WHERE sender_district_id = $1 AND arrived_at IS NULL`,
+		districtID).Scan(&count)
+	return count, err
+}
+
+// GetCaravans returns the latest caravans a district sent or receives,
+// those on the road first
+func (r *Repository) GetCaravans(ctx context.Context, districtID uuid.UUID, limit int) ([]*Caravan, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+caravanColumns+`
+		FROM caravans c`+caravanJoins+`
+		WHERE c.sender_district_id = $1 OR c.receiver_district_id = $1
+		ORDER BY c.arrived_at IS NULL DESC, c.departed_at DESC
+		LIMIT $2`,
+		districtID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanCaravans(rows)
+}
+
+// LockDueCaravans returns up to limit caravans that reached their
+// destination before now but haven't been unloaded, locked until tx ends.
+// Rows locked by another game-service replica are skipped, so every caravan
+// is unloaded exactly once.
+func (r *Repository) LockDueCaravans(ctx context.Context, tx *sqlx.Tx, now time.Time, limit int) ([]*Caravan, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT `+caravanColumns+`
+		FROM caravans c`+caravanJoins+`
+		WHERE c.arrived_at IS NULL AND c.arrives_at <= $1
+		ORDER BY c.arrives_at
+		LIMIT $2
+		FOR UPDATE OF c SKIP LOCKED`,
+		now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanCaravans(rows)
+}
+
+// CompleteCaravanTx marks a caravan as unloaded as part of a transaction
+func (r *Repository) CompleteCaravanTx(ctx context.Context, tx *sqlx.Tx, caravan *Caravan) error {
+	returned, err := json.Marshal(caravan.Returned)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE caravans SET arrived_at = $1, returned = $2 WHERE id = $3`,
+		caravan.ArrivedAt, returned, caravan.ID)
+	return err
+}
+
+func scanCaravans(rows *sql.Rows) ([]*Caravan, error) {
+	var caravans []*Caravan
+	for rows.Next() {
+		var caravan Caravan
+		var cargo, returned []byte
+		err := rows.Scan(&caravan.ID, &caravan.SenderDistrictID, &caravan.Sender.ID, &caravan.Sender.Username,
+			&caravan.ReceiverDistrictID, &caravan.Receiver.ID, &caravan.Receiver.Username, &cargo,
+			&caravan.From.X, &caravan.From.Y, &caravan.To.X, &caravan.To.Y,
+			&caravan.DepartedAt, &caravan.ArrivesAt, &caravan.ArrivedAt, &returned)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(cargo, &caravan.Cargo); err != nil {
+			return nil, fmt.Errorf("failed to decode caravan cargo: %w", err)
+		}
+		if returned != nil {
+			if err := json.Unmarshal(returned, &caravan.Returned); err != nil {
+				return nil, fmt.Errorf("failed to decode caravan returns: %w", err)
+			}
+		}
+		caravans = append(caravans, &caravan)
+	}
+	return caravans, rows.Err()
+}
@@ -0,0 +1,1141 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"github.com/ton-empire/backend/pkg/logger"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// BuildingWarehouse stores resources beyond the Town Hall's capacity
+const BuildingWarehouse models.BuildingType = "warehouse"
+
+var resourceTypes = []models.ResourceType{
+	models.ResourceGold,
+	models.ResourceWood,
+	models.ResourceStone,
+	models.ResourceFood,
+	models.ResourceEnergy,
+}
+
+// Catalog holds the balance data for every building type
+type Catalog struct {
+	Version        string                                `mapstructure:"version" json:"version"`
+	DemolishRefund float64                               `mapstructure:"demolish_refund" json:"demolish_refund"`
+	Construction   ConstructionSpec                      `mapstructure:"construction" json:"construction"`
+	SpeedUp        SpeedUpSpec                           `mapstructure:"speedup" json:"speedup"`
+	Upkeep         UpkeepSpec                            `mapstructure:"upkeep" json:"upkeep"`
+	Population     PopulationSpec                        `mapstructure:"population" json:"population"`
+	Repair         RepairSpec                            `mapstructure:"repair" json:"repair"`
+	Buildings      map[models.BuildingType]*BuildingSpec `mapstructure:"buildings" json:"buildings"`
+	Items          map[string]*ItemSpec                  `mapstructure:"items" json:"items"`
+	// Share of district cells covered by each terrain type
+	Terrain   map[string]float64 `mapstructure:"terrain" json:"terrain"`
+	Adjacency []AdjacencyRule    `mapstructure:"adjacency" json:"adjacency"`
+	// Land expansions in the order they are bought
+	Expansion []ExpansionStep `mapstructure:"expansion" json:"expansion"`
+	Cities    CitySpec        `mapstructure:"cities" json:"cities"`
+	World     WorldSpec       `mapstructure:"world" json:"world"`
+	Battle    BattleSpec      `mapstructure:"battle" json:"battle"`
+	Shield    ShieldSpec      `mapstructure:"shield" json:"shield"`
+	Army      ArmySpec        `mapstructure:"army" json:"army"`
+	GuildWar  GuildWarSpec    `mapstructure:"guild_war" json:"guild_war"`
+	Waves     WaveSpec        `mapstructure:"waves" json:"waves"`
+	Market    MarketSpec      `mapstructure:"market" json:"market"`
+	Exchange  ExchangeSpec    `mapstructure:"exchange" json:"exchange"`
+	Caravans  CaravanSpec     `mapstructure:"caravans" json:"caravans"`
+	// Troop types trained at the Barracks
+	Troops map[string]*TroopSpec `mapstructure:"troops" json:"troops"`
+}
+
+// BattleSpec configures PvP battles
+type BattleSpec struct {
+	// Defense every level of an active building adds; it's the damage the
+	// building deals per tick in battle
+	Defense map[models.BuildingType]float64 `mapstructure:"defense" json:"defense"`
+	// Targets have a power within this share of the attacker's, e.g. 0.25
+	// for 25% weaker to 25% stronger
+	PowerBand float64 `mapstructure:"power_band" json:"power_band"`
+	// Most targets a search returns
+	SearchLimit int `mapstructure:"search_limit" json:"search_limit"`
+	// Share of each of the defender's resources a winning attacker takes
+	LootRatio float64 `mapstructure:"loot_ratio" json:"loot_ratio"`
+	// Longest a battle lasts
+	MaxTicks int `mapstructure:"max_ticks" json:"max_ticks"`
+	// Hits deal between 100-variance and 100+variance percent of their damage
+	Variance int `mapstructure:"variance" json:"variance"`
+	// Share of the defender's building health the attacker must destroy to win
+	WinDestruction float64 `mapstructure:"win_destruction" json:"win_destruction"`
+	// Time before an attacker may attack the same player again
+	Cooldown time.Duration `mapstructure:"cooldown" json:"-"`
+}
+
+func (b BattleSpec) MarshalJSON() ([]byte, error) {
+	type battleSpec BattleSpec
+	return json.Marshal(struct {
+		battleSpec
+		CooldownSeconds int64 `json:"cooldown_seconds"`
+	}{
+		battleSpec:      battleSpec(b),
+		CooldownSeconds: int64(b.Cooldown.Seconds()),
+	})
+}
+
+// ShieldSpec configures the shields that protect districts from attacks
+type ShieldSpec struct {
+	// Shield of new players, counted from registration
+	Beginner time.Duration `mapstructure:"beginner" json:"-"`
+	// Shield a defender gets after being attacked
+	AfterAttack time.Duration `mapstructure:"after_attack" json:"-"`
+	// Longest a shield can last from now on
+	MaxDuration time.Duration `mapstructure:"max_duration" json:"-"`
+}
+
+func (s ShieldSpec) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		BeginnerSeconds    int64 `json:"beginner_seconds"`
+		AfterAttackSeconds int64 `json:"after_attack_seconds"`
+		MaxDurationSeconds int64 `json:"max_duration_seconds"`
+	}{
+		BeginnerSeconds:    int64(s.Beginner.Seconds()),
+		AfterAttackSeconds: int64(s.AfterAttack.Seconds()),
+		MaxDurationSeconds: int64(s.MaxDuration.Seconds()),
+	})
+}
+
+// GuildWarSpec configures wars between guilds
+type GuildWarSpec struct {
+	// Time between the declaration of a war and its start
+	Preparation time.Duration `mapstructure:"preparation" json:"-"`
+	// How long a war lasts once it started
+	Duration time.Duration `mapstructure:"duration" json:"-"`
+	// Points a guild scores when a member wins an attack or a defense
+	AttackPoints  int `mapstructure:"attack_points" json:"attack_points"`
+	DefensePoints int `mapstructure:"defense_points" json:"defense_points"`
+	// Resources paid into the treasury of the winning guild
+	Reward map[models.ResourceType]int64 `mapstructure:"reward" json:"reward"`
+}
+
+func (g GuildWarSpec) MarshalJSON() ([]byte, error) {
+	type guildWarSpec GuildWarSpec
+	return json.Marshal(struct {
+		guildWarSpec
+		PreparationSeconds int64 `json:"preparation_seconds"`
+		DurationSeconds    int64 `json:"duration_seconds"`
+	}{
+		guildWarSpec:       guildWarSpec(g),
+		PreparationSeconds: int64(g.Preparation.Seconds()),
+		DurationSeconds:    int64(g.Duration.Seconds()),
+	})
+}
+
+// WaveSpec configures the NPC waves that attack cities
+type WaveSpec struct {
+	// Time between the waves hitting a city
+	Interval time.Duration `mapstructure:"interval" json:"-"`
+	// How long before it lands a wave is announced. Guildmates can send
+	// reinforcements meanwhile.
+	Warning time.Duration `mapstructure:"warning" json:"-"`
+	// Power of a wave: a base plus some for every district of the city
+	BasePower     float64 `mapstructure:"base_power" json:"base_power"`
+	DistrictPower float64 `mapstructure:"district_power" json:"district_power"`
+	// Share of their max health the buildings of a city lose when a wave
+	// breaks through
+	Damage float64 `mapstructure:"damage" json:"damage"`
+	// Share of the defending troops lost to a wave as strong as the
+	// defense. Weaker waves kill fewer.
+	TroopLosses float64 `mapstructure:"troop_losses" json:"troop_losses"`
+	// Resources per district of the city shared among the defenders when
+	// they hold
+	Reward map[models.ResourceType]int64 `mapstructure:"reward" json:"reward"`
+}
+
+func (w WaveSpec) MarshalJSON() ([]byte, error) {
+	type waveSpec WaveSpec
+	return json.Marshal(struct {
+		waveSpec
+		IntervalSeconds int64 `json:"interval_seconds"`
+		WarningSeconds  int64 `json:"warning_seconds"`
+	}{
+		waveSpec:        waveSpec(w),
+		IntervalSeconds: int64(w.Interval.Seconds()),
+		WarningSeconds:  int64(w.Warning.Seconds()),
+	})
+}
+
+// MarketSpec configures the player market. Prices are in gold per unit.
+type MarketSpec struct {
+	// Share of the gold a seller receives that goes to fees
+	Fee      float64 `mapstructure:"fee" json:"fee"`
+	MaxPrice int64   `mapstructure:"max_price" json:"max_price"`
+	// Open orders a district can have at once
+	MaxOpenOrders int `mapstructure:"max_open_orders" json:"max_open_orders"`
+	// Most orders in the book a new order fills against
+	MaxFills int `mapstructure:"max_fills" json:"max_fills"`
+}
+
+// ExchangeSpec configures the NPC exchange. Prices are in gold per unit.
+type ExchangeSpec struct {
+	// Net volume players bought over this window moves the prices
+	Window time.Duration `mapstructure:"window" json:"-"`
+	// Share of the price between what the exchange asks and what it pays
+	Spread float64 `mapstructure:"spread" json:"spread"`
+	// Units of each resource a player may buy and sell per day, together
+	DailyLimit int64 `mapstructure:"daily_limit" json:"daily_limit"`
+	// Price history has one point per interval, up to history_length points
+	HistoryInterval time.Duration `mapstructure:"history_interval" json:"-"`
+	HistoryLength   int           `mapstructure:"history_length" json:"history_length"`
+	// Resources the exchange trades
+	Resources map[models.ResourceType]*ExchangeResource `mapstructure:"resources" json:"resources"`
+}
+
+func (e ExchangeSpec) MarshalJSON() ([]byte, error) {
+	type exchangeSpec ExchangeSpec
+	return json.Marshal(struct {
+		exchangeSpec
+		WindowSeconds          int64 `json:"window_seconds"`
+		HistoryIntervalSeconds int64 `json:"history_interval_seconds"`
+	}{
+		exchangeSpec:           exchangeSpec(e),
+		WindowSeconds:          int64(e.Window.Seconds()),
+		HistoryIntervalSeconds: int64(e.HistoryInterval.Seconds()),
+	})
+}
+
+// ExchangeResource prices a resource on the NPC exchange
+type ExchangeResource struct {
+	// Price while as much was bought as sold over the window
+	BasePrice float64 `mapstructure:"base_price" json:"base_price"`
+	// Net units bought over the window that double the price. Selling as
+	// many halves it.
+	Depth    int64   `mapstructure:"depth" json:"depth"`
+	MinPrice float64 `mapstructure:"min_price" json:"min_price"`
+	MaxPrice float64 `mapstructure:"max_price" json:"max_price"`
+}
+
+// CaravanSpec configures the caravans carrying resources between districts
+type CaravanSpec struct {
+	// Travel time between districts of the same city, plus some for every
+	// cell of world map distance between their cities
+	BaseTime    time.Duration `mapstructure:"base_time" json:"-"`
+	TimePerCell time.Duration `mapstructure:"time_per_cell" json:"-"`
+	// Units of resources a caravan carries by level of the sender's Market
+	Capacity []int64 `mapstructure:"capacity" json:"capacity"`
+	// Caravans a district can have on the road at once
+	MaxInFlight int `mapstructure:"max_in_flight" json:"max_in_flight"`
+}
+
+func (c CaravanSpec) MarshalJSON() ([]byte, error) {
+	type caravanSpec CaravanSpec
+	return json.Marshal(struct {
+		caravanSpec
+		BaseTimeSeconds    int64   `json:"base_time_seconds"`
+		TimePerCellSeconds float64 `json:"time_per_cell_seconds"`
+	}{
+		caravanSpec:        caravanSpec(c),
+		BaseTimeSeconds:    int64(c.BaseTime.Seconds()),
+		TimePerCellSeconds: c.TimePerCell.Seconds(),
+	})
+}
+
+// ArmySpec configures troop training at the Barracks
+type ArmySpec struct {
+	// Training orders that can wait in a district's queue
+	MaxQueueLength int `mapstructure:"max_queue_length" json:"max_queue_length"`
+}
+
+// TroopSpec describes a troop type trained at the Barracks. Cost and
+// Duration are per troop.
+type TroopSpec struct {
+	Name string `mapstructure:"name" json:"name"`
+	// Barracks level needed to train the troop
+	BarracksLevel int `mapstructure:"barracks_level" json:"barracks_level"`
+	// Damage a troop deals per tick in battle
+	Attack   int                           `mapstructure:"attack" json:"attack"`
+	Health   int                           `mapstructure:"health" json:"health"`
+	Cost     map[models.ResourceType]int64 `mapstructure:"cost" json:"cost"`
+	Duration time.Duration                 `mapstructure:"duration" json:"-"`
+	// Food a troop eats per hour
+	FoodUpkeep float64 `mapstructure:"food_upkeep" json:"food_upkeep"`
+}
+
+func (t TroopSpec) MarshalJSON() ([]byte, error) {
+	type troopSpec TroopSpec
+	return json.Marshal(struct {
+		troopSpec
+		DurationSeconds int64 `json:"duration_seconds"`
+	}{
+		troopSpec:       troopSpec(t),
+		DurationSeconds: int64(t.Duration.Seconds()),
+	})
+}
+
+// WorldSpec configures the world map cities are placed on
+type WorldSpec struct {
+	Width  int `mapstructure:"width" json:"width"`
+	Height int `mapstructure:"height" json:"height"`
+	// Side of the square regions clients subscribe to for live map updates
+	RegionSize int `mapstructure:"region_size" json:"region_size"`
+	// Cities are placed on a grid with this spacing
+	CitySpacing int `mapstructure:"city_spacing" json:"city_spacing"`
+	// Largest width and height of a viewport query
+	MaxViewport int `mapstructure:"max_viewport" json:"max_viewport"`
+	// Share of map cells holding each type of point of interest
+	PointsOfInterest map[string]float64 `mapstructure:"points_of_interest" json:"points_of_interest"`
+	// Points of interest have levels 1 to MaxPOILevel
+	MaxPOILevel int `mapstructure:"max_poi_level" json:"max_poi_level"`
+}
+
+// CitySpec configures the cities districts live in. Levels[0] is the
+// founding of a guild city, every following entry the upgrade to that level.
+type CitySpec struct {
+	// Districts an NPC city holds
+	NPCSlots int `mapstructure:"npc_slots" json:"npc_slots"`
+	// Cities a guild may found
+	MaxPerGuild int             `mapstructure:"max_per_guild" json:"max_per_guild"`
+	Levels      []CityLevelSpec `mapstructure:"levels" json:"levels"`
+}
+
+// CityLevelSpec is the cost of a guild city level and how many districts the
+// city holds at that level
+type CityLevelSpec struct {
+	Slots int                           `mapstructure:"slots" json:"slots"`
+	Cost  map[models.ResourceType]int64 `mapstructure:"cost" json:"cost"`
+}
+
+// ExpansionStep grows a district grid to Size x Size
+type ExpansionStep struct {
+	Size          int                           `mapstructure:"size" json:"size"`
+	TownHallLevel int                           `mapstructure:"town_hall_level" json:"town_hall_level"`
+	Cost          map[models.ResourceType]int64 `mapstructure:"cost" json:"cost"`
+}
+
+// AdjacencyRule boosts the production of a building for every neighbouring
+// building or terrain cell of a type. Exactly one of Neighbor and Terrain is set.
+type AdjacencyRule struct {
+	Building models.BuildingType `mapstructure:"building" json:"building"`
+	Neighbor models.BuildingType `mapstructure:"neighbor" json:"neighbor,omitempty"`
+	Terrain  string              `mapstructure:"terrain" json:"terrain,omitempty"`
+	// Production bonus per neighbour, e.g. 0.05 for +5%
+	Bonus float64 `mapstructure:"bonus" json:"bonus"`
+	// Cap on the bonus from this rule; zero means no cap
+	MaxBonus float64 `mapstructure:"max_bonus" json:"max_bonus"`
+}
+
+// SpeedUpSpec prices finishing an upgrade instantly with a resource
+type SpeedUpSpec struct {
+	Resource      models.ResourceType `mapstructure:"resource" json:"resource"`
+	CostPerMinute int64               `mapstructure:"cost_per_minute" json:"cost_per_minute"`
+}
+
+// UpkeepSpec configures what a district's population consumes and how hard a
+// deficit hits production
+type UpkeepSpec struct {
+	FoodPerCitizen float64 `mapstructure:"food_per_citizen" json:"food_per_citizen"`
+	// Lowest efficiency percentage a deficit can cause
+	MinEfficiency float64 `mapstructure:"min_efficiency" json:"min_efficiency"`
+}
+
+// PopulationSpec configures population growth and staffing
+type PopulationSpec struct {
+	// Share of the gap between population and housing closed per hour
+	GrowthPerHour float64 `mapstructure:"growth_per_hour" json:"growth_per_hour"`
+	// Share of its production a building keeps without any workers
+	UnstaffedRate float64 `mapstructure:"unstaffed_rate" json:"unstaffed_rate"`
+}
+
+// RepairSpec configures when damaged buildings stop working and what
+// repairing them costs
+type RepairSpec struct {
+	// Share of max health below which a building is inactive
+	InactiveBelow float64 `mapstructure:"inactive_below" json:"inactive_below"`
+	// Share of the current level's cost a full repair from zero health costs
+	CostRatio float64 `mapstructure:"cost_ratio" json:"cost_ratio"`
+}
+
+// ItemSpec describes a consumable item
+type ItemSpec struct {
+	Name string `mapstructure:"name" json:"name"`
+	// Time cut from a running upgrade
+	SpeedUp time.Duration `mapstructure:"speedup" json:"-"`
+	// Time added to the district's shield
+	Shield time.Duration `mapstructure:"shield" json:"-"`
+}
+
+func (i ItemSpec) MarshalJSON() ([]byte, error) {
+	type itemSpec ItemSpec
+	return json.Marshal(struct {
+		itemSpec
+		SpeedUpSeconds int64 `json:"speedup_seconds,omitempty"`
+		ShieldSeconds  int64 `json:"shield_seconds,omitempty"`
+	}{
+		itemSpec:       itemSpec(i),
+		SpeedUpSeconds: int64(i.SpeedUp.Seconds()),
+		ShieldSeconds:  int64(i.Shield.Seconds()),
+	})
+}
+
+// ConstructionSpec configures builder slots and the construction queue
+type ConstructionSpec struct {
+	BuilderSlots int `mapstructure:"builder_slots" json:"builder_slots"`
+	// Town Hall levels that each grant one extra builder slot
+	ExtraSlotTownHallLevels []int `mapstructure:"extra_slot_town_hall_levels" json:"extra_slot_town_hall_levels"`
+	MaxQueueLength          int   `mapstructure:"max_queue_length" json:"max_queue_length"`
+	// How many levels other buildings may be ahead of the Town Hall
+	MaxLevelAboveTownHall int `mapstructure:"max_level_above_town_hall" json:"max_level_above_town_hall"`
+}
+
+// BuildingSpec describes a building type. Levels[0] is the construction of
+// the building, every following entry the upgrade to that level.
+type BuildingSpec struct {
+	Name          string         `mapstructure:"name" json:"name"`
+	MaxLevel      int            `mapstructure:"max_level" json:"max_level"`
+	Prerequisites []Prerequisite `mapstructure:"prerequisites" json:"prerequisites"`
+	// Town Hall level needed to construct the building
+	TownHallLevel int `mapstructure:"town_hall_level" json:"town_hall_level"`
+	// MaxCount[i] is how many a district may own with the Town Hall at level
+	// i+1. The last entry applies to all higher levels; empty means no limit.
+	MaxCount []int       `mapstructure:"max_count" json:"max_count"`
+	Levels   []LevelSpec `mapstructure:"levels" json:"levels"`
+}
+
+// MaxCountAt returns how many buildings of the type a district may own with
+// its Town Hall at townHallLevel, or -1 for no limit
+func (b *BuildingSpec) MaxCountAt(townHallLevel int) int {
+	if len(b.MaxCount) == 0 {
+		return -1
+	}
+	if townHallLevel < 1 {
+		return 0
+	}
+	return b.MaxCount[min(townHallLevel, len(b.MaxCount))-1]
+}
+
+// Prerequisite is a building the district must own before constructing another
+type Prerequisite struct {
+	Building models.BuildingType `mapstructure:"building" json:"building"`
+	Level    int                 `mapstructure:"level" json:"level"`
+}
+
+// LevelSpec is the cost, build time, hourly production, storage capacity,
+// hourly upkeep, housing, jobs and troop capacity of a building level
+type LevelSpec struct {
+	Cost       map[models.ResourceType]int64 `mapstructure:"cost" json:"cost"`
+	Duration   time.Duration                 `mapstructure:"duration" json:"-"`
+	Health     float64                       `mapstructure:"health" json:"health"`
+	Production map[models.ResourceType]int64 `mapstructure:"production" json:"production,omitempty"`
+	Storage    map[models.ResourceType]int64 `mapstructure:"storage" json:"storage,omitempty"`
+	Upkeep     map[models.ResourceType]int64 `mapstructure:"upkeep" json:"upkeep,omitempty"`
+	Housing    int                           `mapstructure:"housing" json:"housing,omitempty"`
+	Workers    int                           `mapstructure:"workers" json:"workers,omitempty"`
+	Troops     int                           `mapstructure:"troops" json:"troops,omitempty"`
+}
+
+func (l LevelSpec) MarshalJSON() ([]byte, error) {
+	type levelSpec LevelSpec
+	return json.Marshal(struct {
+		levelSpec
+		DurationSeconds int64 `json:"duration_seconds"`
+	}{
+		levelSpec:       levelSpec(l),
+		DurationSeconds: int64(l.Duration.Seconds()),
+	})
+}
+
+// Building returns the spec of a building type
+func (c *Catalog) Building(buildingType models.BuildingType) (*BuildingSpec, error) {
+	spec, ok := c.Buildings[buildingType]
+	if !ok {
+		return nil, fmt.Errorf("unknown building type: %s", buildingType)
+	}
+	return spec, nil
+}
+
+// Level returns the spec of a building type at the given level
+func (c *Catalog) Level(buildingType models.BuildingType, level int) (*LevelSpec, error) {
+	spec, err := c.Building(buildingType)
+	if err != nil {
+		return nil, err
+	}
+	if level < 1 || level > spec.MaxLevel {
+		return nil, fmt.Errorf("%s has no level %d", buildingType, level)
+	}
+	return &spec.Levels[level-1], nil
+}
+
+// Cost returns the resources needed to reach level
+func (c *Catalog) Cost(buildingType models.BuildingType, level int) (map[models.ResourceType]int64, error) {
+	spec, err := c.Level(buildingType, level)
+	if err != nil {
+		return nil, err
+	}
+
+	cost := make(map[models.ResourceType]int64, len(spec.Cost))
+	for resourceType, amount := range spec.Cost {
+		cost[resourceType] = amount
+	}
+	return cost, nil
+}
+
+// Duration returns how long it takes to reach level
+func (c *Catalog) Duration(buildingType models.BuildingType, level int) (time.Duration, error) {
+	spec, err := c.Level(buildingType, level)
+	if err != nil {
+		return 0, err
+	}
+	return spec.Duration, nil
+}
+
+// Production returns the hourly production of a building at level
+func (c *Catalog) Production(buildingType models.BuildingType, level int) []models.BuildingProduction {
+	spec, err := c.Level(buildingType, level)
+	if err != nil {
+		return nil
+	}
+
+	production := make([]models.BuildingProduction, 0, len(spec.Production))
+	for _, resourceType := range resourceTypes {
+		if rate, ok := spec.Production[resourceType]; ok {
+			production = append(production, models.BuildingProduction{ResourceType: resourceType, Rate: rate})
+		}
+	}
+	return production
+}
+
+// MaxHealth returns the max health of a building at level. Levels the
+// catalog no longer has keep the health they had.
+func (c *Catalog) MaxHealth(building *models.Building) float64 {
+	spec, err := c.Level(building.Type, building.Level)
+	if err != nil {
+		return building.MaxHealth
+	}
+	return spec.Health
+}
+
+// ProductionRate returns the hourly production of one resource at level
+func (c *Catalog) ProductionRate(buildingType models.BuildingType, level int, resourceType models.ResourceType) int64 {
+	spec, err := c.Level(buildingType, level)
+	if err != nil {
+		return 0
+	}
+	return spec.Production[resourceType]
+}
+
+// StorageCapacity returns how much of each resource a district with the
+// given buildings can store
+func (c *Catalog) StorageCapacity(buildings []*models.Building) map[models.ResourceType]int64 {
+	capacity := make(map[models.ResourceType]int64, len(resourceTypes))
+	for _, resourceType := range resourceTypes {
+		capacity[resourceType] = 0
+	}
+
+	for _, building := range buildings {
+		if !building.IsActive {
+			continue
+		}
+		spec, err := c.Level(building.Type, building.Level)
+		if err != nil {
+			continue
+		}
+		for resourceType, amount := range spec.Storage {
+			capacity[resourceType] += amount
+		}
+	}
+	return capacity
+}
+
+// UpkeepRate returns the hourly consumption of a district with the given
+// buildings, population and troops
+func (c *Catalog) UpkeepRate(buildings []*models.Building, population int, troops map[string]int) map[models.ResourceType]int64 {
+	upkeep := make(map[models.ResourceType]int64)
+	for _, building := range buildings {
+		if !building.IsActive {
+			continue
+		}
+		spec, err := c.Level(building.Type, building.Level)
+		if err != nil {
+			continue
+		}
+		for resourceType, amount := range spec.Upkeep {
+			upkeep[resourceType] += amount
+		}
+	}
+
+	if food := int64(math.Ceil(float64(population) * c.Upkeep.FoodPerCitizen)); food > 0 {
+		upkeep[models.ResourceFood] += food
+	}
+	if food := c.ArmyFoodUpkeep(troops); food > 0 {
+		upkeep[models.ResourceFood] += food
+	}
+	return upkeep
+}
+
+// ArmyFoodUpkeep returns the food troops eat per hour
+func (c *Catalog) ArmyFoodUpkeep(troops map[string]int) int64 {
+	food := 0.0
+	for troopType, count := range troops {
+		if spec, ok := c.Troops[troopType]; ok {
+			food += float64(count) * spec.FoodUpkeep
+		}
+	}
+	return int64(math.Ceil(food))
+}
+
+// BattlePower returns the attack of a district's troops and the defense of
+// its buildings. Its power is the sum of both.
+func (c *Catalog) BattlePower(buildings []*models.Building, troops map[string]int) (attack, defense float64) {
+	for troopType, count := range troops {
+		if spec, ok := c.Troops[troopType]; ok {
+			attack += float64(count * spec.Attack)
+		}
+	}
+	for _, building := range buildings {
+		if !building.IsActive {
+			continue
+		}
+		defense += c.Battle.Defense[building.Type] * float64(building.Level)
+	}
+	return attack, defense
+}
+
+// ArmyCapacity returns how many troops the given buildings hold
+func (c *Catalog) ArmyCapacity(buildings []*models.Building) int {
+	capacity := 0
+	for _, building := range buildings {
+		if !building.IsActive {
+			continue
+		}
+		if spec, err := c.Level(building.Type, building.Level); err == nil {
+			capacity += spec.Troops
+		}
+	}
+	return capacity
+}
+
+// Troop returns the spec of a troop type
+func (c *Catalog) Troop(troopType string) (*TroopSpec, error) {
+	spec, ok := c.Troops[troopType]
+	if !ok {
+		return nil, fmt.Errorf("unknown troop type: %s", troopType)
+	}
+	return spec, nil
+}
+
+// HousingCapacity returns how many citizens the given buildings shelter
+func (c *Catalog) HousingCapacity(buildings []*models.Building) int {
+	capacity := 0
+	for _, building := range buildings {
+		if !building.IsActive {
+			continue
+		}
+		if spec, err := c.Level(building.Type, building.Level); err == nil {
+			capacity += spec.Housing
+		}
+	}
+	return capacity
+}
+
+// Workers returns how many workers a building needs at level to produce at
+// full rate
+func (c *Catalog) Workers(buildingType models.BuildingType, level int) int {
+	spec, err := c.Level(buildingType, level)
+	if err != nil {
+		return 0
+	}
+	return spec.Workers
+}
+
+// NextExpansion returns the expansion step after gridSize, or nil when the
+// district is fully expanded
+func (c *Catalog) NextExpansion(gridSize int) *ExpansionStep {
+	for i := range c.Expansion {
+		if c.Expansion[i].Size > gridSize {
+			return &c.Expansion[i]
+		}
+	}
+	return nil
+}
+
+// CitySlots returns how many districts a city holds
+func (c *Catalog) CitySlots(city *City) int {
+	if city.GuildID == nil {
+		return c.Cities.NPCSlots
+	}
+	if city.Level < 1 || len(c.Cities.Levels) == 0 {
+		return 0
+	}
+	return c.Cities.Levels[min(city.Level, len(c.Cities.Levels))-1].Slots
+}
+
+// RepairCost returns the resources that restore a building's missing health.
+// The cost is proportional to the share of health missing.
+func (c *Catalog) RepairCost(building *models.Building) (map[models.ResourceType]int64, error) {
+	levelCost, err := c.Cost(building.Type, building.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := 0.0
+	if building.MaxHealth > 0 {
+		missing = (building.MaxHealth - building.Health) / building.MaxHealth
+	}
+
+	cost := make(map[models.ResourceType]int64, len(levelCost))
+	for resourceType, amount := range levelCost {
+		if repair := int64(math.Ceil(float64(amount) * c.Repair.CostRatio * missing)); repair > 0 {
+			cost[resourceType] = repair
+		}
+	}
+	return cost, nil
+}
+
+// Item returns the spec of an item type
+func (c *Catalog) Item(itemType string) (*ItemSpec, error) {
+	spec, ok := c.Items[itemType]
+	if !ok {
+		return nil, fmt.Errorf("unknown item type: %s", itemType)
+	}
+	return spec, nil
+}
+
+// SpeedUpCost returns the resource amount that finishes an upgrade with
+// remaining time left. Every started minute is charged.
+func (c *Catalog) SpeedUpCost(remaining time.Duration) int64 {
+	if remaining <= 0 {
+		return 0
+	}
+	minutes := int64((remaining + time.Minute - 1) / time.Minute)
+	return minutes * c.SpeedUp.CostPerMinute
+}
+
+// BuilderSlots returns how many upgrades a district can run in parallel
+func (c *Catalog) BuilderSlots(townHallLevel int) int {
+	slots := c.Construction.BuilderSlots
+	for _, level := range c.Construction.ExtraSlotTownHallLevels {
+		if townHallLevel >= level {
+			slots++
+		}
+	}
+	return slots
+}
+
+// Validate checks the catalog for inconsistencies
+func (c *Catalog) Validate() error {
+	if c.Version == "" {
+		return fmt.Errorf("catalog version is required")
+	}
+	if c.DemolishRefund < 0 || c.DemolishRefund > 1 {
+		return fmt.Errorf("demolish_refund must be between 0 and 1")
+	}
+	if c.Construction.BuilderSlots < 1 {
+		return fmt.Errorf("construction.builder_slots must be at least 1")
+	}
+	if c.Construction.MaxQueueLength < 0 {
+		return fmt.Errorf("construction.max_queue_length must not be negative")
+	}
+	if c.Construction.MaxLevelAboveTownHall < 0 {
+		return fmt.Errorf("construction.max_level_above_town_hall must not be negative")
+	}
+	if !isResourceType(c.SpeedUp.Resource) {
+		return fmt.Errorf("speedup.resource: unknown resource type %q", c.SpeedUp.Resource)
+	}
+	if c.SpeedUp.CostPerMinute < 1 {
+		return fmt.Errorf("speedup.cost_per_minute must be at least 1")
+	}
+	if c.Upkeep.FoodPerCitizen < 0 {
+		return fmt.Errorf("upkeep.food_per_citizen must not be negative")
+	}
+	if c.Upkeep.MinEfficiency < 0 || c.Upkeep.MinEfficiency > 100 {
+		return fmt.Errorf("upkeep.min_efficiency must be between 0 and 100")
+	}
+	if c.Population.GrowthPerHour < 0 {
+		return fmt.Errorf("population.growth_per_hour must not be negative")
+	}
+	if c.Population.UnstaffedRate < 0 || c.Population.UnstaffedRate > 1 {
+		return fmt.Errorf("population.unstaffed_rate must be between 0 and 1")
+	}
+	if c.Repair.InactiveBelow < 0 || c.Repair.InactiveBelow > 1 {
+		return fmt.Errorf("repair.inactive_below must be between 0 and 1")
+	}
+	if c.Repair.CostRatio < 0 {
+		return fmt.Errorf("repair.cost_ratio must not be negative")
+	}
+	for itemType, item := range c.Items {
+		if item == nil {
+			return fmt.Errorf("item %s: empty item spec", itemType)
+		}
+		if item.SpeedUp < 0 || item.Shield < 0 {
+			return fmt.Errorf("item %s: negative speedup or shield", itemType)
+		}
+	}
+	terrainShare := 0.0
+	for terrain, share := range c.Terrain {
+		if share < 0 {
+			return fmt.Errorf("terrain %s: negative share", terrain)
+		}
+		terrainShare += share
+	}
+	if terrainShare > 0.5 {
+		return fmt.Errorf("terrain must cover at most half of a district")
+	}
+	for i, rule := range c.Adjacency {
+		if _, ok := c.Buildings[rule.Building]; !ok {
+			return fmt.Errorf("adjacency rule %d: %s is not in the catalog", i+1, rule.Building)
+		}
+		if (rule.Neighbor == "") == (rule.Terrain == "") {
+			return fmt.Errorf("adjacency rule %d: exactly one of neighbor and terrain is required", i+1)
+		}
+		if _, ok := c.Buildings[rule.Neighbor]; rule.Neighbor != "" && !ok {
+			return fmt.Errorf("adjacency rule %d: neighbor %s is not in the catalog", i+1, rule.Neighbor)
+		}
+		if _, ok := c.Terrain[rule.Terrain]; rule.Terrain != "" && !ok {
+			return fmt.Errorf("adjacency rule %d: unknown terrain %s", i+1, rule.Terrain)
+		}
+		if rule.Bonus <= 0 || rule.MaxBonus < 0 {
+			return fmt.Errorf("adjacency rule %d: bonus must be positive and max_bonus not negative", i+1)
+		}
+	}
+
+	townHall, ok := c.Buildings[models.BuildingTownHall]
+	if !ok {
+		return fmt.Errorf("catalog must define %s", models.BuildingTownHall)
+	}
+	for i, step := range c.Expansion {
+		if step.Size <= defaultGridSize || (i > 0 && step.Size <= c.Expansion[i-1].Size) {
+			return fmt.Errorf("expansion step %d: sizes must grow beyond %d", i+1, defaultGridSize)
+		}
+		if step.TownHallLevel < 1 || step.TownHallLevel > townHall.MaxLevel {
+			return fmt.Errorf("expansion step %d: town_hall_level %d is out of range", i+1, step.TownHallLevel)
+		}
+		if err := validateAmounts(step.Cost); err != nil {
+			return fmt.Errorf("expansion step %d cost: %w", i+1, err)
+		}
+	}
+	if c.Cities.NPCSlots < 1 {
+		return fmt.Errorf("cities.npc_slots must be at least 1")
+	}
+	if c.Cities.MaxPerGuild < 0 {
+		return fmt.Errorf("cities.max_per_guild must not be negative")
+	}
+	if len(c.Cities.Levels) == 0 {
+		return fmt.Errorf("cities.levels must define at least the founding")
+	}
+	for i, level := range c.Cities.Levels {
+		if level.Slots < 1 || (i > 0 && level.Slots < c.Cities.Levels[i-1].Slots) {
+			return fmt.Errorf("city level %d: slots must be at least 1 and must not shrink", i+1)
+		}
+		if err := validateAmounts(level.Cost); err != nil {
+			return fmt.Errorf("city level %d cost: %w", i+1, err)
+		}
+	}
+	if c.World.Width < 1 || c.World.Height < 1 {
+		return fmt.Errorf("world width and height must be at least 1")
+	}
+	if c.World.RegionSize < 1 || c.World.CitySpacing < 1 || c.World.MaxViewport < 1 {
+		return fmt.Errorf("world region_size, city_spacing and max_viewport must be at least 1")
+	}
+	if c.World.MaxPOILevel < 1 {
+		return fmt.Errorf("world.max_poi_level must be at least 1")
+	}
+	poiShare := 0.0
+	for poiType, share := range c.World.PointsOfInterest {
+		if share < 0 {
+			return fmt.Errorf("point of interest %s: negative share", poiType)
+		}
+		poiShare += share
+	}
+	if poiShare > 0.5 {
+		return fmt.Errorf("points of interest must cover at most half of the world")
+	}
+	for buildingType, defense := range c.Battle.Defense {
+		if _, ok := c.Buildings[buildingType]; !ok || defense < 0 {
+			return fmt.Errorf("battle defense: %s must be in the catalog and not negative", buildingType)
+		}
+	}
+	if c.Battle.PowerBand < 0 {
+		return fmt.Errorf("battle.power_band must not be negative")
+	}
+	if c.Battle.SearchLimit < 1 {
+		return fmt.Errorf("battle.search_limit must be at least 1")
+	}
+	if c.Battle.LootRatio < 0 || c.Battle.LootRatio > 1 {
+		return fmt.Errorf("battle.loot_ratio must be between 0 and 1")
+	}
+	if c.Battle.MaxTicks < 1 {
+		return fmt.Errorf("battle.max_ticks must be at least 1")
+	}
+	if c.Battle.Variance < 0 || c.Battle.Variance > 100 {
+		return fmt.Errorf("battle.variance must be between 0 and 100")
+	}
+	if c.Battle.WinDestruction <= 0 || c.Battle.WinDestruction > 1 {
+		return fmt.Errorf("battle.win_destruction must be above 0 and at most 1")
+	}
+	if c.Battle.Cooldown < 0 {
+		return fmt.Errorf("battle.cooldown must not be negative")
+	}
+	if c.Shield.Beginner < 0 || c.Shield.AfterAttack < 0 {
+		return fmt.Errorf("shield.beginner and shield.after_attack must not be negative")
+	}
+	if c.Shield.MaxDuration < c.Shield.Beginner || c.Shield.MaxDuration < c.Shield.AfterAttack {
+		return fmt.Errorf("shield.max_duration must cover the beginner and after_attack shields")
+	}
+	if c.GuildWar.Preparation < 0 || c.GuildWar.Duration <= 0 {
+		return fmt.Errorf("guild_war.preparation must not be negative and guild_war.duration must be positive")
+	}
+	if c.GuildWar.AttackPoints < 0 || c.GuildWar.DefensePoints < 0 {
+		return fmt.Errorf("guild_war.attack_points and guild_war.defense_points must not be negative")
+	}
+	if err := validateAmounts(c.GuildWar.Reward); err != nil {
+		return fmt.Errorf("guild_war reward: %w", err)
+	}
+	if c.Waves.Warning < 0 || c.Waves.Interval <= c.Waves.Warning {
+		return fmt.Errorf("waves.warning must not be negative and waves.interval must be longer")
+	}
+	if c.Waves.BasePower < 0 || c.Waves.DistrictPower < 0 {
+		return fmt.Errorf("waves.base_power and waves.district_power must not be negative")
+	}
+	if c.Waves.Damage < 0 || c.Waves.Damage > 1 || c.Waves.TroopLosses < 0 || c.Waves.TroopLosses > 1 {
+		return fmt.Errorf("waves.damage and waves.troop_losses must be between 0 and 1")
+	}
+	if err := validateAmounts(c.Waves.Reward); err != nil {
+		return fmt.Errorf("waves reward: %w", err)
+	}
+	if c.Market.Fee < 0 || c.Market.Fee >= 1 {
+		return fmt.Errorf("market.fee must be at least 0 and below 1")
+	}
+	if c.Market.MaxPrice < 1 || c.Market.MaxOpenOrders < 1 || c.Market.MaxFills < 1 {
+		return fmt.Errorf("market.max_price, market.max_open_orders and market.max_fills must be at least 1")
+	}
+	if c.Exchange.Window <= 0 || c.Exchange.HistoryInterval <= 0 {
+		return fmt.Errorf("exchange.window and exchange.history_interval must be positive")
+	}
+	if c.Exchange.Spread < 0 || c.Exchange.Spread >= 1 {
+		return fmt.Errorf("exchange.spread must be at least 0 and below 1")
+	}
+	if c.Exchange.DailyLimit < 1 || c.Exchange.HistoryLength < 1 {
+		return fmt.Errorf("exchange.daily_limit and exchange.history_length must be at least 1")
+	}
+	for resourceType, resource := range c.Exchange.Resources {
+		if resourceType == models.ResourceGold || !isResourceType(resourceType) {
+			return fmt.Errorf("exchange: %s can't be traded", resourceType)
+		}
+		if resource == nil {
+			return fmt.Errorf("exchange %s: empty resource spec", resourceType)
+		}
+		if resource.MinPrice <= 0 || resource.BasePrice < resource.MinPrice || resource.MaxPrice < resource.BasePrice {
+			return fmt.Errorf("exchange %s: prices must be positive with min_price <= base_price <= max_price", resourceType)
+		}
+		if resource.Depth < 1 {
+			return fmt.Errorf("exchange %s: depth must be at least 1", resourceType)
+		}
+	}
+	if c.Caravans.BaseTime < 0 || c.Caravans.TimePerCell < 0 {
+		return fmt.Errorf("caravans.base_time and caravans.time_per_cell must not be negative")
+	}
+	if market, ok := c.Buildings[models.BuildingMarket]; ok && len(c.Caravans.Capacity) != market.MaxLevel {
+		return fmt.Errorf("caravans.capacity must have one entry per market level")
+	}
+	for _, capacity := range c.Caravans.Capacity {
+		if capacity < 1 {
+			return fmt.Errorf("caravans.capacity entries must be at least 1")
+		}
+	}
+	if c.Caravans.MaxInFlight < 1 {
+		return fmt.Errorf("caravans.max_in_flight must be at least 1")
+	}
+	if c.Army.MaxQueueLength < 1 {
+		return fmt.Errorf("army.max_queue_length must be at least 1")
+	}
+	for troopType, troop := range c.Troops {
+		if troop == nil {
+			return fmt.Errorf("troop %s: empty troop spec", troopType)
+		}
+		barracks, ok := c.Buildings[models.BuildingBarracks]
+		if !ok || troop.BarracksLevel < 1 || troop.BarracksLevel > barracks.MaxLevel {
+			return fmt.Errorf("troop %s: barracks_level %d is out of range", troopType, troop.BarracksLevel)
+		}
+		if troop.Attack < 0 || troop.Health < 1 {
+			return fmt.Errorf("troop %s: attack must not be negative and health must be at least 1", troopType)
+		}
+		if troop.Duration < 0 || troop.FoodUpkeep < 0 {
+			return fmt.Errorf("troop %s: negative duration or food_upkeep", troopType)
+		}
+		if err := validateAmounts(troop.Cost); err != nil {
+			return fmt.Errorf("troop %s cost: %w", troopType, err)
+		}
+	}
+	// Every district has a Town Hall, so it provides the base storage
+	for i, level := range townHall.Levels {
+		for _, resourceType := range resourceTypes {
+			if level.Storage[resourceType] <= 0 {
+				return fmt.Errorf("%s level %d: storage for %s is required", models.BuildingTownHall, i+1, resourceType)
+			}
+		}
+	}
+
+	for buildingType, spec := range c.Buildings {
+		if spec == nil {
+			return fmt.Errorf("%s: empty building spec", buildingType)
+		}
+		if spec.MaxLevel < 1 {
+			return fmt.Errorf("%s: max_level must be at least 1", buildingType)
+		}
+		if len(spec.Levels) != spec.MaxLevel {
+			return fmt.Errorf("%s: expected %d levels, got %d", buildingType, spec.MaxLevel, len(spec.Levels))
+		}
+		if spec.TownHallLevel < 0 || spec.TownHallLevel > townHall.MaxLevel {
+			return fmt.Errorf("%s: town_hall_level %d is out of range", buildingType, spec.TownHallLevel)
+		}
+		for _, count := range spec.MaxCount {
+			if count < 0 {
+				return fmt.Errorf("%s: max_count must not be negative", buildingType)
+			}
+		}
+
+		for i, level := range spec.Levels {
+			if level.Duration < 0 {
+				return fmt.Errorf("%s level %d: negative duration", buildingType, i+1)
+			}
+			if level.Health < 1 {
+				return fmt.Errorf("%s level %d: health must be at least 1", buildingType, i+1)
+			}
+			if err := validateAmounts(level.Cost); err != nil {
+				return fmt.Errorf("%s level %d cost: %w", buildingType, i+1, err)
+			}
+			if err := validateAmounts(level.Production); err != nil {
+				return fmt.Errorf("%s level %d production: %w", buildingType, i+1, err)
+			}
+			if err := validateAmounts(level.Storage); err != nil {
+				return fmt.Errorf("%s level %d storage: %w", buildingType, i+1, err)
+			}
+			if err := validateAmounts(level.Upkeep); err != nil {
+				return fmt.Errorf("%s level %d upkeep: %w", buildingType, i+1, err)
+			}
+			if level.Housing < 0 || level.Workers < 0 || level.Troops < 0 {
+				return fmt.Errorf("%s level %d: negative housing, workers or troops", buildingType, i+1)
+			}
+		}
+
+		for _, prerequisite := range spec.Prerequisites {
+			required, ok := c.Buildings[prerequisite.Building]
+			if !ok {
+				return fmt.Errorf("%s: prerequisite %s is not in the catalog", buildingType, prerequisite.Building)
+			}
+			if prerequisite.Building == buildingType {
+				return fmt.Errorf("%s: building cannot require itself", buildingType)
+			}
+			if prerequisite.Level < 1 || prerequisite.Level > required.MaxLevel {
+				return fmt.Errorf("%s: prerequisite %s level %d is out of range", buildingType, prerequisite.Building, prerequisite.Level)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateAmounts(amounts map[models.ResourceType]int64) error {
+	for resourceType, amount := range amounts {
+		if !isResourceType(resourceType) {
+			return fmt.Errorf("unknown resource type %q", resourceType)
+		}
+		if amount < 0 {
+			return fmt.Errorf("negative amount for %s", resourceType)
+		}
+	}
+	return nil
+}
+
+func isResourceType(resourceType models.ResourceType) bool {
+	for _, known := range resourceTypes {
+		if resourceType == known {
+			return true
+		}
+	}
+	return false
+}
+
+// CatalogStore holds the active catalog and swaps it when the file changes
+type CatalogStore struct {
+	path    string
+	catalog *Catalog
+	mu      sync.RWMutex
+}
+
+// LoadCatalog reads and validates the catalog file at path
+func LoadCatalog(path string) (*CatalogStore, error) {
+	catalog, err := readCatalog(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CatalogStore{
+		path:    path,
+		catalog: catalog,
+	}, nil
+}
+
+// Get returns the active catalog
+func (s *CatalogStore) Get() *Catalog {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.catalog
+}
+
+// Reload re-reads the catalog file. The active catalog is kept if the new
+// one fails validation.
+func (s *CatalogStore) Reload() error {
+	catalog, err := readCatalog(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.catalog = catalog
+	s.mu.Unlock()
+
+	logger.Infof("Building catalog reloaded: version %s", catalog.Version)
+	return nil
+}
+
+// Watch reloads the catalog whenever its file changes
+func (s *CatalogStore) Watch() {
+	v := viper.New()
+	v.SetConfigFile(s.path)
+	if err := v.ReadInConfig(); err != nil {
+		logger.Errorf("Failed to watch building catalog: %v", err)
+		return
+	}
+
+	v.OnConfigChange(func(e fsnotify.Event) {
+		if err := s.Reload(); err != nil {
+			logger.Errorf("Failed to reload building catalog, keeping version %s: %v", s.Get().Version, err)
+		}
+	})
+	v.WatchConfig()
+}
+
+func readCatalog(path string) (*Catalog, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %w", err)
+	}
+
+	var catalog Catalog
+	if err := v.Unmarshal(&catalog); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal catalog: %w", err)
+	}
+
+	if err := catalog.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid catalog: %w", err)
+	}
+
+	return &catalog, nil
+}
@@ -0,0 +1,333 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/ton-empire/backend/pkg/logger"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// City operations
+
+// GetCity returns a city with its occupancy
+func (s *Service) GetCity(ctx context.Context, cityID uuid.UUID) (*City, error) {
+	city, err := s.repo.GetCity(ctx, cityID)
+	if err != nil {
+		return nil, err
+	}
+
+	city.Slots = s.catalog.Get().CitySlots(city)
+	return city, nil
+}
+
+// GetGuildCities returns the cities a guild founded
+func (s *Service) GetGuildCities(ctx context.Context, guildID uuid.UUID) ([]*City, error) {
+	cities, err := s.repo.GetCities(ctx, &guildID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cities: %w", err)
+	}
+
+	catalog := s.catalog.Get()
+	for _, city := range cities {
+		city.Slots = catalog.CitySlots(city)
+	}
+	if cities == nil {
+		cities = []*City{}
+	}
+	return cities, nil
+}
+
+// FoundCity founds a new city for a guild. The founding officer pays for it
+// from their district.
+func (s *Service) FoundCity(ctx context.Context, userID, guildID uuid.UUID, req FoundCityRequest) (*City, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	catalog := s.catalog.Get()
+	now := time.Now()
+	city := &City{
+		ID:        uuid.New(),
+		GuildID:   &guildID,
+		Name:      req.Name,
+		Level:     1,
+		Defense:   100,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockGuildTx(ctx, tx, guildID); err != nil {
+			return err
+		}
+		if err := s.checkGuildOfficer(ctx, tx, guildID, userID, "manage cities"); err != nil {
+			return err
+		}
+
+		cities, err := s.repo.GetCitiesTx(ctx, tx, &guildID)
+		if err != nil {
+			return err
+		}
+		if len(cities) >= catalog.Cities.MaxPerGuild {
+			return fmt.Errorf("guild already has the maximum of %d cities", catalog.Cities.MaxPerGuild)
+		}
+		for _, existing := range cities {
+			if existing.Name == req.Name {
+				return fmt.Errorf("guild already has a city named %s", req.Name)
+			}
+		}
+
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+		if _, err := s.spendResourcesTx(ctx, tx, district.ID, catalog.Cities.Levels[0].Cost,
+			LedgerReasonCityFounded, citySource(city.ID)); err != nil {
+			return err
+		}
+
+		if city.Position, err = s.placeCityTx(ctx, tx); err != nil {
+			return err
+		}
+		if err := s.repo.CreateCityTx(ctx, tx, city); err != nil {
+			return fmt.Errorf("failed to found city: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("City founded: %s by guild %s at %d,%d", city.Name, guildID, city.Position.X, city.Position.Y)
+	s.notifyCityUpdate(ctx, city.ID)
+
+	city.Slots = catalog.CitySlots(city)
+	return city, nil
+}
+
+// UpgradeCity raises a guild city to its next level, which holds more
+// districts. The upgrading officer pays for it from their district.
+func (s *Service) UpgradeCity(ctx context.Context, userID, cityID uuid.UUID) (*City, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	city, err := s.repo.GetCity(ctx, cityID)
+	if err != nil {
+		return nil, err
+	}
+	if city.GuildID == nil {
+		return nil, fmt.Errorf("NPC cities can't be upgraded")
+	}
+
+	catalog := s.catalog.Get()
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockGuildTx(ctx, tx, *city.GuildID); err != nil {
+			return err
+		}
+		if err := s.checkGuildOfficer(ctx, tx, *city.GuildID, userID, "manage cities"); err != nil {
+			return err
+		}
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+		if err := s.repo.LockCityTx(ctx, tx, city.ID); err != nil {
+			return err
+		}
+
+		city, err = s.repo.GetCityTx(ctx, tx, city.ID)
+		if err != nil {
+			return err
+		}
+		if city.Level >= len(catalog.Cities.Levels) {
+			return fmt.Errorf("city is already at max level")
+		}
+
+		if _, err := s.spendResourcesTx(ctx, tx, district.ID, catalog.Cities.Levels[city.Level].Cost,
+			LedgerReasonCityUpgrade, citySource(city.ID)); err != nil {
+			return err
+		}
+
+		city.Level++
+		if err := s.repo.SetCityLevelTx(ctx, tx, city.ID, city.Level); err != nil {
+			return fmt.Errorf("failed to upgrade city: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifyCityUpdate(ctx, city.ID)
+
+	city.Slots = catalog.CitySlots(city)
+	return city, nil
+}
+
+// RelocateDistrict moves a user's district into a city of their guild or an
+// NPC city with a free slot
+func (s *Service) RelocateDistrict(ctx context.Context, userID uuid.UUID, req RelocateDistrictRequest) (*City, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	catalog := s.catalog.Get()
+	var city *City
+	var previousCityID uuid.UUID
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+
+		current, err := s.repo.GetDistrictByIDTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		previousCityID = current.CityID
+		if current.CityID == req.CityID {
+			return fmt.Errorf("district is already in this city")
+		}
+
+		if err := s.repo.LockCityTx(ctx, tx, req.CityID); err != nil {
+			return err
+		}
+		city, err = s.repo.GetCityTx(ctx, tx, req.CityID)
+		if err != nil {
+			return err
+		}
+
+		if city.GuildID != nil {
+			guildID, err := s.repo.GetUserGuildIDTx(ctx, tx, userID)
+			if err != nil {
+				return err
+			}
+			if guildID == nil || *guildID != *city.GuildID {
+				return fmt.Errorf("city belongs to another guild")
+			}
+		}
+		if city.Districts >= catalog.CitySlots(city) {
+			return fmt.Errorf("city is full")
+		}
+
+		return s.repo.SetDistrictCityTx(ctx, tx, district.ID, city.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("District %s relocated to city %s", district.ID, city.ID)
+	s.notifyCityUpdate(ctx, previousCityID, city.ID)
+
+	city.Districts++
+	city.Slots = catalog.CitySlots(city)
+	return city, nil
+}
+
+// openCityTx locks and returns the oldest city of a guild that has a free
+// slot, or nil when they are all full. With a nil guildID it looks at the NPC
+// cities and opens a new one when those are all full.
+func (s *Service) openCityTx(ctx context.Context, tx *sqlx.Tx, guildID *uuid.UUID) (*City, error) {
+	// NPC cities are only looked at holding the world lock, so concurrent
+	// registrations can't both find them full and open two cities with the
+	// same name. The world is locked before any city.
+	if guildID == nil {
+		if err := s.repo.LockWorldTx(ctx, tx); err != nil {
+			return nil, fmt.Errorf("failed to lock world map: %w", err)
+		}
+	}
+
+	cities, err := s.repo.GetCitiesTx(ctx, tx, guildID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cities: %w", err)
+	}
+
+	catalog := s.catalog.Get()
+	for _, city := range cities {
+		if city.Districts >= catalog.CitySlots(city) {
+			continue
+		}
+
+		// Count again once no one else can move in
+		if err := s.repo.LockCityTx(ctx, tx, city.ID); err != nil {
+			return nil, err
+		}
+		locked, err := s.repo.GetCityTx(ctx, tx, city.ID)
+		if err != nil {
+			return nil, err
+		}
+		if locked.Districts < catalog.CitySlots(locked) {
+			return locked, nil
+		}
+	}
+
+	if guildID != nil {
+		return nil, nil
+	}
+
+	now := time.Now()
+	city := &City{
+		ID:        uuid.New(),
+		Name:      fmt.Sprintf("Free City %d", len(cities)+1),
+		Level:     1,
+		Defense:   100,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if city.Position, err = s.placeCityTx(ctx, tx); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateCityTx(ctx, tx, city); err != nil {
+		return nil, fmt.Errorf("failed to open NPC city: %w", err)
+	}
+
+	logger.Infof("NPC city opened: %s", city.Name)
+	return city, nil
+}
+
+// checkGuildOfficer rejects users who aren't the emperor or a governor of a
+// guild. action names what they tried to do in the error.
+func (s *Service) checkGuildOfficer(ctx context.Context, tx *sqlx.Tx, guildID, userID uuid.UUID, action string) error {
+	role, err := s.repo.GetGuildRoleTx(ctx, tx, guildID, userID)
+	if err != nil {
+		return err
+	}
+	if role != models.GuildRoleEmperor && role != models.GuildRoleGovernor {
+		return fmt.Errorf("only the emperor and governors can %s", action)
+	}
+	return nil
+}
+
+func citySource(cityID uuid.UUID) LedgerSource {
+	return LedgerSource{Type: LedgerSourceCity, ID: cityID}
+}
+
+// City holds the districts of a guild's members. NPC cities have no guild
+// and hold the districts of guildless players.
+type City struct {
+	ID         uuid.UUID  `db:"id" json:"id"`
+	GuildID    *uuid.UUID `db:"guild_id" json:"guild_id"`
+	Name       string     `db:"name" json:"name"`
+	Level      int        `db:"level" json:"level"`
+	Population int        `db:"population" json:"population"`
+	Defense    float64    `db:"defense" json:"defense"`
+	// Location on the world map
+	Position  models.Position `db:"position" json:"position"`
+	Districts int             `db:"districts" json:"districts"`
+	Slots     int             `db:"-" json:"slots"`
+	CreatedAt time.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time       `db:"updated_at" json:"updated_at"`
+}
+
+type FoundCityRequest struct {
+	Name string `json:"name" binding:"required,min=3,max=50"`
+}
+
+type RelocateDistrictRequest struct {
+	CityID uuid.UUID `json:"city_id" binding:"required"`
+}
@@ -0,0 +1,93 @@
+package game
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// City operations
+
+const cityColumns = `
+	c.id, c.guild_id, c.name, c.level, c.defense, c.created_at, c.updated_at,
+	c.x AS "position.x", c.y AS "position.y",
+	(SELECT COUNT(*) FROM districts d WHERE d.city_id = c.id) AS districts,
+	(SELECT COALESCE(SUM(d.population), 0) FROM districts d WHERE d.city_id = c.id) AS population`
+
+func (r *Repository) GetCity(ctx context.Context, cityID uuid.UUID) (*City, error) {
+	return getCity(ctx, r.db, cityID)
+}
+
+// GetCityTx reads a city as part of a transaction. Lock the city first to
+// get a district count that stays valid until the transaction ends.
+func (r *Repository) GetCityTx(ctx context.Context, tx *sqlx.Tx, cityID uuid.UUID) (*City, error) {
+	return getCity(ctx, tx, cityID)
+}
+
+func getCity(ctx context.Context, q sqlx.QueryerContext, cityID uuid.UUID) (*City, error) {
+	var city City
+	err := sqlx.GetContext(ctx, q, &city, `SELECT `+cityColumns+` FROM cities c WHERE c.id = $1`, cityID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("city not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &city, nil
+}
+
+// GetCities returns the cities of a guild, or the NPC cities when guildID is
+// nil, oldest first
+func (r *Repository) GetCities(ctx context.Context, guildID *uuid.UUID) ([]*City, error) {
+	return getCities(ctx, r.db, guildID)
+}
+
+// GetCitiesTx reads the cities of a guild as part of a transaction
+func (r *Repository) GetCitiesTx(ctx context.Context, tx *sqlx.Tx, guildID *uuid.UUID) ([]*City, error) {
+	return getCities(ctx, tx, guildID)
+}
+
+func getCities(ctx context.Context, q sqlx.QueryerContext, guildID *uuid.UUID) ([]*City, error) {
+	query := `
+		SELECT ` + cityColumns + `
+		FROM cities c
+		WHERE c.guild_id IS NOT DISTINCT FROM $1
+		ORDER BY c.created_at, c.id`
+
+	var cities []*City
+	if err := sqlx.SelectContext(ctx, q, &cities, query, guildID); err != nil {
+		return nil, err
+	}
+	return cities, nil
+}
+
+// LockCityTx locks a city row until tx ends, serializing districts moving in
+func (r *Repository) LockCityTx(ctx context.Context, tx *sqlx.Tx, cityID uuid.UUID) error {
+	var id uuid.UUID
+	err := tx.QueryRowContext(ctx,
+		`SELECT id FROM cities WHERE id = $1 FOR UPDATE`, cityID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("city not found")
+	}
+	return err
+}
+
+func (r *Repository) CreateCityTx(ctx context.Context, tx *sqlx.Tx, city *City) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO cities (id, guild_id, name, level, defense, x, y, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		city.ID, city.GuildID, city.Name, city.Level, city.Defense,
+		city.Position.X, city.Position.Y, city.CreatedAt, city.UpdatedAt)
+	return err
+}
+
+// SetCityLevelTx stores an upgraded city level as part of a transaction
+func (r *Repository) SetCityLevelTx(ctx context.Context, tx *sqlx.Tx, cityID uuid.UUID, level int) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE cities SET level = $1 WHERE id = $2`,
+		level, cityID)
+	return err
+}
@@ -0,0 +1,246 @@
+//go:build integration
+
+package game
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ton-empire/backend/internal/common/cache"
+	"github.com/ton-empire/backend/internal/common/config"
+	"github.com/ton-empire/backend/internal/common/database"
+	"github.com/ton-empire/backend/internal/common/events"
+	"github.com/ton-empire/backend/pkg/logger"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// These tests run against the Postgres and Redis of config/config.yaml with
+// the migrations applied, e.g. after make docker-up migrate-up. The players
+// they create are deleted afterwards; their ledger entries are kept, as for
+// any deleted player.
+
+func newIntegrationService(t *testing.T) (*Service, *database.DB) {
+	t.Helper()
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if err := logger.Init(cfg.Logging.Level, cfg.Logging.Format); err != nil {
+		t.Fatalf("failed to initialize logger: %v", err)
+	}
+
+	db, err := database.NewPostgresDB(cfg.Database.Postgres)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	redisCache, err := cache.NewRedisCache(cfg.Redis)
+	if err != nil {
+		t.Fatalf("failed to connect to Redis: %v", err)
+	}
+	t.Cleanup(func() { redisCache.Close() })
+
+	catalog, err := LoadCatalog("../../config/catalog.yaml")
+	if err != nil {
+		t.Fatalf("failed to load catalog: %v", err)
+	}
+
+	return NewService(NewRepository(db), catalog, events.NewPublisher(redisCache)), db
+}
+
+// createIntegrationPlayer registers a user with a starter district
+func createIntegrationPlayer(t *testing.T, s *Service, db *database.DB) (uuid.UUID, *models.District) {
+	t.Helper()
+	ctx := context.Background()
+
+	userID := uuid.New()
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO users (id, telegram_id, username, first_name)
+		VALUES ($1, $2, $3, 'Test')`,
+		userID, time.Now().UnixNano(), fmt.Sprintf("test_%s", userID.String()[:8]))
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := db.ExecContext(ctx, `DELETE FROM districts WHERE owner_id = $1`, userID); err != nil {
+			t.Errorf("failed to delete district: %v", err)
+		}
+		if _, err := db.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID); err != nil {
+			t.Errorf("failed to delete user: %v", err)
+		}
+	})
+
+	district, err := s.GetUserDistrict(ctx, userID)
+	if err != nil {
+		t.Fatalf("failed to create district: %v", err)
+	}
+	return userID, district
+}
+
+func getIntegrationResources(t *testing.T, db *database.DB, districtID uuid.UUID) map[models.ResourceType]int64 {
+	t.Helper()
+
+	rows, err := db.QueryContext(context.Background(),
+		`SELECT resource_type, amount FROM district_resources WHERE district_id = $1`, districtID)
+	if err != nil {
+		t.Fatalf("failed to get resources: %v", err)
+	}
+	defer rows.Close()
+
+	resources := make(map[models.ResourceType]int64)
+	for rows.Next() {
+		var resourceType models.ResourceType
+		var amount int64
+		if err := rows.Scan(&resourceType, &amount); err != nil {
+			t.Fatalf("failed to scan resources: %v", err)
+		}
+		resources[resourceType] = amount
+	}
+	return resources
+}
+
+// getIntegrationLedger sums the ledger entries of a district by reason and
+// resource, and counts them
+func getIntegrationLedger(t *testing.T, db *database.DB, districtID uuid.UUID) (map[string]int64, int) {
+	t.Helper()
+
+	rows, err := db.QueryContext(context.Background(), `
+		SELECT reason, resource_type, delta
+		FROM resource_ledger
+		WHERE district_id = $1 AND reason IN ($2, $3)`,
+		districtID, LedgerReasonProduction, LedgerReasonUpkeep)
+	if err != nil {
+		t.Fatalf("failed to get ledger: %v", err)
+	}
+	defer rows.Close()
+
+	sums := make(map[string]int64)
+	entries := 0
+	for rows.Next() {
+		var reason, resourceType string
+		var delta int64
+		if err := rows.Scan(&reason, &resourceType, &delta); err != nil {
+			t.Fatalf("failed to scan ledger: %v", err)
+		}
+		sums[reason+"/"+resourceType] += delta
+		entries++
+	}
+	return sums, entries
+}
+
+// placeIntegrationFarm constructs a Farm on the first open cell of a
+// district, so it has production to collect
+func placeIntegrationFarm(t *testing.T, s *Service, userID uuid.UUID, district *models.District) {
+	t.Helper()
+
+	catalog := s.catalog.Get()
+	for y := 0; y < defaultGridSize; y++ {
+		for x := 0; x < defaultGridSize; x++ {
+			position := models.Position{X: x, Y: y}
+			if position == starterTownHallPosition || catalog.terrainAt(district.ID, defaultGridSize, position) != "" {
+				continue
+			}
+			req := CreateBuildingRequest{Type: models.BuildingFarm, Position: position}
+			if _, err := s.CreateBuilding(context.Background(), userID, req); err != nil {
+				t.Fatalf("failed to place farm: %v", err)
+			}
+			return
+		}
+	}
+	t.Fatal("district has no open cell for a farm")
+}
+
+// TestCollectResourcesConcurrent checks that concurrent collections of one
+// district credit its production and charge its upkeep only once
+func TestCollectResourcesConcurrent(t *testing.T) {
+	const collectors = 10
+
+	s, db := newIntegrationService(t)
+	ctx := context.Background()
+
+	controlUserID, control := createIntegrationPlayer(t, s, db)
+	userID, district := createIntegrationPlayer(t, s, db)
+	placeIntegrationFarm(t, s, controlUserID, control)
+	placeIntegrationFarm(t, s, userID, district)
+
+	// Both districts last collected two hours ago
+	lastCollected := time.Now().Add(-2 * time.Hour)
+	for _, districtID := range []uuid.UUID{control.ID, district.ID} {
+		result, err := db.ExecContext(ctx, `
+			UPDATE building_production SET last_collected = $2
+			WHERE building_id IN (SELECT id FROM buildings WHERE district_id = $1)`,
+			districtID, lastCollected)
+		if err != nil {
+			t.Fatalf("failed to backdate production: %v", err)
+		}
+		if backdated, err := result.RowsAffected(); err != nil || backdated == 0 {
+			t.Fatalf("backdated %d production rows (%v), want the farm's", backdated, err)
+		}
+		if _, err := db.ExecContext(ctx,
+			`UPDATE districts SET last_upkeep_at = $2 WHERE id = $1`, districtID, lastCollected); err != nil {
+			t.Fatalf("failed to backdate upkeep: %v", err)
+		}
+	}
+
+	if _, err := s.CollectResources(ctx, controlUserID); err != nil {
+		t.Fatalf("failed to collect control district: %v", err)
+	}
+
+	before := getIntegrationResources(t, db, district.ID)
+	ledgerBefore, _ := getIntegrationLedger(t, db, district.ID)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, collectors)
+	for i := 0; i < collectors; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.CollectResources(ctx, userID); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent collect failed: %v", err)
+	}
+
+	wantLedger, wantEntries := getIntegrationLedger(t, db, control.ID)
+	gotLedger, gotEntries := getIntegrationLedger(t, db, district.ID)
+	if wantLedger[string(LedgerReasonProduction)+"/"+string(models.ResourceFood)] <= 0 {
+		t.Fatal("a single collect credited no food; the farm produced nothing")
+	}
+	if gotEntries != wantEntries {
+		t.Errorf("ledger has %d production and upkeep entries, want %d as after a single collect", gotEntries, wantEntries)
+	}
+	for key, want := range wantLedger {
+		if got := gotLedger[key]; got != want {
+			t.Errorf("ledger %s = %d, want %d as after a single collect", key, got, want)
+		}
+	}
+
+	// The balances moved by exactly what the ledger recorded, and ended where
+	// a single collect leaves them
+	after := getIntegrationResources(t, db, district.ID)
+	wantResources := getIntegrationResources(t, db, control.ID)
+	for resourceType, balance := range after {
+		var delta int64
+		for _, reason := range []LedgerReason{LedgerReasonProduction, LedgerReasonUpkeep} {
+			key := string(reason) + "/" + string(resourceType)
+			delta += gotLedger[key] - ledgerBefore[key]
+		}
+		if balance-before[resourceType] != delta {
+			t.Errorf("%s balance moved by %d, but the ledger recorded %d", resourceType, balance-before[resourceType], delta)
+		}
+		if want := wantResources[resourceType]; balance != want {
+			t.Errorf("%s balance = %d, want %d as after a single collect", resourceType, balance, want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// Damage and repair operations
+
+// DamageBuilding lowers a building's health, e.g. after a battle or a world
+// event. Below the catalog threshold the building stops working; at zero
+// health it's destroyed and stays a ruin until it's repaired.
+func (s *Service) DamageBuilding(ctx context.Context, buildingID uuid.UUID, damage float64) (*models.Building, error) {
+	building, err := s.repo.GetBuildingByID(ctx, buildingID)
+	if err != nil {
+		return nil, err
+	}
+	district, err := s.repo.GetDistrictByID(ctx, building.DistrictID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+
+		building, err = s.damageBuildingTx(ctx, tx, district.ID, buildingID, damage)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifyBuildingUpdate(ctx, district.OwnerID, building)
+
+	return building, nil
+}
+
+// damageBuildingTx applies damage to a building of a locked district
+func (s *Service) damageBuildingTx(ctx context.Context, tx *sqlx.Tx, districtID, buildingID uuid.UUID, damage float64) (*models.Building, error) {
+	if damage < 0 {
+		return nil, fmt.Errorf("damage must not be negative")
+	}
+
+	buildings, err := s.repo.GetBuildingsByDistrictTx(ctx, tx, districtID)
+	if err != nil {
+		return nil, err
+	}
+	building := findBuilding(buildings, buildingID)
+	if building == nil {
+		return nil, fmt.Errorf("building not found")
+	}
+
+	setHealth(building, building.Health-damage, s.catalog.Get().Repair.InactiveBelow)
+	if err := s.repo.UpdateBuildingTx(ctx, tx, building); err != nil {
+		return nil, fmt.Errorf("failed to update building: %w", err)
+	}
+
+	return building, nil
+}
+
+// RepairBuilding restores a building to full health for resources
+// proportional to the health it's missing
+func (s *Service) RepairBuilding(ctx context.Context, userID uuid.UUID, buildingID uuid.UUID) (*RepairResult, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	catalog := s.catalog.Get()
+	var result *RepairResult
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+
+		buildings, err := s.repo.GetBuildingsByDistrictTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		building := findBuilding(buildings, buildingID)
+		if building == nil {
+			return fmt.Errorf("building not found")
+		}
+		if building.Health >= building.MaxHealth {
+			return fmt.Errorf("building is not damaged")
+		}
+
+		cost, err := catalog.RepairCost(building)
+		if err != nil {
+			return err
+		}
+
+		resources, err := s.spendResourcesTx(ctx, tx, district.ID, cost, LedgerReasonRepair, buildingSource(building.ID))
+		if err != nil {
+			return err
+		}
+
+		// A building produces nothing for the time it was out of order
+		if setHealth(building, building.MaxHealth, catalog.Repair.InactiveBelow) {
+			if err := s.repo.UpdateProductionCollectedTx(ctx, tx, building.ID, time.Now()); err != nil {
+				return err
+			}
+		}
+		if err := s.repo.UpdateBuildingTx(ctx, tx, building); err != nil {
+			return fmt.Errorf("failed to update building: %w", err)
+		}
+
+		result = &RepairResult{
+			Building:       building,
+			Cost:           cost,
+			TotalResources: resources,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifyBuildingUpdate(ctx, userID, result.Building)
+
+	return result, nil
+}
+
+// setHealth changes a building's health and activity and reports whether the
+// building came back into working order
+func setHealth(building *models.Building, health, inactiveBelow float64) bool {
+	building.Health = max(min(health, building.MaxHealth), 0)
+
+	wasActive := building.IsActive
+	building.IsActive = building.Health > 0 && building.Health >= building.MaxHealth*inactiveBelow
+	return !wasActive && building.IsActive
+}
+
+type RepairResult struct {
+	Building       *models.Building              `json:"building"`
+	Cost           map[models.ResourceType]int64 `json:"cost"`
+	TotalResources map[models.ResourceType]int64 `json:"total_resources"`
+}
@@ -0,0 +1,21 @@
+package game
+
+// Error codes clients can use to show a specific message
+const (
+	ErrCodePrerequisiteMissing   = "prerequisite_missing"
+	ErrCodeTownHallLevelRequired = "town_hall_level_required"
+	ErrCodeBuildingLimitReached  = "building_limit_reached"
+	ErrCodeLevelCapped           = "level_capped_by_town_hall"
+)
+
+// Error is a rule violation reported to the client with a machine readable
+// code and the values needed to explain it
+type Error struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"error"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
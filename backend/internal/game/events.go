@@ -0,0 +1,70 @@
+package game
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/ton-empire/backend/pkg/logger"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// WebSocket event types published by the game service
+const (
+	EventBuildingUpdate          = "building_update"
+	EventBuildingUpgradeComplete = "building_upgrade_complete"
+	EventCityUpdate              = "city_update"
+	EventBattleStarted           = "battle_started"
+	EventBattleEnded             = "battle_ended"
+	EventTrainingComplete        = "training_complete"
+	EventGuildWarDeclared        = "guild_war_declared"
+	EventGuildWarScore           = "guild_war_score"
+	EventGuildWarEnded           = "guild_war_ended"
+	EventGuildMembership         = "guild_membership"
+	EventWaveIncoming            = "wave_incoming"
+	EventWaveResult              = "wave_result"
+	EventMarketTradeCompleted    = "market_trade_completed"
+	EventCaravanDeparted         = "caravan_departed"
+	EventCaravanArrived          = "caravan_arrived"
+)
+
+// notifyBuildingUpdate sends the current state of a building to its owner
+func (s *Service) notifyBuildingUpdate(ctx context.Context, ownerID uuid.UUID, building *models.Building) {
+	if err := s.events.PublishToUser(ctx, ownerID, EventBuildingUpdate, building); err != nil {
+		logger.Errorf("Failed to publish building update for %s: %v", building.ID, err)
+	}
+}
+
+// notifyGuildMembership tells a user which guild they belong to now, so the
+// gateway moves their connections to its room
+func (s *Service) notifyGuildMembership(ctx context.Context, userID uuid.UUID, guildID *uuid.UUID) {
+	data := map[string]*uuid.UUID{"guild_id": guildID}
+	if err := s.events.PublishToUser(ctx, userID, EventGuildMembership, data); err != nil {
+		logger.Errorf("Failed to publish guild membership of %s: %v", userID, err)
+	}
+}
+
+// notifyBattle tells both sides of a battle that it started and how it ended
+func (s *Service) notifyBattle(ctx context.Context, battle *Battle) {
+	started := &BattleStarted{
+		BattleID: battle.ID,
+		Attacker: s.battlePlayer(ctx, battle.AttackerID),
+		Defender: s.battlePlayer(ctx, battle.DefenderID),
+	}
+
+	for _, userID := range []uuid.UUID{battle.AttackerID, battle.DefenderID} {
+		if err := s.events.PublishToUser(ctx, userID, EventBattleStarted, started); err != nil {
+			logger.Errorf("Failed to publish battle start for %s: %v", battle.ID, err)
+		}
+		if err := s.events.PublishToUser(ctx, userID, EventBattleEnded, battle); err != nil {
+			logger.Errorf("Failed to publish battle end for %s: %v", battle.ID, err)
+		}
+	}
+}
+
+func (s *Service) battlePlayer(ctx context.Context, userID uuid.UUID) BattlePlayer {
+	username, err := s.repo.GetUsername(ctx, userID)
+	if err != nil {
+		logger.Errorf("Failed to get username of %s: %v", userID, err)
+	}
+	return BattlePlayer{ID: userID, Username: username}
+}
@@ -0,0 +1,257 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// NPC exchange operations
+
+// exchangeDay is how often the daily trade limits reset, at midnight UTC
+const exchangeDay = 24 * time.Hour
+
+// GetExchange returns the prices of the exchange and how much more of each
+// resource a user may trade today
+func (s *Service) GetExchange(ctx context.Context, userID uuid.UUID) (*Exchange, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	catalog := s.catalog.Get()
+	now := time.Now()
+	day := now.UTC().Truncate(exchangeDay)
+
+	volumes, err := s.repo.GetExchangeVolumes(ctx, now.Add(-catalog.Exchange.Window))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exchange volumes: %w", err)
+	}
+	traded, err := s.repo.GetDistrictExchangeVolumes(ctx, district.ID, day)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get traded volumes: %w", err)
+	}
+
+	exchange := &Exchange{Quotes: []*ExchangeQuote{}, ResetsAt: day.Add(exchangeDay)}
+	for _, resourceType := range resourceTypes {
+		resource, ok := catalog.Exchange.Resources[resourceType]
+		if !ok {
+			continue
+		}
+		price := exchangePrice(resource, volumes[resourceType])
+		exchange.Quotes = append(exchange.Quotes, &ExchangeQuote{
+			ResourceType: resourceType,
+			Price:        price,
+			BuyPrice:     price * (1 + catalog.Exchange.Spread/2),
+			SellPrice:    price * (1 - catalog.Exchange.Spread/2),
+			NetVolume:    volumes[resourceType],
+			Traded:       traded[resourceType],
+			Remaining:    max(catalog.Exchange.DailyLimit-traded[resourceType], 0),
+		})
+	}
+	return exchange, nil
+}
+
+// TradeWithExchange buys a resource from the exchange or sells one to it
+// for gold. A trade is priced halfway through its own volume, so splitting
+// it up doesn't get a better price, and fails if that's worse than the
+// request's limit price.
+func (s *Service) TradeWithExchange(ctx context.Context, userID uuid.UUID, req ExchangeRequest) (*ExchangeTrade, error) {
+	catalog := s.catalog.Get()
+	resource, ok := catalog.Exchange.Resources[req.ResourceType]
+	if !ok {
+		return nil, fmt.Errorf("the exchange doesn't trade %s", req.ResourceType)
+	}
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	now := time.Now()
+	trade := &ExchangeTrade{
+		ID:           uuid.New(),
+		DistrictID:   district.ID,
+		Side:         req.Side,
+		ResourceType: req.ResourceType,
+		Quantity:     req.Quantity,
+		CreatedAt:    now,
+	}
+	source := LedgerSource{Type: LedgerSourceExchangeTrade, ID: trade.ID}
+
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockExchangeTx(ctx, tx, req.ResourceType); err != nil {
+			return err
+		}
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+
+		traded, err := s.repo.GetDistrictExchangeVolumeTx(ctx, tx, district.ID, req.ResourceType, now.UTC().Truncate(exchangeDay))
+		if err != nil {
+			return err
+		}
+		if traded+req.Quantity > catalog.Exchange.DailyLimit {
+			return fmt.Errorf("you can trade %d more %s on the exchange today",
+				max(catalog.Exchange.DailyLimit-traded, 0), req.ResourceType)
+		}
+
+		volume, err := s.repo.GetExchangeVolumeTx(ctx, tx, req.ResourceType, now.Add(-catalog.Exchange.Window))
+		if err != nil {
+			return err
+		}
+
+		if req.Side == MarketBuy {
+			trade.Price = exchangePrice(resource, volume+req.Quantity/2) * (1 + catalog.Exchange.Spread/2)
+			trade.Gold = int64(math.Ceil(trade.Price * float64(req.Quantity)))
+			if req.LimitPrice > 0 && trade.Price > req.LimitPrice {
+				return fmt.Errorf("the exchange asks %.2f gold per %s, above your limit", trade.Price, req.ResourceType)
+			}
+
+			cost := map[models.ResourceType]int64{models.ResourceGold: trade.Gold}
+			if _, err := s.spendResourcesTx(ctx, tx, district.ID, cost, LedgerReasonExchange, source); err != nil {
+				return err
+			}
+			bought := map[models.ResourceType]int64{req.ResourceType: req.Quantity}
+			if _, err := s.changeResourcesTx(ctx, tx, district.ID, bought, LedgerReasonExchange, source); err != nil {
+				return err
+			}
+			volume += req.Quantity
+		} else {
+			trade.Price = exchangePrice(resource, volume-req.Quantity/2) * (1 - catalog.Exchange.Spread/2)
+			trade.Gold = int64(math.Floor(trade.Price * float64(req.Quantity)))
+			if req.LimitPrice > 0 && trade.Price < req.LimitPrice {
+				return fmt.Errorf("the exchange pays %.2f gold per %s, below your limit", trade.Price, req.ResourceType)
+			}
+			if trade.Gold < 1 {
+				return fmt.Errorf("too little %s to sell", req.ResourceType)
+			}
+
+			sold := map[models.ResourceType]int64{req.ResourceType: req.Quantity}
+			if _, err := s.spendResourcesTx(ctx, tx, district.ID, sold, LedgerReasonExchange, source); err != nil {
+				return err
+			}
+			proceeds := map[models.ResourceType]int64{models.ResourceGold: trade.Gold}
+			if _, err := s.changeResourcesTx(ctx, tx, district.ID, proceeds, LedgerReasonExchange, source); err != nil {
+				return err
+			}
+			volume -= req.Quantity
+		}
+
+		if err := s.repo.CreateExchangeTradeTx(ctx, tx, trade); err != nil {
+			return fmt.Errorf("failed to record trade: %w", err)
+		}
+		bucket := now.Truncate(catalog.Exchange.HistoryInterval)
+		if err := s.repo.RecordExchangePriceTx(ctx, tx, req.ResourceType, bucket, exchangePrice(resource, volume)); err != nil {
+			return fmt.Errorf("failed to record price: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return trade, nil
+}
+
+// GetExchangeHistory returns the price history of a resource on the
+// exchange, oldest first
+func (s *Service) GetExchangeHistory(ctx context.Context, resourceType models.ResourceType) ([]*ExchangePricePoint, error) {
+	catalog := s.catalog.Get()
+	if _, ok := catalog.Exchange.Resources[resourceType]; !ok {
+		return nil, fmt.Errorf("the exchange doesn't trade %s", resourceType)
+	}
+
+	interval := catalog.Exchange.HistoryInterval
+	since := time.Now().Truncate(interval).Add(-interval * time.Duration(catalog.Exchange.HistoryLength-1))
+	points, err := s.repo.GetExchangePrices(ctx, resourceType, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get price history: %w", err)
+	}
+	return points, nil
+}
+
+// OpenExchangePrices starts the current point of the price history of
+// every resource at its price, so quiet intervals show up in the history
+// too. It returns when the next point starts.
+func (s *Service) OpenExchangePrices(ctx context.Context) (time.Time, error) {
+	catalog := s.catalog.Get()
+	now := time.Now()
+	bucket := now.Truncate(catalog.Exchange.HistoryInterval)
+
+	volumes, err := s.repo.GetExchangeVolumes(ctx, now.Add(-catalog.Exchange.Window))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get exchange volumes: %w", err)
+	}
+	for resourceType, resource := range catalog.Exchange.Resources {
+		price := exchangePrice(resource, volumes[resourceType])
+		if err := s.repo.OpenExchangePrice(ctx, resourceType, bucket, price); err != nil {
+			return time.Time{}, fmt.Errorf("failed to open %s price: %w", resourceType, err)
+		}
+	}
+
+	return bucket.Add(catalog.Exchange.HistoryInterval), nil
+}
+
+// exchangePrice returns the price of a resource after players bought volume
+// units more than they sold over the window
+func exchangePrice(resource *ExchangeResource, volume int64) float64 {
+	price := resource.BasePrice * math.Exp2(float64(volume)/float64(resource.Depth))
+	return math.Min(math.Max(price, resource.MinPrice), resource.MaxPrice)
+}
+
+// Exchange is the state of the NPC exchange for a player
+type Exchange struct {
+	Quotes []*ExchangeQuote `json:"quotes"`
+	// When the daily trade limits reset
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+// ExchangeQuote prices a resource on the exchange, in gold per unit
+type ExchangeQuote struct {
+	ResourceType models.ResourceType `json:"resource_type"`
+	Price        float64             `json:"price"`
+	// What the exchange asks from buyers and pays sellers
+	BuyPrice  float64 `json:"buy_price"`
+	SellPrice float64 `json:"sell_price"`
+	// Net units players bought over the window; negative if they sold more
+	NetVolume int64 `json:"net_volume"`
+	// Units the player bought and sold today, and may still trade
+	Traded    int64 `json:"traded"`
+	Remaining int64 `json:"remaining"`
+}
+
+// ExchangeTrade is a purchase from or sale to the exchange
+type ExchangeTrade struct {
+	ID           uuid.UUID           `json:"id"`
+	DistrictID   uuid.UUID           `json:"district_id"`
+	Side         MarketSide          `json:"side"`
+	ResourceType models.ResourceType `json:"resource_type"`
+	Quantity     int64               `json:"quantity"`
+	// Gold per unit, and the gold paid or received in total
+	Price     float64   `json:"price"`
+	Gold      int64     `json:"gold"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ExchangePricePoint is the price of a resource over one interval of its
+// history
+type ExchangePricePoint struct {
+	Time  time.Time `json:"time"`
+	Open  float64   `json:"open"`
+	High  float64   `json:"high"`
+	Low   float64   `json:"low"`
+	Close float64   `json:"close"`
+}
+
+type ExchangeRequest struct {
+	Side         MarketSide          `json:"side" binding:"required,oneof=buy sell"`
+	ResourceType models.ResourceType `json:"resource_type" binding:"required"`
+	Quantity     int64               `json:"quantity" binding:"required,min=1,max=1000000000"`
+	// Worst price per unit the player accepts; 0 for any
+	LimitPrice float64 `json:"limit_price" binding:"omitempty,gt=0"`
+}
@@ -0,0 +1,159 @@
+package game
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// Exchange operations
+
+// exchangeLockKey namespaces the advisory locks serializing the trades of
+// each resource with the exchange
+const exchangeLockKey int32 = 0x786368
+
+// LockExchangeTx serializes trades of a resource with the exchange until tx
+// ends, so each one is priced after the last. The exchange is locked before
+// any district.
+func (r *Repository) LockExchangeTx(ctx context.Context, tx *sqlx.Tx, resourceType models.ResourceType) error {
+	_, err := tx.ExecContext(ctx,
+		`SELECT pg_advisory_xact_lock($1, hashtext($2))`, exchangeLockKey, string(resourceType))
+	return err
+}
+
+// GetExchangeVolumes returns the net units of each resource players bought
+// from the exchange since a time; negative if they sold more
+func (r *Repository) GetExchangeVolumes(ctx context.Context, since time.Time) (map[models.ResourceType]int64, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT resource_type, SUM(CASE WHEN side = 'buy' THEN quantity ELSE -quantity END)
+		FROM exchange_trades
+		WHERE created_at > $1
+		GROUP BY resource_type`,
+		since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanResourceVolumes(rows)
+}
+
+// GetExchangeVolumeTx returns the net units of a resource players bought
+// from the exchange since a time, as part of a transaction
+func (r *Repository) GetExchangeVolumeTx(ctx context.Context, tx *sqlx.Tx, resourceType models.ResourceType, since time.Time) (int64, error) {
+	var volume int64
+	err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(CASE WHEN side = 'buy' THEN quantity ELSE -quantity END), 0)
+		FROM exchange_trades
+		WHERE resource_type = $1 AND created_at > $2`,
+		resourceType, since).Scan(&volume)
+	return volume, err
+}
+
+// GetDistrictExchangeVolumes returns the units of each resource a district
+// bought and sold on the exchange since a time
+func (r *Repository) GetDistrictExchangeVolumes(ctx context.Context, districtID uuid.UUID, since time.Time) (map[models.ResourceType]int64, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT resource_type, SUM(quantity)
+		FROM exchange_trades
+		WHERE district_id = $1 AND created_at >= $2
+		GROUP BY resource_type`,
+		districtID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanResourceVolumes(rows)
+}
+
+// GetDistrictExchangeVolumeTx returns the units of a resource a district
+// bought and sold on the exchange since a time, as part of a transaction
+func (r *Repository) GetDistrictExchangeVolumeTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID, resourceType models.ResourceType, since time.Time) (int64, error) {
+	var volume int64
+	err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(quantity), 0)
+		FROM exchange_trades
+		WHERE district_id = $1 AND resource_type = $2 AND created_at >= $3`,
+		districtID, resourceType, since).Scan(&volume)
+	return volume, err
+}
+
+// CreateExchangeTradeTx stores a trade with the exchange as part of a
+// transaction
+func (r *Repository) CreateExchangeTradeTx(ctx context.Context, tx *sqlx.Tx, trade *ExchangeTrade) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO exchange_trades (id, district_id, side, resource_type, quantity, price, gold, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		trade.ID, trade.DistrictID, trade.Side, trade.ResourceType, trade.Quantity,
+		trade.Price, trade.Gold, trade.CreatedAt)
+	return err
+}
+
+// RecordExchangePriceTx adds a price to the history point of a resource
+// starting at bucket as part of a transaction, opening the point if needed
+func (r *Repository) RecordExchangePriceTx(ctx context.Context, tx *sqlx.Tx, resourceType models.ResourceType, bucket time.Time, price float64) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO exchange_prices (resource_type, bucket, open, high, low, close)
+		VALUES ($1, $2, $3, $3, $3, $3)
+		ON CONFLICT (resource_type, bucket) DO UPDATE
+		SET high = GREATEST(exchange_prices.high, EXCLUDED.high),
+		    low = LEAST(exchange_prices.low, EXCLUDED.low),
+		    close = EXCLUDED.close`,
+		resourceType, bucket, price)
+	return err
+}
+
+// OpenExchangePrice opens the history point of a resource starting at
+// bucket at a price, unless a trade or another replica opened it already
+func (r *Repository) OpenExchangePrice(ctx context.Context, resourceType models.ResourceType, bucket time.Time, price float64) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO exchange_prices (resource_type, bucket, open, high, low, close)
+		VALUES ($1, $2, $3, $3, $3, $3)
+		ON CONFLICT (resource_type, bucket) DO NOTHING`,
+		resourceType, bucket, price)
+	return err
+}
+
+// GetExchangePrices returns the price history of a resource since a time,
+// oldest first
+func (r *Repository) GetExchangePrices(ctx context.Context, resourceType models.ResourceType, since time.Time) ([]*ExchangePricePoint, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT bucket, open, high, low, close
+		FROM exchange_prices
+		WHERE resource_type = $1 AND bucket >= $2
+		ORDER BY bucket`,
+		resourceType, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []*ExchangePricePoint{}
+	for rows.Next() {
+		var point ExchangePricePoint
+		if err := rows.Scan(&point.Time, &point.Open, &point.High, &point.Low, &point.Close); err != nil {
+			return nil, err
+		}
+		points = append(points, &point)
+	}
+
+	return points, rows.Err()
+}
+
+func scanResourceVolumes(rows *sql.Rows) (map[models.ResourceType]int64, error) {
+	volumes := make(map[models.ResourceType]int64)
+	for rows.Next() {
+		var resourceType models.ResourceType
+		var volume int64
+		if err := rows.Scan(&resourceType, &volume); err != nil {
+			return nil, err
+		}
+		volumes[resourceType] = volume
+	}
+	return volumes, rows.Err()
+}
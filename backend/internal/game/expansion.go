@@ -0,0 +1,98 @@
+package game
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// Land expansion operations
+
+// GetExpansion returns a district's grid size and the next land expansion it
+// can buy
+func (s *Service) GetExpansion(ctx context.Context, userID uuid.UUID) (*ExpansionStatus, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	gridSize, err := s.repo.GetDistrictGridSize(ctx, district.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExpansionStatus{
+		GridSize: gridSize,
+		Next:     s.catalog.Get().NextExpansion(gridSize),
+	}, nil
+}
+
+// ExpandDistrict buys the next land expansion. The grid grows to the right
+// and down, so existing positions stay valid.
+func (s *Service) ExpandDistrict(ctx context.Context, userID uuid.UUID) (*ExpansionResult, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	catalog := s.catalog.Get()
+	var result *ExpansionResult
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+
+		gridSize, err := s.repo.GetDistrictGridSizeTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		step := catalog.NextExpansion(gridSize)
+		if step == nil {
+			return fmt.Errorf("district is fully expanded")
+		}
+
+		buildings, err := s.repo.GetBuildingsByDistrictTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		if townHallLevel(buildings) < step.TownHallLevel {
+			return fmt.Errorf("expansion requires %s level %d", models.BuildingTownHall, step.TownHallLevel)
+		}
+
+		resources, err := s.spendResourcesTx(ctx, tx, district.ID, step.Cost, LedgerReasonExpansion,
+			LedgerSource{Type: LedgerSourceDistrict, ID: district.ID})
+		if err != nil {
+			return err
+		}
+
+		if err := s.repo.SetDistrictGridSizeTx(ctx, tx, district.ID, step.Size); err != nil {
+			return fmt.Errorf("failed to expand district: %w", err)
+		}
+
+		result = &ExpansionResult{
+			GridSize:       step.Size,
+			Cost:           step.Cost,
+			TotalResources: resources,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+type ExpansionStatus struct {
+	GridSize int            `json:"grid_size"`
+	Next     *ExpansionStep `json:"next"`
+}
+
+type ExpansionResult struct {
+	GridSize       int                           `json:"grid_size"`
+	Cost           map[models.ResourceType]int64 `json:"cost"`
+	TotalResources map[models.ResourceType]int64 `json:"total_resources"`
+}
@@ -0,0 +1,79 @@
+package game
+
+import (
+	"fmt"
+
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// checkConstruction checks that a district with the given buildings may
+// construct another building of a type
+func checkConstruction(spec *BuildingSpec, buildingType models.BuildingType, buildings []*models.Building) error {
+	if err := checkPrerequisites(spec, buildings); err != nil {
+		return err
+	}
+
+	townHall := townHallLevel(buildings)
+	if townHall < spec.TownHallLevel {
+		return &Error{
+			Code:    ErrCodeTownHallLevelRequired,
+			Message: fmt.Sprintf("%s requires %s level %d", buildingType, models.BuildingTownHall, spec.TownHallLevel),
+			Details: map[string]interface{}{
+				"building":        buildingType,
+				"town_hall_level": townHall,
+				"required_level":  spec.TownHallLevel,
+			},
+		}
+	}
+
+	maxCount := spec.MaxCountAt(townHall)
+	if maxCount < 0 {
+		return nil
+	}
+	count := 0
+	for _, building := range buildings {
+		if building.Type == buildingType {
+			count++
+		}
+	}
+	if count >= maxCount {
+		return &Error{
+			Code:    ErrCodeBuildingLimitReached,
+			Message: fmt.Sprintf("%s level %d allows at most %d %s", models.BuildingTownHall, townHall, maxCount, buildingType),
+			Details: map[string]interface{}{
+				"building":        buildingType,
+				"town_hall_level": townHall,
+				"count":           count,
+				"max_count":       maxCount,
+			},
+		}
+	}
+
+	return nil
+}
+
+// checkLevelCap checks that a building may be upgraded to targetLevel with
+// the district's current Town Hall. The Town Hall itself is only limited by
+// its max level.
+func (c *Catalog) checkLevelCap(building *models.Building, targetLevel int, buildings []*models.Building) error {
+	if building.Type == models.BuildingTownHall {
+		return nil
+	}
+
+	townHall := townHallLevel(buildings)
+	maxLevel := townHall + c.Construction.MaxLevelAboveTownHall
+	if targetLevel > maxLevel {
+		return &Error{
+			Code:    ErrCodeLevelCapped,
+			Message: fmt.Sprintf("%s level %d caps buildings at level %d", models.BuildingTownHall, townHall, maxLevel),
+			Details: map[string]interface{}{
+				"building":        building.Type,
+				"target_level":    targetLevel,
+				"town_hall_level": townHall,
+				"max_level":       maxLevel,
+			},
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,237 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/ton-empire/backend/internal/common/events"
+	"github.com/ton-empire/backend/pkg/logger"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// Guild war operations
+
+const guildWarHistoryLimit = 20
+
+// Phases of a guild war
+const (
+	GuildWarPreparation = "preparation"
+	GuildWarActive      = "active"
+	GuildWarEnded       = "ended"
+)
+
+// GetGuildWars returns the latest wars of a guild, newest first
+func (s *Service) GetGuildWars(ctx context.Context, guildID uuid.UUID) ([]*GuildWar, error) {
+	wars, err := s.repo.GetGuildWars(ctx, guildID, guildWarHistoryLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get guild wars: %w", err)
+	}
+
+	now := time.Now()
+	for _, war := range wars {
+		war.Phase = war.phaseAt(now)
+	}
+	if wars == nil {
+		wars = []*GuildWar{}
+	}
+	return wars, nil
+}
+
+// DeclareWar declares war on another guild on behalf of a guild's emperor
+// or governor. The war starts after the catalog's preparation window. A
+// guild fights one war at a time.
+func (s *Service) DeclareWar(ctx context.Context, userID, guildID uuid.UUID, req DeclareWarRequest) (*GuildWar, error) {
+	if req.GuildID == guildID {
+		return nil, fmt.Errorf("a guild can't declare war on itself")
+	}
+
+	catalog := s.catalog.Get()
+	now := time.Now()
+	war := &GuildWar{
+		ID:              uuid.New(),
+		AttackerGuildID: guildID,
+		DefenderGuildID: req.GuildID,
+		DeclaredBy:      &userID,
+		Reward:          map[models.ResourceType]int64{},
+		DeclaredAt:      now,
+		StartsAt:        now.Add(catalog.GuildWar.Preparation),
+		EndsAt:          now.Add(catalog.GuildWar.Preparation + catalog.GuildWar.Duration),
+	}
+
+	err := s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.lockGuildsTx(ctx, tx, guildID, req.GuildID); err != nil {
+			return err
+		}
+		if err := s.checkGuildOfficer(ctx, tx, guildID, userID, "declare war"); err != nil {
+			return err
+		}
+
+		open, err := s.repo.GetOpenGuildWarTx(ctx, tx, guildID)
+		if err != nil {
+			return err
+		}
+		if open != nil {
+			return fmt.Errorf("your guild is already at war")
+		}
+		if open, err = s.repo.GetOpenGuildWarTx(ctx, tx, req.GuildID); err != nil {
+			return err
+		}
+		if open != nil {
+			return fmt.Errorf("that guild is already at war")
+		}
+
+		if err := s.repo.CreateGuildWarTx(ctx, tx, war); err != nil {
+			return fmt.Errorf("failed to declare war: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("Guild %s declared war on guild %s, starting at %s", guildID, req.GuildID, war.StartsAt)
+
+	war.Phase = war.phaseAt(now)
+	s.notifyGuildWar(ctx, EventGuildWarDeclared, war)
+	return war, nil
+}
+
+// EndDueGuildWars settles up to limit wars whose time has run out and
+// returns how many were settled. The guild with more points wins and the
+// catalog's reward is paid into its treasury; a tie has no winner.
+func (s *Service) EndDueGuildWars(ctx context.Context, limit int) (int, error) {
+	var ended []*GuildWar
+	reward := s.catalog.Get().GuildWar.Reward
+
+	err := s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		now := time.Now()
+		due, err := s.repo.LockDueGuildWars(ctx, tx, now, limit)
+		if err != nil {
+			return err
+		}
+
+		for _, war := range due {
+			war.EndedAt = &now
+			war.Reward = map[models.ResourceType]int64{}
+			switch {
+			case war.AttackerScore > war.DefenderScore:
+				war.WinnerGuildID = &war.AttackerGuildID
+			case war.DefenderScore > war.AttackerScore:
+				war.WinnerGuildID = &war.DefenderGuildID
+			}
+
+			if war.WinnerGuildID != nil {
+				for resourceType, amount := range reward {
+					war.Reward[resourceType] = amount
+				}
+				if err := s.repo.AddGuildTreasuryTx(ctx, tx, *war.WinnerGuildID, war.Reward); err != nil {
+					return fmt.Errorf("failed to reward guild war %s: %w", war.ID, err)
+				}
+			}
+			if err := s.repo.EndGuildWarTx(ctx, tx, war); err != nil {
+				return fmt.Errorf("failed to end guild war %s: %w", war.ID, err)
+			}
+			war.Phase = GuildWarEnded
+		}
+
+		ended = due
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, war := range ended {
+		s.notifyGuildWar(ctx, EventGuildWarEnded, war)
+	}
+
+	return len(ended), nil
+}
+
+// scoreGuildWarTx scores a battle for the winner's guild if the guilds of
+// its attacker and defender are at war. It returns the updated war, or nil
+// if the battle didn't count.
+func (s *Service) scoreGuildWarTx(ctx context.Context, tx *sqlx.Tx, battle *Battle, attackerGuildID, defenderGuildID uuid.UUID) (*GuildWar, error) {
+	war, err := s.repo.LockActiveGuildWarTx(ctx, tx, attackerGuildID, defenderGuildID, battle.CreatedAt)
+	if err != nil || war == nil {
+		return nil, err
+	}
+
+	catalog := s.catalog.Get()
+	guildID, points := defenderGuildID, catalog.GuildWar.DefensePoints
+	if battle.WinnerID == battle.AttackerID {
+		guildID, points = attackerGuildID, catalog.GuildWar.AttackPoints
+	}
+
+	if war, err = s.repo.AddGuildWarScoreTx(ctx, tx, war.ID, guildID, points); err != nil {
+		return nil, fmt.Errorf("failed to score guild war: %w", err)
+	}
+	war.Phase = GuildWarActive
+	battle.GuildWarID = &war.ID
+	return war, nil
+}
+
+// notifyGuildWar sends the state of a war to the rooms of both guilds
+func (s *Service) notifyGuildWar(ctx context.Context, eventType string, war *GuildWar) {
+	for _, guildID := range []uuid.UUID{war.AttackerGuildID, war.DefenderGuildID} {
+		if err := s.events.PublishToRoom(ctx, events.GuildRoomPrefix+guildID.String(), eventType, war); err != nil {
+			logger.Errorf("Failed to publish %s for guild war %s: %v", eventType, war.ID, err)
+		}
+	}
+}
+
+// lockGuildsTx locks several guilds in a fixed order, so transactions
+// locking the same guilds can't deadlock
+func (s *Service) lockGuildsTx(ctx context.Context, tx *sqlx.Tx, guildIDs ...uuid.UUID) error {
+	sorted := append([]uuid.UUID(nil), guildIDs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].String() < sorted[j].String()
+	})
+
+	for _, guildID := range sorted {
+		if err := s.repo.LockGuildTx(ctx, tx, guildID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GuildWar is a conflict between two guilds. Battles between their members
+// score points from StartsAt until EndsAt.
+type GuildWar struct {
+	ID              uuid.UUID  `json:"id"`
+	AttackerGuildID uuid.UUID  `json:"attacker_guild_id"`
+	DefenderGuildID uuid.UUID  `json:"defender_guild_id"`
+	DeclaredBy      *uuid.UUID `json:"declared_by"`
+	AttackerScore   int        `json:"attacker_score"`
+	DefenderScore   int        `json:"defender_score"`
+	// Nil until the war is settled, and for a draw
+	WinnerGuildID *uuid.UUID                    `json:"winner_guild_id"`
+	Reward        map[models.ResourceType]int64 `json:"reward"`
+	Phase         string                        `json:"phase"`
+	DeclaredAt    time.Time                     `json:"declared_at"`
+	StartsAt      time.Time                     `json:"starts_at"`
+	EndsAt        time.Time                     `json:"ends_at"`
+	EndedAt       *time.Time                    `json:"ended_at"`
+}
+
+// phaseAt returns the phase of a war at a time. A war stays active after
+// EndsAt until the scheduler settles it.
+func (w *GuildWar) phaseAt(now time.Time) string {
+	switch {
+	case w.EndedAt != nil:
+		return GuildWarEnded
+	case now.Before(w.StartsAt):
+		return GuildWarPreparation
+	default:
+		return GuildWarActive
+	}
+}
+
+type DeclareWarRequest struct {
+	GuildID uuid.UUID `json:"guild_id" binding:"required"`
+}
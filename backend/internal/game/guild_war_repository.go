@@ -0,0 +1,179 @@
+package game
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// Guild war operations
+
+const guildWarColumns = `
+	id, attacker_guild_id, defender_guild_id, declared_by, attacker_score, defender_score,
+	winner_guild_id, reward, declared_at, starts_at, ends_at, ended_at`
+
+// CreateGuildWarTx stores a declared war as part of a transaction
+func (r *Repository) CreateGuildWarTx(ctx context.Context, tx *sqlx.Tx, war *GuildWar) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO guild_wars (id, attacker_guild_id, defender_guild_id, declared_by,
+		                        declared_at, starts_at, ends_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		war.ID, war.AttackerGuildID, war.DefenderGuildID, war.DeclaredBy,
+		war.DeclaredAt, war.StartsAt, war.EndsAt)
+	return err
+}
+
+// GetOpenGuildWarTx returns the war a guild is preparing for or fighting, or
+// nil, as part of a transaction
+func (r *Repository) GetOpenGuildWarTx(ctx context.Context, tx *sqlx.Tx, guildID uuid.UUID) (*GuildWar, error) {
+	row := tx.QueryRowContext(ctx, `
+		SELECT `+guildWarColumns+`
+		FROM guild_wars
+		WHERE (attacker_guild_id = $1 OR defender_guild_id = $1) AND ended_at IS NULL
+		LIMIT 1`,
+		guildID)
+	war, err := scanGuildWar(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return war, err
+}
+
+// GetGuildWars returns the latest wars of a guild, newest first
+func (r *Repository) GetGuildWars(ctx context.Context, guildID uuid.UUID, limit int) ([]*GuildWar, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+guildWarColumns+`
+		FROM guild_wars
+		WHERE attacker_guild_id = $1 OR defender_guild_id = $1
+		ORDER BY declared_at DESC
+		LIMIT $2`,
+		guildID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var wars []*GuildWar
+	for rows.Next() {
+		war, err := scanGuildWar(rows)
+		if err != nil {
+			return nil, err
+		}
+		wars = append(wars, war)
+	}
+
+	return wars, rows.Err()
+}
+
+// LockActiveGuildWarTx returns the war two guilds are fighting at now,
+// locked until tx ends, or nil if they aren't at war
+func (r *Repository) LockActiveGuildWarTx(ctx context.Context, tx *sqlx.Tx, guildID, enemyID uuid.UUID, now time.Time) (*GuildWar, error) {
+	row := tx.QueryRowContext(ctx, `
+		SELECT `+guildWarColumns+`
+		FROM guild_wars
+		WHERE ((attacker_guild_id = $1 AND defender_guild_id = $2)
+		    OR (attacker_guild_id = $2 AND defender_guild_id = $1))
+		  AND ended_at IS NULL AND starts_at <= $3 AND ends_at > $3
+		LIMIT 1
+		FOR UPDATE`,
+		guildID, enemyID, now)
+	war, err := scanGuildWar(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return war, err
+}
+
+// AddGuildWarScoreTx adds points to the score of one side of a war as part
+// of a transaction and returns the updated war
+func (r *Repository) AddGuildWarScoreTx(ctx context.Context, tx *sqlx.Tx, warID, guildID uuid.UUID, points int) (*GuildWar, error) {
+	row := tx.QueryRowContext(ctx, `
+		UPDATE guild_wars
+		SET attacker_score = attacker_score + CASE WHEN attacker_guild_id = $2 THEN $3 ELSE 0 END,
+		    defender_score = defender_score + CASE WHEN defender_guild_id = $2 THEN $3 ELSE 0 END
+		WHERE id = $1
+		RETURNING `+guildWarColumns,
+		warID, guildID, points)
+	return scanGuildWar(row)
+}
+
+// LockDueGuildWars returns wars that ended before now but haven't been
+// settled, locked until tx ends. Rows locked by another game-service replica
+// are skipped, so every war is settled exactly once.
+func (r *Repository) LockDueGuildWars(ctx context.Context, tx *sqlx.Tx, now time.Time, limit int) ([]*GuildWar, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT `+guildWarColumns+`
+		FROM guild_wars
+		WHERE ended_at IS NULL AND ends_at <= $1
+		ORDER BY ends_at
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED`,
+		now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var wars []*GuildWar
+	for rows.Next() {
+		war, err := scanGuildWar(rows)
+		if err != nil {
+			return nil, err
+		}
+		wars = append(wars, war)
+	}
+
+	return wars, rows.Err()
+}
+
+// EndGuildWarTx stores the outcome of a settled war as part of a transaction
+func (r *Repository) EndGuildWarTx(ctx context.Context, tx *sqlx.Tx, war *GuildWar) error {
+	reward, err := json.Marshal(war.Reward)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE guild_wars SET winner_guild_id = $1, reward = $2, ended_at = $3 WHERE id = $4`,
+		war.WinnerGuildID, reward, war.EndedAt, war.ID)
+	return err
+}
+
+// AddGuildTreasuryTx pays resources into a guild's treasury as part of a
+// transaction
+func (r *Repository) AddGuildTreasuryTx(ctx context.Context, tx *sqlx.Tx, guildID uuid.UUID, amounts map[models.ResourceType]int64) error {
+	for resourceType, amount := range amounts {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO guild_treasury (guild_id, resource_type, amount)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (guild_id, resource_type) DO UPDATE
+			SET amount = guild_treasury.amount + EXCLUDED.amount,
+			    updated_at = CURRENT_TIMESTAMP`,
+			guildID, resourceType, amount)
+		if err != nil {
+			return fmt.Errorf("failed to add %s to guild treasury: %w", resourceType, err)
+		}
+	}
+	return nil
+}
+
+func scanGuildWar(row interface{ Scan(...interface{}) error }) (*GuildWar, error) {
+	var war GuildWar
+	var reward []byte
+	err := row.Scan(&war.ID, &war.AttackerGuildID, &war.DefenderGuildID, &war.DeclaredBy,
+		&war.AttackerScore, &war.DefenderScore, &war.WinnerGuildID, &reward,
+		&war.DeclaredAt, &war.StartsAt, &war.EndsAt, &war.EndedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(reward, &war.Reward); err != nil {
+		return nil, fmt.Errorf("failed to decode guild war reward: %w", err)
+	}
+	return &war, nil
+}
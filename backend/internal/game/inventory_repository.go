@@ -0,0 +1,68 @@
+package game
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// Inventory operations
+
+func (r *Repository) GetInventory(ctx context.Context, userID uuid.UUID) (map[string]int, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT item_type, quantity FROM inventory_items
+		WHERE user_id = $1 AND quantity > 0`,
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make(map[string]int)
+	for rows.Next() {
+		var itemType string
+		var quantity int
+		if err := rows.Scan(&itemType, &quantity); err != nil {
+			return nil, err
+		}
+		items[itemType] = quantity
+	}
+
+	return items, rows.Err()
+}
+
+// AddItem grants items to a user, e.g. after a store purchase
+func (r *Repository) AddItem(ctx context.Context, userID uuid.UUID, itemType string, quantity int) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO inventory_items (user_id, item_type, quantity, updated_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id, item_type)
+		DO UPDATE SET quantity = inventory_items.quantity + $3, updated_at = CURRENT_TIMESTAMP`,
+		userID, itemType, quantity)
+	return err
+}
+
+// ConsumeItemTx takes items from a user's inventory as part of a transaction.
+// The decrement only applies if enough items are left, so concurrent
+// requests can't spend the same item twice.
+func (r *Repository) ConsumeItemTx(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID, itemType string, quantity int) error {
+	result, err := tx.ExecContext(ctx,
+		`UPDATE inventory_items
+		SET quantity = quantity - $3, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND item_type = $2 AND quantity >= $3`,
+		userID, itemType, quantity)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("not enough items: %s", itemType)
+	}
+	return nil
+}
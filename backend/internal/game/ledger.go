@@ -0,0 +1,249 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// LedgerReason says why district resources changed
+type LedgerReason string
+
+const (
+	LedgerReasonStarter          LedgerReason = "starter"
+	LedgerReasonProduction       LedgerReason = "production"
+	LedgerReasonUpkeep           LedgerReason = "upkeep"
+	LedgerReasonConstruction     LedgerReason = "construction"
+	LedgerReasonUpgrade          LedgerReason = "upgrade"
+	LedgerReasonUpgradeCancelled LedgerReason = "upgrade_cancelled"
+	LedgerReasonDemolish         LedgerReason = "demolish"
+	LedgerReasonSpeedUp          LedgerReason = "speedup"
+	LedgerReasonRepair           LedgerReason = "repair"
+	LedgerReasonExpansion        LedgerReason = "expansion"
+	LedgerReasonCityFounded      LedgerReason = "city_founded"
+	LedgerReasonCityUpgrade      LedgerReason = "city_upgrade"
+	LedgerReasonAdminGrant       LedgerReason = "admin_grant"
+	LedgerReasonBattleLoot       LedgerReason = "battle_loot"
+	LedgerReasonTraining         LedgerReason = "training"
+	LedgerReasonWaveReward       LedgerReason = "wave_reward"
+	LedgerReasonTrade            LedgerReason = "trade"
+	LedgerReasonExchange         LedgerReason = "exchange"
+	LedgerReasonCaravan          LedgerReason = "caravan"
+)
+
+// Kinds of entities a ledger entry can point at
+const (
+	LedgerSourceBuilding      = "building"
+	LedgerSourceQueueEntry    = "queue_entry"
+	LedgerSourceDistrict      = "district"
+	LedgerSourceUser          = "user"
+	LedgerSourceCity          = "city"
+	LedgerSourceBattle        = "battle"
+	LedgerSourceTrainingOrder = "training_order"
+	LedgerSourceWave          = "wave"
+	LedgerSourceMarketOrder   = "market_order"
+	LedgerSourceMarketTrade   = "market_trade"
+	LedgerSourceExchangeTrade = "exchange_trade"
+	LedgerSourceCaravan       = "caravan"
+)
+
+const (
+	defaultLedgerLimit = 50
+	maxLedgerLimit     = 100
+)
+
+// GetLedger returns a page of the resource ledger of a user's district,
+// newest first
+func (s *Service) GetLedger(ctx context.Context, userID uuid.UUID, before int64, limit int) (*LedgerPage, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	limit = ledgerLimit(limit)
+	entries, err := s.repo.GetDistrictLedger(ctx, district.ID, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ledger: %w", err)
+	}
+
+	return newLedgerPage(entries, limit), nil
+}
+
+// QueryLedger returns a page of the ledger entries a user got in a time range,
+// newest first. It's meant for admins auditing where resources came from.
+func (s *Service) QueryLedger(ctx context.Context, query LedgerQuery) (*LedgerPage, error) {
+	if query.To.IsZero() {
+		query.To = time.Now()
+	}
+	if !query.From.Before(query.To) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+
+	limit := ledgerLimit(query.Limit)
+	entries, err := s.repo.GetUserLedger(ctx, query.UserID, query.From, query.To, query.Before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ledger: %w", err)
+	}
+
+	return newLedgerPage(entries, limit), nil
+}
+
+// GrantResources credits resources to a user's district on behalf of an
+// admin. Negative amounts take resources away.
+func (s *Service) GrantResources(ctx context.Context, adminID, userID uuid.UUID, req GrantResourcesRequest) (map[models.ResourceType]int64, error) {
+	for resourceType := range req.Resources {
+		if !isResourceType(resourceType) {
+			return nil, fmt.Errorf("unknown resource type %q", resourceType)
+		}
+	}
+
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	var resources map[models.ResourceType]int64
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+
+		resources, err = s.changeResourcesTx(ctx, tx, district.ID, req.Resources,
+			LedgerReasonAdminGrant, LedgerSource{Type: LedgerSourceUser, ID: adminID})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resources, nil
+}
+
+// changeResourcesTx adds delta to the resources of a district locked by tx,
+// records every change in the ledger and returns the new resources. It fails
+// if any resource would go negative.
+func (s *Service) changeResourcesTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID, delta map[models.ResourceType]int64, reason LedgerReason, source LedgerSource) (map[models.ResourceType]int64, error) {
+	resources, err := s.repo.GetDistrictResourcesTx(ctx, tx, districtID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var entries []*LedgerEntry
+	for _, resourceType := range resourceTypes {
+		amount := delta[resourceType]
+		if amount == 0 {
+			continue
+		}
+
+		balance := resources[resourceType] + amount
+		if balance < 0 {
+			return nil, fmt.Errorf("insufficient resources")
+		}
+		resources[resourceType] = balance
+
+		entries = append(entries, &LedgerEntry{
+			ResourceType: resourceType,
+			Delta:        amount,
+			Balance:      balance,
+			Reason:       reason,
+			SourceType:   source.Type,
+			SourceID:     source.id(),
+			CreatedAt:    now,
+		})
+	}
+	if len(entries) == 0 {
+		return resources, nil
+	}
+
+	if err := s.repo.UpdateDistrictResourcesTx(ctx, tx, districtID, resources); err != nil {
+		return nil, fmt.Errorf("failed to update resources: %w", err)
+	}
+	if err := s.repo.AddLedgerEntriesTx(ctx, tx, districtID, entries); err != nil {
+		return nil, fmt.Errorf("failed to record resource change: %w", err)
+	}
+
+	return resources, nil
+}
+
+// spendResourcesTx deducts cost from a district locked by tx and returns the
+// resources left
+func (s *Service) spendResourcesTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID, cost map[models.ResourceType]int64, reason LedgerReason, source LedgerSource) (map[models.ResourceType]int64, error) {
+	delta := make(map[models.ResourceType]int64, len(cost))
+	for resourceType, amount := range cost {
+		delta[resourceType] = -amount
+	}
+	return s.changeResourcesTx(ctx, tx, districtID, delta, reason, source)
+}
+
+func ledgerLimit(limit int) int {
+	if limit <= 0 || limit > maxLedgerLimit {
+		return defaultLedgerLimit
+	}
+	return limit
+}
+
+func newLedgerPage(entries []*LedgerEntry, limit int) *LedgerPage {
+	page := &LedgerPage{Entries: entries}
+	if page.Entries == nil {
+		page.Entries = []*LedgerEntry{}
+	}
+	if len(entries) == limit {
+		page.NextBefore = entries[len(entries)-1].ID
+	}
+	return page
+}
+
+// LedgerSource is the entity a resource change came from
+type LedgerSource struct {
+	Type string
+	ID   uuid.UUID
+}
+
+func buildingSource(buildingID uuid.UUID) LedgerSource {
+	return LedgerSource{Type: LedgerSourceBuilding, ID: buildingID}
+}
+
+func (s LedgerSource) id() *uuid.UUID {
+	if s.ID == uuid.Nil {
+		return nil
+	}
+	return &s.ID
+}
+
+// LedgerEntry is one credit or debit of a single resource
+type LedgerEntry struct {
+	ID           int64               `json:"id"`
+	DistrictID   uuid.UUID           `json:"district_id"`
+	UserID       uuid.UUID           `json:"user_id"`
+	ResourceType models.ResourceType `json:"resource_type"`
+	Delta        int64               `json:"delta"`
+	Balance      int64               `json:"balance"`
+	Reason       LedgerReason        `json:"reason"`
+	SourceType   string              `json:"source_type,omitempty"`
+	SourceID     *uuid.UUID          `json:"source_id,omitempty"`
+	CreatedAt    time.Time           `json:"created_at"`
+}
+
+// LedgerPage is a page of ledger entries. NextBefore is passed as before to
+// get the next page and is 0 on the last one.
+type LedgerPage struct {
+	Entries    []*LedgerEntry `json:"entries"`
+	NextBefore int64          `json:"next_before,omitempty"`
+}
+
+type LedgerQuery struct {
+	UserID uuid.UUID
+	From   time.Time
+	To     time.Time
+	Before int64
+	Limit  int
+}
+
+type GrantResourcesRequest struct {
+	Resources map[models.ResourceType]int64 `json:"resources" binding:"required"`
+}
@@ -0,0 +1,83 @@
+package game
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// Resource ledger operations
+
+// AddLedgerEntriesTx appends entries to the resource ledger of a district as
+// part of a transaction. The owner is taken from the district row.
+func (r *Repository) AddLedgerEntriesTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID, entries []*LedgerEntry) error {
+	return addLedgerEntries(ctx, tx, districtID, entries)
+}
+
+func addLedgerEntries(ctx context.Context, exec sqlx.ExecerContext, districtID uuid.UUID, entries []*LedgerEntry) error {
+	for _, entry := range entries {
+		_, err := exec.ExecContext(ctx, `
+			INSERT INTO resource_ledger (district_id, user_id, resource_type, delta, balance,
+			                             reason, source_type, source_id, created_at)
+			VALUES ($1, (SELECT owner_id FROM districts WHERE id = $1), $2, $3, $4, $5, $6, $7, $8)`,
+			districtID, entry.ResourceType, entry.Delta, entry.Balance,
+			entry.Reason, entry.SourceType, entry.SourceID, entry.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetDistrictLedger returns up to limit ledger entries of a district, newest
+// first, older than the entry with ID before (0 starts from the newest)
+func (r *Repository) GetDistrictLedger(ctx context.Context, districtID uuid.UUID, before int64, limit int) ([]*LedgerEntry, error) {
+	query := `
+		SELECT id, district_id, user_id, resource_type, delta, balance,
+		       reason, source_type, source_id, created_at
+		FROM resource_ledger
+		WHERE district_id = $1 AND ($2 = 0 OR id < $2)
+		ORDER BY id DESC
+		LIMIT $3`
+
+	return r.queryLedger(ctx, query, districtID, before, limit)
+}
+
+// GetUserLedger returns up to limit ledger entries of a user recorded in
+// [from, to), newest first, older than the entry with ID before
+func (r *Repository) GetUserLedger(ctx context.Context, userID uuid.UUID, from, to time.Time, before int64, limit int) ([]*LedgerEntry, error) {
+	query := `
+		SELECT id, district_id, user_id, resource_type, delta, balance,
+		       reason, source_type, source_id, created_at
+		FROM resource_ledger
+		WHERE user_id = $1 AND created_at >= $2 AND created_at < $3
+		  AND ($4 = 0 OR id < $4)
+		ORDER BY id DESC
+		LIMIT $5`
+
+	return r.queryLedger(ctx, query, userID, from, to, before, limit)
+}
+
+func (r *Repository) queryLedger(ctx context.Context, query string, args ...interface{}) ([]*LedgerEntry, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*LedgerEntry
+	for rows.Next() {
+		var entry LedgerEntry
+		err := rows.Scan(&entry.ID, &entry.DistrictID, &entry.UserID, &entry.ResourceType,
+			&entry.Delta, &entry.Balance, &entry.Reason, &entry.SourceType, &entry.SourceID, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+
+	return entries, rows.Err()
+}
@@ -0,0 +1,450 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/ton-empire/backend/pkg/logger"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// Market operations
+
+const (
+	marketOrdersLimit = 50
+	marketTradesLimit = 20
+)
+
+// MarketSide says whether an order buys or sells a resource for gold
+type MarketSide string
+
+const (
+	MarketBuy  MarketSide = "buy"
+	MarketSell MarketSide = "sell"
+)
+
+// Statuses of a market order
+const (
+	MarketOrderOpen      = "open"
+	MarketOrderFilled    = "filled"
+	MarketOrderCancelled = "cancelled"
+)
+
+// GetOrderBook returns the open orders for a resource by price and its
+// latest trades
+func (s *Service) GetOrderBook(ctx context.Context, userID uuid.UUID, resourceType models.ResourceType) (*OrderBook, error) {
+	if err := checkMarketResource(resourceType); err != nil {
+		return nil, err
+	}
+	if _, err := s.marketDistrict(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	book, err := s.repo.GetOrderBook(ctx, resourceType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order book: %w", err)
+	}
+	if book.Trades, err = s.repo.GetMarketTrades(ctx, resourceType, marketTradesLimit); err != nil {
+		return nil, fmt.Errorf("failed to get trades: %w", err)
+	}
+	return book, nil
+}
+
+// GetMarketOrders returns the latest orders of a user's district, open ones
+// first
+func (s *Service) GetMarketOrders(ctx context.Context, userID uuid.UUID) ([]*MarketOrder, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	orders, err := s.repo.GetDistrictMarketOrders(ctx, district.ID, marketOrdersLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get orders: %w", err)
+	}
+	if orders == nil {
+		orders = []*MarketOrder{}
+	}
+	return orders, nil
+}
+
+// PlaceMarketOrder puts what an order offers in escrow and fills it against
+// the best priced orders on the other side of the book, at their price.
+// Whatever doesn't fill stays in the book. A fill never delivers more than
+// the buyer has room to store, so a full buyer's orders are passed over and
+// the book can hold orders that cross, as it can past the MaxFills best
+// orders; they fill once the buyer has room and a new order comes in.
+func (s *Service) PlaceMarketOrder(ctx context.Context, userID uuid.UUID, req PlaceOrderRequest) (*MarketOrder, error) {
+	if err := checkMarketResource(req.ResourceType); err != nil {
+		return nil, err
+	}
+	catalog := s.catalog.Get()
+	if req.Price > catalog.Market.MaxPrice {
+		return nil, fmt.Errorf("price can be at most %d gold", catalog.Market.MaxPrice)
+	}
+	district, err := s.marketDistrict(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	order := &MarketOrder{
+		ID:           uuid.New(),
+		DistrictID:   district.ID,
+		OwnerID:      userID,
+		Side:         req.Side,
+		ResourceType: req.ResourceType,
+		Price:        req.Price,
+		Quantity:     req.Quantity,
+		Status:       MarketOrderOpen,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	escrow := map[models.ResourceType]int64{req.ResourceType: req.Quantity}
+	other := MarketBuy
+	if req.Side == MarketBuy {
+		escrow = map[models.ResourceType]int64{models.ResourceGold: req.Price * req.Quantity}
+		other = MarketSell
+	}
+
+	var fills []*marketFill
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockMarketTx(ctx, tx, req.ResourceType); err != nil {
+			return err
+		}
+		matches, err := s.repo.GetMatchingOrdersTx(ctx, tx, req.ResourceType, other, req.Price, district.ID, catalog.Market.MaxFills)
+		if err != nil {
+			return err
+		}
+
+		districtIDs := []uuid.UUID{district.ID}
+		for _, match := range matches {
+			districtIDs = append(districtIDs, match.DistrictID)
+		}
+		if err := s.lockDistrictsTx(ctx, tx, districtIDs...); err != nil {
+			return err
+		}
+
+		open, err := s.repo.CountOpenMarketOrdersTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		if open >= catalog.Market.MaxOpenOrders {
+			return fmt.Errorf("you already have %d open orders", open)
+		}
+
+		if _, err := s.spendResourcesTx(ctx, tx, district.ID, escrow, LedgerReasonTrade, marketOrderSource(order.ID)); err != nil {
+			return err
+		}
+		if err := s.repo.CreateMarketOrderTx(ctx, tx, order); err != nil {
+			return fmt.Errorf("failed to place order: %w", err)
+		}
+
+		rooms := make(map[uuid.UUID]int64)
+		for _, match := range matches {
+			if order.Filled >= order.Quantity {
+				break
+			}
+			fill := &marketFill{buy: order, sell: match}
+			if order.Side == MarketSell {
+				fill.buy, fill.sell = match, order
+			}
+
+			room, ok := rooms[fill.buy.DistrictID]
+			if !ok {
+				buyerRoom, err := s.storageRoomTx(ctx, tx, fill.buy.DistrictID)
+				if err != nil {
+					return err
+				}
+				room = buyerRoom[req.ResourceType]
+			}
+			quantity := min(order.Quantity-order.Filled, match.Quantity-match.Filled, room)
+			rooms[fill.buy.DistrictID] = room - max(quantity, 0)
+			if quantity <= 0 {
+				continue
+			}
+			fill.trade = &MarketTrade{
+				ID:           uuid.New(),
+				ResourceType: req.ResourceType,
+				Price:        match.Price,
+				Quantity:     quantity,
+				Fee:          int64(math.Ceil(float64(match.Price*quantity) * catalog.Market.Fee)),
+				BuyOrderID:   fill.buy.ID,
+				SellOrderID:  fill.sell.ID,
+				CreatedAt:    now,
+			}
+			if err := s.settleTradeTx(ctx, tx, fill); err != nil {
+				return err
+			}
+
+			match.fill(quantity, now)
+			order.fill(quantity, now)
+			if err := s.repo.UpdateMarketOrderTx(ctx, tx, match); err != nil {
+				return fmt.Errorf("failed to update order %s: %w", match.ID, err)
+			}
+			fills = append(fills, fill)
+		}
+
+		if err := s.repo.UpdateMarketOrderTx(ctx, tx, order); err != nil {
+			return fmt.Errorf("failed to update order %s: %w", order.ID, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fill := range fills {
+		s.notifyMarketTrade(ctx, fill)
+	}
+	return order, nil
+}
+
+// CancelMarketOrder takes the unfilled part of an open order out of the
+// book and returns its escrow
+func (s *Service) CancelMarketOrder(ctx context.Context, userID, orderID uuid.UUID) (*MarketOrder, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+	order, err := s.repo.GetMarketOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order.DistrictID != district.ID {
+		return nil, fmt.Errorf("order not found")
+	}
+
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockMarketTx(ctx, tx, order.ResourceType); err != nil {
+			return err
+		}
+		if order, err = s.repo.LockMarketOrderTx(ctx, tx, orderID); err != nil {
+			return err
+		}
+		if order.Status != MarketOrderOpen {
+			return fmt.Errorf("order is already %s", order.Status)
+		}
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+
+		left := order.Quantity - order.Filled
+		refund := map[models.ResourceType]int64{order.ResourceType: left}
+		if order.Side == MarketBuy {
+			refund = map[models.ResourceType]int64{models.ResourceGold: order.Price * left}
+		}
+		if _, err := s.changeResourcesTx(ctx, tx, district.ID, refund, LedgerReasonTrade, marketOrderSource(order.ID)); err != nil {
+			return err
+		}
+
+		order.Status = MarketOrderCancelled
+		order.UpdatedAt = time.Now()
+		if err := s.repo.UpdateMarketOrderTx(ctx, tx, order); err != nil {
+			return fmt.Errorf("failed to cancel order: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// settleTradeTx delivers a fill out of escrow: the buyer gets the resource
+// and the seller the gold minus the fee. A buyer who bid above the trade
+// price gets the difference back.
+func (s *Service) settleTradeTx(ctx context.Context, tx *sqlx.Tx, fill *marketFill) error {
+	trade := fill.trade
+	source := LedgerSource{Type: LedgerSourceMarketTrade, ID: trade.ID}
+
+	received := map[models.ResourceType]int64{trade.ResourceType: trade.Quantity}
+	if refund := (fill.buy.Price - trade.Price) * trade.Quantity; refund > 0 {
+		received[models.ResourceGold] = refund
+	}
+	if _, err := s.changeResourcesTx(ctx, tx, fill.buy.DistrictID, received, LedgerReasonTrade, source); err != nil {
+		return err
+	}
+
+	proceeds := map[models.ResourceType]int64{models.ResourceGold: trade.Price*trade.Quantity - trade.Fee}
+	if _, err := s.changeResourcesTx(ctx, tx, fill.sell.DistrictID, proceeds, LedgerReasonTrade, source); err != nil {
+		return err
+	}
+
+	if err := s.repo.CreateMarketTradeTx(ctx, tx, trade); err != nil {
+		return fmt.Errorf("failed to record trade: %w", err)
+	}
+	return nil
+}
+
+// storageRoomTx returns how much more of each resource a district locked by
+// tx can store
+func (s *Service) storageRoomTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID) (map[models.ResourceType]int64, error) {
+	buildings, err := s.repo.GetBuildingsByDistrictTx(ctx, tx, districtID)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := s.repo.GetDistrictResourcesTx(ctx, tx, districtID)
+	if err != nil {
+		return nil, err
+	}
+
+	room := s.catalog.Get().StorageCapacity(buildings)
+	for resourceType, capacity := range room {
+		room[resourceType] = max(capacity-resources[resourceType], 0)
+	}
+	return room, nil
+}
+
+// notifyMarketTrade tells both sides of a fill what they gave and received
+func (s *Service) notifyMarketTrade(ctx context.Context, fill *marketFill) {
+	trade := fill.trade
+	gold := trade.Price * trade.Quantity
+
+	buyer := &MarketTradeCompleted{
+		TradeID:  trade.ID,
+		OrderID:  fill.buy.ID,
+		Partner:  s.battlePlayer(ctx, fill.sell.OwnerID),
+		Given:    map[models.ResourceType]int64{models.ResourceGold: gold},
+		Received: map[models.ResourceType]int64{trade.ResourceType: trade.Quantity},
+	}
+	seller := &MarketTradeCompleted{
+		TradeID:  trade.ID,
+		OrderID:  fill.sell.ID,
+		Partner:  s.battlePlayer(ctx, fill.buy.OwnerID),
+		Given:    map[models.ResourceType]int64{trade.ResourceType: trade.Quantity},
+		Received: map[models.ResourceType]int64{models.ResourceGold: gold - trade.Fee},
+		Fee:      trade.Fee,
+	}
+
+	if err := s.events.PublishToUser(ctx, fill.buy.OwnerID, EventMarketTradeCompleted, buyer); err != nil {
+		logger.Errorf("Failed to publish trade %s: %v", trade.ID, err)
+	}
+	if err := s.events.PublishToUser(ctx, fill.sell.OwnerID, EventMarketTradeCompleted, seller); err != nil {
+		logger.Errorf("Failed to publish trade %s: %v", trade.ID, err)
+	}
+}
+
+// marketDistrict returns the district of a user if it has a working Market
+func (s *Service) marketDistrict(ctx context.Context, userID uuid.UUID) (*models.District, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+	buildings, err := s.repo.GetBuildingsByDistrict(ctx, district.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get buildings: %w", err)
+	}
+	if marketLevel(buildings) == 0 {
+		return nil, fmt.Errorf("trading needs a working Market")
+	}
+	return district, nil
+}
+
+// marketLevel returns the level of a district's highest working Market
+func marketLevel(buildings []*models.Building) int {
+	level := 0
+	for _, building := range buildings {
+		if building.Type == models.BuildingMarket && building.IsActive {
+			level = max(level, building.Level)
+		}
+	}
+	return level
+}
+
+// checkMarketResource rejects resources without an order book. Everything
+// is priced in gold, so gold itself isn't traded.
+func checkMarketResource(resourceType models.ResourceType) error {
+	if resourceType == models.ResourceGold || !isResourceType(resourceType) {
+		return fmt.Errorf("%s can't be traded on the market", resourceType)
+	}
+	return nil
+}
+
+func marketOrderSource(orderID uuid.UUID) LedgerSource {
+	return LedgerSource{Type: LedgerSourceMarketOrder, ID: orderID}
+}
+
+// marketFill is a trade between a buy and a sell order
+type marketFill struct {
+	trade     *MarketTrade
+	buy, sell *MarketOrder
+}
+
+// MarketOrder is a limit order to buy or sell a resource for gold. What it
+// offers is held in escrow until it fills or is cancelled.
+type MarketOrder struct {
+	ID           uuid.UUID           `json:"id"`
+	DistrictID   uuid.UUID           `json:"district_id"`
+	OwnerID      uuid.UUID           `json:"-"`
+	Side         MarketSide          `json:"side"`
+	ResourceType models.ResourceType `json:"resource_type"`
+	// Gold per unit
+	Price     int64     `json:"price"`
+	Quantity  int64     `json:"quantity"`
+	Filled    int64     `json:"filled"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (o *MarketOrder) fill(quantity int64, now time.Time) {
+	o.Filled += quantity
+	if o.Filled >= o.Quantity {
+		o.Status = MarketOrderFilled
+	}
+	o.UpdatedAt = now
+}
+
+// MarketTrade is a fill between a buy and a sell order, at the price of the
+// order that was in the book first
+type MarketTrade struct {
+	ID           uuid.UUID           `json:"id"`
+	ResourceType models.ResourceType `json:"resource_type"`
+	Price        int64               `json:"price"`
+	Quantity     int64               `json:"quantity"`
+	// Gold the seller paid in fees
+	Fee         int64     `json:"fee"`
+	BuyOrderID  uuid.UUID `json:"buy_order_id"`
+	SellOrderID uuid.UUID `json:"sell_order_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// PriceLevel is the open quantity at one price of an order book
+type PriceLevel struct {
+	Price    int64 `json:"price"`
+	Quantity int64 `json:"quantity"`
+	Orders   int   `json:"orders"`
+}
+
+// OrderBook is the open orders for a resource by price, best first, and
+// its latest trades
+type OrderBook struct {
+	ResourceType models.ResourceType `json:"resource_type"`
+	Bids         []*PriceLevel       `json:"bids"`
+	Asks         []*PriceLevel       `json:"asks"`
+	Trades       []*MarketTrade      `json:"trades"`
+}
+
+// MarketTradeCompleted tells one side of a trade what they gave and
+// received
+type MarketTradeCompleted struct {
+	TradeID  uuid.UUID                     `json:"trade_id"`
+	OrderID  uuid.UUID                     `json:"order_id"`
+	Partner  BattlePlayer                  `json:"partner"`
+	Given    map[models.ResourceType]int64 `json:"given"`
+	Received map[models.ResourceType]int64 `json:"received"`
+	Fee      int64                         `json:"fee,omitempty"`
+}
+
+type PlaceOrderRequest struct {
+	Side         MarketSide          `json:"side" binding:"required,oneof=buy sell"`
+	ResourceType models.ResourceType `json:"resource_type" binding:"required"`
+	Price        int64               `json:"price" binding:"required,min=1"`
+	Quantity     int64               `json:"quantity" binding:"required,min=1,max=1000000000"`
+}
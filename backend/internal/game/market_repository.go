@@ -0,0 +1,219 @@
+package game
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// Market operations
+
+// marketLockKey namespaces the advisory locks serializing each order book
+const marketLockKey int32 = 0x6d6b74
+
+const marketOrderColumns = `
+	o.id, o.district_id, d.owner_id, o.side, o.resource_type, o.price,
+	o.quantity, o.filled, o.status, o.created_at, o.updated_at`
+
+// LockMarketTx serializes changes to the order book of a resource until tx
+// ends. The book is locked before any district.
+func (r *Repository) LockMarketTx(ctx context.Context, tx *sqlx.Tx, resourceType models.ResourceType) error {
+	_, err := tx.ExecContext(ctx,
+		`SELECT pg_advisory_xact_lock($1, hashtext($2))`, marketLockKey, string(resourceType))
+	return err
+}
+
+// GetMatchingOrdersTx returns the open orders on one side of a book an
+// order at price fills against, best price first and oldest first at each
+// price, as part of a transaction. Orders of excludeDistrictID are left out.
+func (r *Repository) GetMatchingOrdersTx(ctx context.Context, tx *sqlx.Tx, resourceType models.ResourceType, side MarketSide, price int64, excludeDistrictID uuid.UUID, limit int) ([]*MarketOrder, error) {
+	// Sells fill buys at or above their price, buys fill sells at or below
+	condition, order := "o.price <= $3", "o.price ASC"
+	if side == MarketBuy {
+		condition, order = "o.price >= $3", "o.price DESC"
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT `+marketOrderColumns+`
+		FROM market_orders o
+		JOIN districts d ON d.id = o.district_id
+		WHERE o.resource_type = $1 AND o.side = $2 AND o.status = 'open'
+		  AND `+condition+` AND o.district_id <> $4
+		ORDER BY `+order+`, o.created_at, o.id
+		LIMIT $5`,
+		resourceType, side, price, excludeDistrictID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMarketOrders(rows)
+}
+
+// CreateMarketOrderTx stores a new order as part of a transaction
+func (r *Repository) CreateMarketOrderTx(ctx context.Context, tx *sqlx.Tx, order *MarketOrder) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO market_orders (id, district_id, side, resource_type, price, quantity, filled, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		order.ID, order.DistrictID, order.Side, order.ResourceType, order.Price,
+		order.Quantity, order.Filled, order.Status, order.CreatedAt, order.UpdatedAt)
+	return err
+}
+
+// UpdateMarketOrderTx stores how much of an order filled and its status as
+// part of a transaction
+func (r *Repository) UpdateMarketOrderTx(ctx context.Context, tx *sqlx.Tx, order *MarketOrder) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE market_orders SET filled = $1, status = $2, updated_at = $3 WHERE id = $4`,
+		order.Filled, order.Status, order.UpdatedAt, order.ID)
+	return err
+}
+
+// CreateMarketTradeTx stores a fill as part of a transaction
+func (r *Repository) CreateMarketTradeTx(ctx context.Context, tx *sqlx.Tx, trade *MarketTrade) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO market_trades (id, resource_type, price, quantity, fee, buy_order_id, sell_order_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		trade.ID, trade.ResourceType, trade.Price, trade.Quantity, trade.Fee,
+		trade.BuyOrderID, trade.SellOrderID, trade.CreatedAt)
+	return err
+}
+
+// GetMarketOrder returns an order
+func (r *Repository) GetMarketOrder(ctx context.Context, orderID uuid.UUID) (*MarketOrder, error) {
+	return getMarketOrder(ctx, r.db, orderID, "")
+}
+
+// LockMarketOrderTx returns an order locked until tx ends
+func (r *Repository) LockMarketOrderTx(ctx context.Context, tx *sqlx.Tx, orderID uuid.UUID) (*MarketOrder, error) {
+	return getMarketOrder(ctx, tx, orderID, "FOR UPDATE OF o")
+}
+
+func getMarketOrder(ctx context.Context, q sqlx.QueryerContext, orderID uuid.UUID, lock string) (*MarketOrder, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT `+marketOrderColumns+`
+		FROM market_orders o
+		JOIN districts d ON d.id = o.district_id
+		WHERE o.id = $1 `+lock,
+		orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders, err := scanMarketOrders(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return nil, fmt.Errorf("order not found")
+	}
+	return orders[0], nil
+}
+
+// GetDistrictMarketOrders returns the latest orders of a district, open
+// ones first, then newest first
+func (r *Repository) GetDistrictMarketOrders(ctx context.Context, districtID uuid.UUID, limit int) ([]*MarketOrder, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+marketOrderColumns+`
+		FROM market_orders o
+		JOIN districts d ON d.id = o.district_id
+		WHERE o.district_id = $1
+		ORDER BY o.status = 'open' DESC, o.created_at DESC
+		LIMIT $2`,
+		districtID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMarketOrders(rows)
+}
+
+// CountOpenMarketOrdersTx counts the open orders of a district as part of a
+// transaction
+func (r *Repository) CountOpenMarketOrdersTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID) (int, error) {
+	var count int
+	err := tx.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM market_orders WHERE district_id = $1 AND status = 'open'`,
+		districtID).Scan(&count)
+	return count, err
+}
+
+// GetOrderBook returns the open quantity at every price on both sides of
+// the book of a resource, best prices first
+func (r *Repository) GetOrderBook(ctx context.Context, resourceType models.ResourceType) (*OrderBook, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT side, price, SUM(quantity - filled), COUNT(*)
+		FROM market_orders
+		WHERE resource_type = $1 AND status = 'open'
+		GROUP BY side, price
+		ORDER BY CASE WHEN side = 'buy' THEN -price ELSE price END`,
+		resourceType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	book := &OrderBook{ResourceType: resourceType, Bids: []*PriceLevel{}, Asks: []*PriceLevel{}}
+	for rows.Next() {
+		var side MarketSide
+		var level PriceLevel
+		if err := rows.Scan(&side, &level.Price, &level.Quantity, &level.Orders); err != nil {
+			return nil, err
+		}
+		if side == MarketBuy {
+			book.Bids = append(book.Bids, &level)
+		} else {
+			book.Asks = append(book.Asks, &level)
+		}
+	}
+
+	return book, rows.Err()
+}
+
+// GetMarketTrades returns the latest trades of a resource, newest first
+func (r *Repository) GetMarketTrades(ctx context.Context, resourceType models.ResourceType, limit int) ([]*MarketTrade, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, resource_type, price, quantity, fee, buy_order_id, sell_order_id, created_at
+		FROM market_trades
+		WHERE resource_type = $1
+		ORDER BY created_at DESC
+		LIMIT $2`,
+		resourceType, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trades := []*MarketTrade{}
+	for rows.Next() {
+		var trade MarketTrade
+		err := rows.Scan(&trade.ID, &trade.ResourceType, &trade.Price, &trade.Quantity, &trade.Fee,
+			&trade.BuyOrderID, &trade.SellOrderID, &trade.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		trades = append(trades, &trade)
+	}
+
+	return trades, rows.Err()
+}
+
+func scanMarketOrders(rows *sql.Rows) ([]*MarketOrder, error) {
+	var orders []*MarketOrder
+	for rows.Next() {
+		var order MarketOrder
+		err := rows.Scan(&order.ID, &order.DistrictID, &order.OwnerID, &order.Side, &order.ResourceType,
+			&order.Price, &order.Quantity, &order.Filled, &order.Status, &order.CreatedAt, &order.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, &order)
+	}
+	return orders, rows.Err()
+}
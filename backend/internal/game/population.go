@@ -0,0 +1,161 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// Population operations
+
+// GetPopulation returns a district's population, housing and the workers of
+// every building that employs any
+func (s *Service) GetPopulation(ctx context.Context, userID uuid.UUID) (*PopulationOverview, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	return s.getPopulation(ctx, district)
+}
+
+// AssignWorkers sets how many citizens work in a building; zero unassigns
+// everyone. Resources are collected first, so the new staffing only affects
+// production from now on.
+func (s *Service) AssignWorkers(ctx context.Context, userID uuid.UUID, buildingID uuid.UUID, req AssignWorkersRequest) (*PopulationOverview, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	catalog := s.catalog.Get()
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+
+		collected, err := s.collectResourcesTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		district.Population = collected.Population
+
+		buildings, err := s.repo.GetBuildingsByDistrictTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		building := findBuilding(buildings, buildingID)
+		if building == nil {
+			return fmt.Errorf("building not found")
+		}
+
+		if required := catalog.Workers(building.Type, building.Level); req.Workers > required {
+			return fmt.Errorf("building employs at most %d workers", required)
+		}
+
+		workers, err := s.repo.GetWorkersTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		employed := 0
+		for id, count := range workers {
+			if id != building.ID {
+				employed += count
+			}
+		}
+		if employed+req.Workers > district.Population {
+			return fmt.Errorf("not enough free citizens")
+		}
+
+		return s.repo.SetBuildingWorkersTx(ctx, tx, building.ID, req.Workers)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getPopulation(ctx, district)
+}
+
+func (s *Service) getPopulation(ctx context.Context, district *models.District) (*PopulationOverview, error) {
+	buildings, err := s.repo.GetBuildingsByDistrict(ctx, district.ID)
+	if err != nil {
+		return nil, err
+	}
+	workers, err := s.repo.GetWorkers(ctx, district.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workers: %w", err)
+	}
+
+	catalog := s.catalog.Get()
+	overview := &PopulationOverview{
+		Population: district.Population,
+		Housing:    catalog.HousingCapacity(buildings),
+		Buildings:  []*BuildingStaffing{},
+	}
+	for _, building := range buildings {
+		required := catalog.Workers(building.Type, building.Level)
+		if required == 0 && workers[building.ID] == 0 {
+			continue
+		}
+
+		overview.Employed += workers[building.ID]
+		overview.Buildings = append(overview.Buildings, &BuildingStaffing{
+			BuildingID: building.ID,
+			Type:       building.Type,
+			Workers:    workers[building.ID],
+			Required:   required,
+		})
+	}
+
+	return overview, nil
+}
+
+// growPopulation moves population toward housing capacity, closing
+// growthPerHour of the gap every hour. A district with too little housing
+// loses the excess at once.
+func growPopulation(population, capacity int, hours, growthPerHour float64) int {
+	if population >= capacity {
+		return capacity
+	}
+	if hours <= 0 {
+		return population
+	}
+
+	gap := float64(capacity - population)
+	grown := int(gap * (1 - math.Exp(-growthPerHour*hours)))
+	return population + grown
+}
+
+// staffingFactor returns the share of its production a building reaches with
+// assigned of required workers. employment scales assignments down when the
+// district has fewer citizens than jobs it assigned.
+func staffingFactor(required, assigned int, employment, unstaffedRate float64) float64 {
+	if required <= 0 {
+		return 1
+	}
+
+	staffed := math.Min(float64(assigned)*employment/float64(required), 1)
+	return unstaffedRate + (1-unstaffedRate)*staffed
+}
+
+type PopulationOverview struct {
+	Population int                 `json:"population"`
+	Housing    int                 `json:"housing"`
+	Employed   int                 `json:"employed"`
+	Buildings  []*BuildingStaffing `json:"buildings"`
+}
+
+type BuildingStaffing struct {
+	BuildingID uuid.UUID           `json:"building_id"`
+	Type       models.BuildingType `json:"type"`
+	Workers    int                 `json:"workers"`
+	Required   int                 `json:"required"`
+}
+
+type AssignWorkersRequest struct {
+	Workers int `json:"workers" binding:"min=0"`
+}
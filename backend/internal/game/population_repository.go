@@ -0,0 +1,50 @@
+package game
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// Worker operations
+
+// GetWorkers returns the workers assigned to each building of a district
+func (r *Repository) GetWorkers(ctx context.Context, districtID uuid.UUID) (map[uuid.UUID]int, error) {
+	return getWorkers(ctx, r.db, districtID)
+}
+
+// GetWorkersTx reads assigned workers as part of a transaction
+func (r *Repository) GetWorkersTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID) (map[uuid.UUID]int, error) {
+	return getWorkers(ctx, tx, districtID)
+}
+
+func getWorkers(ctx context.Context, q sqlx.QueryerContext, districtID uuid.UUID) (map[uuid.UUID]int, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT id, workers FROM buildings WHERE district_id = $1 AND workers > 0`,
+		districtID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workers := make(map[uuid.UUID]int)
+	for rows.Next() {
+		var buildingID uuid.UUID
+		var count int
+		if err := rows.Scan(&buildingID, &count); err != nil {
+			return nil, err
+		}
+		workers[buildingID] = count
+	}
+
+	return workers, rows.Err()
+}
+
+// SetBuildingWorkersTx changes the workers assigned to a building as part of a transaction
+func (r *Repository) SetBuildingWorkersTx(ctx context.Context, tx *sqlx.Tx, buildingID uuid.UUID, workers int) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE buildings SET workers = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`,
+		workers, buildingID)
+	return err
+}
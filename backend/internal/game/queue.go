@@ -0,0 +1,358 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/ton-empire/backend/pkg/logger"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// Construction queue operations
+
+// GetConstructionQueue returns the running upgrades and the queue of a user's district
+func (s *Service) GetConstructionQueue(ctx context.Context, userID uuid.UUID) (*ConstructionQueue, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	return s.getConstructionQueue(ctx, district.ID)
+}
+
+// EnqueueUpgrade pays for a building's next level and adds it to the
+// construction queue. It starts right away if a builder is free.
+func (s *Service) EnqueueUpgrade(ctx context.Context, userID uuid.UUID, req EnqueueUpgradeRequest) (*ConstructionQueue, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	catalog := s.catalog.Get()
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+
+		buildings, err := s.repo.GetBuildingsByDistrictTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		building := findBuilding(buildings, req.BuildingID)
+		if building == nil {
+			return fmt.Errorf("building not found")
+		}
+		if isUpgrading(building) {
+			return fmt.Errorf("building is already upgrading")
+		}
+		if !building.IsActive {
+			return fmt.Errorf("building is damaged, repair it first")
+		}
+
+		spec, err := catalog.Building(building.Type)
+		if err != nil {
+			return err
+		}
+		if building.Level >= spec.MaxLevel {
+			return fmt.Errorf("building is already at max level")
+		}
+		if err := catalog.checkLevelCap(building, building.Level+1, buildings); err != nil {
+			return err
+		}
+
+		queue, err := s.repo.GetQueueTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		if len(queue) >= catalog.Construction.MaxQueueLength {
+			return fmt.Errorf("construction queue is full")
+		}
+		for _, entry := range queue {
+			if entry.BuildingID == building.ID {
+				return fmt.Errorf("building is already queued")
+			}
+		}
+
+		cost, err := catalog.Cost(building.Type, building.Level+1)
+		if err != nil {
+			return err
+		}
+
+		entryID := uuid.New()
+		if _, err := s.spendResourcesTx(ctx, tx, district.ID, cost, LedgerReasonUpgrade,
+			LedgerSource{Type: LedgerSourceQueueEntry, ID: entryID}); err != nil {
+			return err
+		}
+
+		entry := &QueueEntry{
+			ID:           entryID,
+			DistrictID:   district.ID,
+			BuildingID:   building.ID,
+			BuildingType: building.Type,
+			TargetLevel:  building.Level + 1,
+			Cost:         cost,
+			CreatedAt:    time.Now(),
+		}
+		if err := s.repo.AddQueueEntryTx(ctx, tx, entry); err != nil {
+			return fmt.Errorf("failed to queue upgrade: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.startQueuedUpgrades(ctx, district.ID, userID)
+
+	return s.getConstructionQueue(ctx, district.ID)
+}
+
+// ReorderQueue changes the order in which queued upgrades start. The request
+// must list every queued entry exactly once.
+func (s *Service) ReorderQueue(ctx context.Context, userID uuid.UUID, req ReorderQueueRequest) (*ConstructionQueue, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+
+		queue, err := s.repo.GetQueueTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		if len(req.EntryIDs) != len(queue) {
+			return fmt.Errorf("order must list every queued upgrade")
+		}
+
+		queued := make(map[uuid.UUID]bool, len(queue))
+		for _, entry := range queue {
+			queued[entry.ID] = true
+		}
+		for _, entryID := range req.EntryIDs {
+			if !queued[entryID] {
+				return fmt.Errorf("order must list every queued upgrade")
+			}
+			delete(queued, entryID)
+		}
+
+		return s.repo.SetQueueOrderTx(ctx, tx, req.EntryIDs)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getConstructionQueue(ctx, district.ID)
+}
+
+// CancelQueuedUpgrade removes an upgrade from the queue and refunds its cost
+func (s *Service) CancelQueuedUpgrade(ctx context.Context, userID uuid.UUID, entryID uuid.UUID) (*CancelQueueResult, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	var result *CancelQueueResult
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+
+		queue, err := s.repo.GetQueueTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+
+		var entry *QueueEntry
+		for _, e := range queue {
+			if e.ID == entryID {
+				entry = e
+				break
+			}
+		}
+		if entry == nil {
+			return fmt.Errorf("queue entry not found")
+		}
+
+		if err := s.repo.DeleteQueueEntryTx(ctx, tx, entry.ID); err != nil {
+			return fmt.Errorf("failed to cancel upgrade: %w", err)
+		}
+
+		resources, err := s.changeResourcesTx(ctx, tx, district.ID, entry.Cost, LedgerReasonUpgradeCancelled,
+			LedgerSource{Type: LedgerSourceQueueEntry, ID: entry.ID})
+		if err != nil {
+			return err
+		}
+
+		result = &CancelQueueResult{
+			Refund:         entry.Cost,
+			TotalResources: resources,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// startQueuedUpgrades starts queued upgrades of a district while it has free
+// builder slots
+func (s *Service) startQueuedUpgrades(ctx context.Context, districtID, ownerID uuid.UUID) {
+	var started []*models.Building
+
+	catalog := s.catalog.Get()
+	err := s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockDistrictTx(ctx, tx, districtID); err != nil {
+			return err
+		}
+
+		queue, err := s.repo.GetQueueTx(ctx, tx, districtID)
+		if err != nil || len(queue) == 0 {
+			return err
+		}
+
+		buildings, err := s.repo.GetBuildingsByDistrictTx(ctx, tx, districtID)
+		if err != nil {
+			return err
+		}
+
+		freeSlots := catalog.BuilderSlots(townHallLevel(buildings)) - countUpgrading(buildings)
+		for _, entry := range queue {
+			if freeSlots <= 0 {
+				break
+			}
+
+			building := findBuilding(buildings, entry.BuildingID)
+			if building == nil || isUpgrading(building) {
+				continue
+			}
+
+			duration, err := catalog.Duration(building.Type, entry.TargetLevel)
+			if err != nil {
+				return err
+			}
+			upgradeEndAt := time.Now().Add(duration)
+			building.UpgradeEndAt = &upgradeEndAt
+
+			if err := s.repo.UpdateBuildingTx(ctx, tx, building); err != nil {
+				return fmt.Errorf("failed to start upgrade for building %s: %w", building.ID, err)
+			}
+			if err := s.repo.DeleteQueueEntryTx(ctx, tx, entry.ID); err != nil {
+				return err
+			}
+
+			started = append(started, building)
+			freeSlots--
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("Failed to start queued upgrades for district %s: %v", districtID, err)
+		return
+	}
+
+	for _, building := range started {
+		s.notifyBuildingUpdate(ctx, ownerID, building)
+	}
+}
+
+func (s *Service) getConstructionQueue(ctx context.Context, districtID uuid.UUID) (*ConstructionQueue, error) {
+	buildings, err := s.repo.GetBuildingsByDistrict(ctx, districtID)
+	if err != nil {
+		return nil, err
+	}
+
+	queued, err := s.repo.GetQueue(ctx, districtID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get construction queue: %w", err)
+	}
+
+	catalog := s.catalog.Get()
+	queue := &ConstructionQueue{
+		BuilderSlots:   catalog.BuilderSlots(townHallLevel(buildings)),
+		MaxQueueLength: catalog.Construction.MaxQueueLength,
+		Active:         []*models.Building{},
+		Queued:         queued,
+	}
+	for _, building := range buildings {
+		if isUpgrading(building) {
+			queue.Active = append(queue.Active, building)
+		}
+	}
+	if queue.Queued == nil {
+		queue.Queued = []*QueueEntry{}
+	}
+
+	return queue, nil
+}
+
+func findBuilding(buildings []*models.Building, buildingID uuid.UUID) *models.Building {
+	for _, building := range buildings {
+		if building.ID == buildingID {
+			return building
+		}
+	}
+	return nil
+}
+
+func townHallLevel(buildings []*models.Building) int {
+	for _, building := range buildings {
+		if building.Type == models.BuildingTownHall {
+			return building.Level
+		}
+	}
+	return 0
+}
+
+func countUpgrading(buildings []*models.Building) int {
+	count := 0
+	for _, building := range buildings {
+		if isUpgrading(building) {
+			count++
+		}
+	}
+	return count
+}
+
+// QueueEntry is an upgrade waiting for a free builder slot. Its cost is paid
+// when it's queued and refunded if it's cancelled.
+type QueueEntry struct {
+	ID           uuid.UUID                     `json:"id"`
+	DistrictID   uuid.UUID                     `json:"district_id"`
+	BuildingID   uuid.UUID                     `json:"building_id"`
+	BuildingType models.BuildingType           `json:"building_type"`
+	TargetLevel  int                           `json:"target_level"`
+	Position     int                           `json:"position"`
+	Cost         map[models.ResourceType]int64 `json:"cost"`
+	CreatedAt    time.Time                     `json:"created_at"`
+}
+
+type ConstructionQueue struct {
+	BuilderSlots   int                `json:"builder_slots"`
+	MaxQueueLength int                `json:"max_queue_length"`
+	Active         []*models.Building `json:"active"`
+	Queued         []*QueueEntry      `json:"queued"`
+}
+
+type EnqueueUpgradeRequest struct {
+	BuildingID uuid.UUID `json:"building_id" binding:"required"`
+}
+
+type ReorderQueueRequest struct {
+	EntryIDs []uuid.UUID `json:"entry_ids" binding:"required"`
+}
+
+type CancelQueueResult struct {
+	Refund         map[models.ResourceType]int64 `json:"refund"`
+	TotalResources map[models.ResourceType]int64 `json:"total_resources"`
+}
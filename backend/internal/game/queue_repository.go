@@ -0,0 +1,88 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// Construction queue operations
+
+func (r *Repository) GetQueue(ctx context.Context, districtID uuid.UUID) ([]*QueueEntry, error) {
+	return getQueue(ctx, r.db, districtID)
+}
+
+// GetQueueTx reads the construction queue as part of a transaction
+func (r *Repository) GetQueueTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID) ([]*QueueEntry, error) {
+	return getQueue(ctx, tx, districtID)
+}
+
+func getQueue(ctx context.Context, q sqlx.QueryerContext, districtID uuid.UUID) ([]*QueueEntry, error) {
+	query := `
+		SELECT q.id, q.district_id, q.building_id, b.type, q.target_level,
+		       q.position, q.cost, q.created_at
+		FROM construction_queue q
+		JOIN buildings b ON b.id = q.building_id
+		WHERE q.district_id = $1
+		ORDER BY q.position`
+
+	rows, err := q.QueryContext(ctx, query, districtID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*QueueEntry
+	for rows.Next() {
+		var entry QueueEntry
+		var cost []byte
+		err := rows.Scan(&entry.ID, &entry.DistrictID, &entry.BuildingID, &entry.BuildingType,
+			&entry.TargetLevel, &entry.Position, &cost, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(cost, &entry.Cost); err != nil {
+			return nil, fmt.Errorf("failed to decode queue entry cost: %w", err)
+		}
+		entries = append(entries, &entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func (r *Repository) AddQueueEntryTx(ctx context.Context, tx *sqlx.Tx, entry *QueueEntry) error {
+	cost, err := json.Marshal(entry.Cost)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO construction_queue (id, district_id, building_id, target_level, position, cost, created_at)
+		VALUES ($1, $2, $3, $4,
+		        (SELECT COALESCE(MAX(position), 0) + 1 FROM construction_queue WHERE district_id = $2),
+		        $5, $6)
+		RETURNING position`,
+		entry.ID, entry.DistrictID, entry.BuildingID, entry.TargetLevel, cost, entry.CreatedAt).Scan(&entry.Position)
+	return err
+}
+
+func (r *Repository) DeleteQueueEntryTx(ctx context.Context, tx *sqlx.Tx, entryID uuid.UUID) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM construction_queue WHERE id = $1`, entryID)
+	return err
+}
+
+// SetQueueOrderTx renumbers queue entries in the given order
+func (r *Repository) SetQueueOrderTx(ctx context.Context, tx *sqlx.Tx, entryIDs []uuid.UUID) error {
+	for i, entryID := range entryIDs {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE construction_queue SET position = $1 WHERE id = $2`,
+			i+1, entryID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,8 +3,9 @@ package game
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
@@ -12,6 +13,9 @@ import (
 	"github.com/ton-empire/backend/pkg/models"
 )
 
+// ErrDistrictNotFound is returned when a user has no district yet
+var ErrDistrictNotFound = errors.New("district not found")
+
 type Repository struct {
 	db *database.DB
 }
@@ -32,14 +36,14 @@ func (r *Repository) GetDistrictByUserID(ctx context.Context, userID uuid.UUID)
 	
 	err := r.db.GetContext(ctx, &district, query, userID)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("district not found")
+		return nil, ErrDistrictNotFound
 	}
 	if err != nil {
 		return nil, err
 	}
 
 	// Load resources
-	resources, err := r.getDistrictResources(ctx, district.ID)
+	resources, err := getDistrictResources(ctx, r.db, district.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -49,6 +53,15 @@ func (r *Repository) GetDistrictByUserID(ctx context.Context, userID uuid.UUID)
 }
 
 func (r *Repository) GetDistrictByID(ctx context.Context, districtID uuid.UUID) (*models.District, error) {
+	return getDistrictByID(ctx, r.db, districtID)
+}
+
+// GetDistrictByIDTx reads a district and its resources as part of a transaction
+func (r *Repository) GetDistrictByIDTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID) (*models.District, error) {
+	return getDistrictByID(ctx, tx, districtID)
+}
+
+func getDistrictByID(ctx context.Context, q sqlx.QueryerContext, districtID uuid.UUID) (*models.District, error) {
 	var district models.District
 	query := `
 		SELECT id, owner_id, city_id, name, population, efficiency, 
@@ -56,13 +69,13 @@ func (r *Repository) GetDistrictByID(ctx context.Context, districtID uuid.UUID)
 		FROM districts 
 		WHERE id = $1`
 	
-	err := r.db.GetContext(ctx, &district, query, districtID)
+	err := sqlx.GetContext(ctx, q, &district, query, districtID)
 	if err != nil {
 		return nil, err
 	}
 
 	// Load resources
-	resources, err := r.getDistrictResources(ctx, district.ID)
+	resources, err := getDistrictResources(ctx, q, district.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -71,26 +84,23 @@ func (r *Repository) GetDistrictByID(ctx context.Context, districtID uuid.UUID)
 	return &district, nil
 }
 
-func (r *Repository) CreateDistrict(ctx context.Context, district *models.District) error {
-	tx, err := r.db.Beginx()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
+// CreateDistrictTx inserts a district with its starting resources as part of
+// a transaction
+func (r *Repository) CreateDistrictTx(ctx context.Context, tx *sqlx.Tx, district *models.District) error {
 	// Insert district
 	query := `
 		INSERT INTO districts (id, owner_id, city_id, name, population, efficiency, created_at, updated_at)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
 	
-	_, err = tx.ExecContext(ctx, query,
+	_, err := tx.ExecContext(ctx, query,
 		district.ID, district.OwnerID, district.CityID, district.Name,
 		district.Population, district.Efficiency, district.CreatedAt, district.UpdatedAt)
 	if err != nil {
 		return err
 	}
 
-	// Initialize resources
+	// Initialize resources and record them in the ledger
+	var entries []*LedgerEntry
 	for resourceType, amount := range district.Resources {
 		_, err = tx.ExecContext(ctx,
 			`INSERT INTO district_resources (district_id, resource_type, amount) VALUES ($1, $2, $3)`,
@@ -98,28 +108,40 @@ func (r *Repository) CreateDistrict(ctx context.Context, district *models.Distri
 		if err != nil {
 			return err
 		}
+
+		entries = append(entries, &LedgerEntry{
+			ResourceType: resourceType,
+			Delta:        amount,
+			Balance:      amount,
+			Reason:       LedgerReasonStarter,
+			SourceType:   LedgerSourceDistrict,
+			SourceID:     &district.ID,
+			CreatedAt:    district.CreatedAt,
+		})
+	}
+	if err := addLedgerEntries(ctx, tx, district.ID, entries); err != nil {
+		return err
 	}
 
 	// Update user's district_id
 	_, err = tx.ExecContext(ctx,
 		`UPDATE users SET district_id = $1 WHERE id = $2`,
 		district.ID, district.OwnerID)
-	if err != nil {
-		return err
-	}
-
-	return tx.Commit()
+	return err
 }
 
-func (r *Repository) UpdateDistrictResources(ctx context.Context, districtID uuid.UUID, resources map[models.ResourceType]int64) error {
-	tx, err := r.db.Beginx()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
+// SetDistrictCityTx moves a district to another city as part of a transaction
+func (r *Repository) SetDistrictCityTx(ctx context.Context, tx *sqlx.Tx, districtID, cityID uuid.UUID) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE districts SET city_id = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`,
+		cityID, districtID)
+	return err
+}
 
+// UpdateDistrictResourcesTx updates district resources as part of a transaction
+func (r *Repository) UpdateDistrictResourcesTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID, resources map[models.ResourceType]int64) error {
 	for resourceType, amount := range resources {
-		_, err = tx.ExecContext(ctx,
+		_, err := tx.ExecContext(ctx,
 			`INSERT INTO district_resources (district_id, resource_type, amount) 
 			VALUES ($1, $2, $3)
 			ON CONFLICT (district_id, resource_type) 
@@ -130,11 +152,78 @@ func (r *Repository) UpdateDistrictResources(ctx context.Context, districtID uui
 		}
 	}
 
-	return tx.Commit()
+	return nil
 }
 
-func (r *Repository) getDistrictResources(ctx context.Context, districtID uuid.UUID) (map[models.ResourceType]int64, error) {
-	rows, err := r.db.QueryContext(ctx,
+// GetDistrictUpkeepTimeTx returns when upkeep was last settled for a district
+func (r *Repository) GetDistrictUpkeepTimeTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID) (time.Time, error) {
+	var lastUpkeepAt time.Time
+	err := tx.QueryRowContext(ctx,
+		`SELECT last_upkeep_at FROM districts WHERE id = $1`, districtID).Scan(&lastUpkeepAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, fmt.Errorf("district not found")
+	}
+	return lastUpkeepAt, err
+}
+
+// UpdateDistrictUpkeepTx stores the population and efficiency resulting from
+// settling upkeep at settledAt as part of a transaction
+func (r *Repository) UpdateDistrictUpkeepTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID, population int, efficiency float64, settledAt time.Time) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE districts
+		SET population = $1, efficiency = $2, last_upkeep_at = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4`,
+		population, efficiency, settledAt, districtID)
+	return err
+}
+
+// GetDistrictGridSize returns the width and height of a district's grid
+func (r *Repository) GetDistrictGridSize(ctx context.Context, districtID uuid.UUID) (int, error) {
+	return getDistrictGridSize(ctx, r.db, districtID)
+}
+
+// GetDistrictGridSizeTx reads the grid size as part of a transaction
+func (r *Repository) GetDistrictGridSizeTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID) (int, error) {
+	return getDistrictGridSize(ctx, tx, districtID)
+}
+
+func getDistrictGridSize(ctx context.Context, q sqlx.QueryerContext, districtID uuid.UUID) (int, error) {
+	var gridSize int
+	err := q.QueryRowxContext(ctx,
+		`SELECT grid_size FROM districts WHERE id = $1`, districtID).Scan(&gridSize)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("district not found")
+	}
+	return gridSize, err
+}
+
+// SetDistrictGridSizeTx stores an expanded grid size as part of a transaction
+func (r *Repository) SetDistrictGridSizeTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID, gridSize int) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE districts SET grid_size = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`,
+		gridSize, districtID)
+	return err
+}
+
+// GetDistrictResourcesTx reads district resources as part of a transaction
+func (r *Repository) GetDistrictResourcesTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID) (map[models.ResourceType]int64, error) {
+	return getDistrictResources(ctx, tx, districtID)
+}
+
+// LockDistrictTx locks a district row until tx ends, serializing changes to
+// its resources, buildings and construction queue
+func (r *Repository) LockDistrictTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID) error {
+	var id uuid.UUID
+	err := tx.QueryRowContext(ctx,
+		`SELECT id FROM districts WHERE id = $1 FOR UPDATE`, districtID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrDistrictNotFound
+	}
+	return err
+}
+
+func getDistrictResources(ctx context.Context, q sqlx.QueryerContext, districtID uuid.UUID) (map[models.ResourceType]int64, error) {
+	rows, err := q.QueryContext(ctx,
 		`SELECT resource_type, amount FROM district_resources WHERE district_id = $1`,
 		districtID)
 	if err != nil {
@@ -158,6 +247,15 @@ func (r *Repository) getDistrictResources(ctx context.Context, districtID uuid.U
 // Building operations
 
 func (r *Repository) GetBuildingsByDistrict(ctx context.Context, districtID uuid.UUID) ([]*models.Building, error) {
+	return getBuildingsByDistrict(ctx, r.db, districtID)
+}
+
+// GetBuildingsByDistrictTx reads district buildings as part of a transaction
+func (r *Repository) GetBuildingsByDistrictTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID) ([]*models.Building, error) {
+	return getBuildingsByDistrict(ctx, tx, districtID)
+}
+
+func getBuildingsByDistrict(ctx context.Context, q sqlx.QueryerContext, districtID uuid.UUID) ([]*models.Building, error) {
 	var buildings []*models.Building
 	query := `
 		SELECT id, district_id, type, level, health, max_health, 
@@ -167,7 +265,7 @@ func (r *Repository) GetBuildingsByDistrict(ctx context.Context, districtID uuid
 		WHERE district_id = $1
 		ORDER BY created_at`
 	
-	rows, err := r.db.QueryContext(ctx, query, districtID)
+	rows, err := q.QueryContext(ctx, query, districtID)
 	if err != nil {
 		return nil, err
 	}
@@ -187,14 +285,45 @@ func (r *Repository) GetBuildingsByDistrict(ctx context.Context, districtID uuid
 	return buildings, rows.Err()
 }
 
-func (r *Repository) CreateBuilding(ctx context.Context, building *models.Building) error {
+func (r *Repository) GetBuildingByID(ctx context.Context, buildingID uuid.UUID) (*models.Building, error) {
+	query := `
+		SELECT id, district_id, type, level, health, max_health, 
+		       position_x, position_y, is_active, upgrade_end_at, 
+		       created_at, updated_at
+		FROM buildings 
+		WHERE id = $1`
+
+	var b models.Building
+	err := r.db.QueryRowContext(ctx, query, buildingID).Scan(&b.ID, &b.DistrictID, &b.Type, &b.Level,
+		&b.Health, &b.MaxHealth, &b.Position.X, &b.Position.Y,
+		&b.IsActive, &b.UpgradeEndAt, &b.CreatedAt, &b.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("building not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+func (r *Repository) CreateBuilding(ctx context.Context, building *models.Building, production []models.BuildingProduction) error {
+	return createBuilding(ctx, r.db, building, production)
+}
+
+// CreateBuildingTx creates a building and its production as part of a transaction
+func (r *Repository) CreateBuildingTx(ctx context.Context, tx *sqlx.Tx, building *models.Building, production []models.BuildingProduction) error {
+	return createBuilding(ctx, tx, building, production)
+}
+
+func createBuilding(ctx context.Context, exec sqlx.ExecerContext, building *models.Building, production []models.BuildingProduction) error {
 	query := `
 		INSERT INTO buildings (id, district_id, type, level, health, max_health,
 		                      position_x, position_y, is_active, upgrade_end_at,
 		                      created_at, updated_at)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
 	
-	_, err := r.db.ExecContext(ctx, query,
+	_, err := exec.ExecContext(ctx, query,
 		building.ID, building.DistrictID, building.Type, building.Level,
 		building.Health, building.MaxHealth, building.Position.X, building.Position.Y,
 		building.IsActive, building.UpgradeEndAt, building.CreatedAt, building.UpdatedAt)
@@ -204,15 +333,13 @@ func (r *Repository) CreateBuilding(ctx context.Context, building *models.Buildi
 	}
 
 	// Initialize production if applicable
-	if production := getDefaultProduction(building.Type); production != nil {
-		for _, prod := range production {
-			_, err = r.db.ExecContext(ctx,
-				`INSERT INTO building_production (building_id, resource_type, rate, last_collected)
-				VALUES ($1, $2, $3, CURRENT_TIMESTAMP)`,
-				building.ID, prod.ResourceType, prod.Rate)
-			if err != nil {
-				return fmt.Errorf("failed to create production: %w", err)
-			}
+	for _, prod := range production {
+		_, err = exec.ExecContext(ctx,
+			`INSERT INTO building_production (building_id, resource_type, rate, last_collected)
+			VALUES ($1, $2, $3, CURRENT_TIMESTAMP)`,
+			building.ID, prod.ResourceType, prod.Rate)
+		if err != nil {
+			return fmt.Errorf("failed to create production: %w", err)
 		}
 	}
 
@@ -220,19 +347,109 @@ func (r *Repository) CreateBuilding(ctx context.Context, building *models.Buildi
 }
 
 func (r *Repository) UpdateBuilding(ctx context.Context, building *models.Building) error {
+	return updateBuilding(ctx, r.db, building)
+}
+
+// UpdateBuildingTx updates a building as part of a transaction
+func (r *Repository) UpdateBuildingTx(ctx context.Context, tx *sqlx.Tx, building *models.Building) error {
+	return updateBuilding(ctx, tx, building)
+}
+
+func updateBuilding(ctx context.Context, exec sqlx.ExecerContext, building *models.Building) error {
 	query := `
 		UPDATE buildings 
 		SET level = $1, health = $2, max_health = $3, is_active = $4,
 		    upgrade_end_at = $5, updated_at = CURRENT_TIMESTAMP
 		WHERE id = $6`
 	
-	_, err := r.db.ExecContext(ctx, query,
+	_, err := exec.ExecContext(ctx, query,
 		building.Level, building.Health, building.MaxHealth,
 		building.IsActive, building.UpgradeEndAt, building.ID)
 	
 	return err
 }
 
+// UpdateBuildingPositionTx moves a building as part of a transaction
+func (r *Repository) UpdateBuildingPositionTx(ctx context.Context, tx *sqlx.Tx, building *models.Building) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE buildings 
+		SET position_x = $1, position_y = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3`,
+		building.Position.X, building.Position.Y, building.ID)
+	return err
+}
+
+// DeleteBuildingTx removes a building and its production as part of a transaction
+func (r *Repository) DeleteBuildingTx(ctx context.Context, tx *sqlx.Tx, buildingID uuid.UUID) error {
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM building_production WHERE building_id = $1`, buildingID); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `DELETE FROM buildings WHERE id = $1`, buildingID)
+	return err
+}
+
+// LockDueUpgrades returns buildings whose upgrade finished before now, locked
+// until tx ends. Rows locked by another game-service replica are skipped, so
+// every upgrade is completed exactly once. Passing uuid.Nil as districtID
+// selects buildings from all districts.
+func (r *Repository) LockDueUpgrades(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID, now time.Time, limit int) ([]*DueUpgrade, error) {
+	query := `
+		SELECT b.id, b.district_id, b.type, b.level, b.health, b.max_health,
+		       b.position_x, b.position_y, b.is_active, b.upgrade_end_at,
+		       b.created_at, b.updated_at, d.owner_id
+		FROM buildings b
+		JOIN districts d ON d.id = b.district_id
+		WHERE b.upgrade_end_at IS NOT NULL AND b.upgrade_end_at <= $1
+		  AND ($2 = '00000000-0000-0000-0000-000000000000'::uuid OR b.district_id = $2)
+		ORDER BY b.upgrade_end_at
+		LIMIT $3
+		FOR UPDATE OF b SKIP LOCKED`
+
+	rows, err := tx.QueryContext(ctx, query, now, districtID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []*DueUpgrade
+	for rows.Next() {
+		var b models.Building
+		var ownerID uuid.UUID
+		err := rows.Scan(&b.ID, &b.DistrictID, &b.Type, &b.Level,
+			&b.Health, &b.MaxHealth, &b.Position.X, &b.Position.Y,
+			&b.IsActive, &b.UpgradeEndAt, &b.CreatedAt, &b.UpdatedAt, &ownerID)
+		if err != nil {
+			return nil, err
+		}
+		due = append(due, &DueUpgrade{Building: &b, OwnerID: ownerID})
+	}
+
+	return due, rows.Err()
+}
+
+// GetDistrictProductionTx returns the production of every building in a
+// district, keyed by building, as part of a transaction
+func (r *Repository) GetDistrictProductionTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID) (map[uuid.UUID][]*models.BuildingProduction, error) {
+	var production []*models.BuildingProduction
+	query := `
+		SELECT p.building_id, p.resource_type, p.rate, p.last_collected
+		FROM building_production p
+		JOIN buildings b ON b.id = p.building_id
+		WHERE b.district_id = $1`
+
+	if err := tx.SelectContext(ctx, &production, query, districtID); err != nil {
+		return nil, err
+	}
+
+	byBuilding := make(map[uuid.UUID][]*models.BuildingProduction)
+	for _, prod := range production {
+		byBuilding[prod.BuildingID] = append(byBuilding[prod.BuildingID], prod)
+	}
+	return byBuilding, nil
+}
+
 func (r *Repository) GetBuildingProduction(ctx context.Context, buildingID uuid.UUID) ([]*models.BuildingProduction, error) {
 	var production []*models.BuildingProduction
 	query := `
@@ -244,12 +461,33 @@ func (r *Repository) GetBuildingProduction(ctx context.Context, buildingID uuid.
 	return production, err
 }
 
-func (r *Repository) UpdateProductionCollected(ctx context.Context, buildingID uuid.UUID) error {
-	_, err := r.db.ExecContext(ctx,
+// SetBuildingProductionTx updates the production rates of a building as part
+// of a transaction. Resources the building didn't produce before start
+// accumulating from now.
+func (r *Repository) SetBuildingProductionTx(ctx context.Context, tx *sqlx.Tx, buildingID uuid.UUID, production []models.BuildingProduction) error {
+	for _, prod := range production {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO building_production (building_id, resource_type, rate, last_collected)
+			VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+			ON CONFLICT (building_id, resource_type)
+			DO UPDATE SET rate = $3`,
+			buildingID, prod.ResourceType, prod.Rate)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateProductionCollectedTx marks a building's production as collected up
+// to at, as part of a transaction. The time is passed in rather than taken
+// from the database, whose clock stops at the start of the transaction.
+func (r *Repository) UpdateProductionCollectedTx(ctx context.Context, tx *sqlx.Tx, buildingID uuid.UUID, at time.Time) error {
+	_, err := tx.ExecContext(ctx,
 		`UPDATE building_production 
-		SET last_collected = CURRENT_TIMESTAMP 
-		WHERE building_id = $1`,
-		buildingID)
+		SET last_collected = $1 
+		WHERE building_id = $2`,
+		at, buildingID)
 	return err
 }
 
@@ -329,15 +567,10 @@ func (r *Repository) CreateGuild(ctx context.Context, guild *models.Guild) error
 	return tx.Commit()
 }
 
-func (r *Repository) JoinGuild(ctx context.Context, guildID, userID uuid.UUID, role models.GuildRole) error {
-	tx, err := r.db.Beginx()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
+// JoinGuildTx adds a member to a guild as part of a transaction
+func (r *Repository) JoinGuildTx(ctx context.Context, tx *sqlx.Tx, guildID, userID uuid.UUID, role models.GuildRole) error {
 	// Add member
-	_, err = tx.ExecContext(ctx,
+	_, err := tx.ExecContext(ctx,
 		`INSERT INTO guild_members (guild_id, user_id, role, joined_at)
 		VALUES ($1, $2, $3, CURRENT_TIMESTAMP)`,
 		guildID, userID, role)
@@ -357,22 +590,13 @@ func (r *Repository) JoinGuild(ctx context.Context, guildID, userID uuid.UUID, r
 	_, err = tx.ExecContext(ctx,
 		`UPDATE guilds SET member_count = member_count + 1 WHERE id = $1`,
 		guildID)
-	if err != nil {
-		return err
-	}
-
-	return tx.Commit()
+	return err
 }
 
-func (r *Repository) LeaveGuild(ctx context.Context, guildID, userID uuid.UUID) error {
-	tx, err := r.db.Beginx()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
+// LeaveGuildTx removes a member from a guild as part of a transaction
+func (r *Repository) LeaveGuildTx(ctx context.Context, tx *sqlx.Tx, guildID, userID uuid.UUID) error {
 	// Remove member
-	_, err = tx.ExecContext(ctx,
+	_, err := tx.ExecContext(ctx,
 		`DELETE FROM guild_members WHERE guild_id = $1 AND user_id = $2`,
 		guildID, userID)
 	if err != nil {
@@ -391,11 +615,50 @@ func (r *Repository) LeaveGuild(ctx context.Context, guildID, userID uuid.UUID)
 	_, err = tx.ExecContext(ctx,
 		`UPDATE guilds SET member_count = member_count - 1 WHERE id = $1`,
 		guildID)
-	if err != nil {
-		return err
+	return err
+}
+
+// GetUserGuildID returns the guild a user belongs to, or nil
+func (r *Repository) GetUserGuildID(ctx context.Context, userID uuid.UUID) (*uuid.UUID, error) {
+	return getUserGuildID(ctx, r.db, userID)
+}
+
+// GetUserGuildIDTx reads a user's guild as part of a transaction
+func (r *Repository) GetUserGuildIDTx(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID) (*uuid.UUID, error) {
+	return getUserGuildID(ctx, tx, userID)
+}
+
+func getUserGuildID(ctx context.Context, q sqlx.QueryerContext, userID uuid.UUID) (*uuid.UUID, error) {
+	var guildID *uuid.UUID
+	err := q.QueryRowxContext(ctx, `SELECT guild_id FROM users WHERE id = $1`, userID).Scan(&guildID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user not found")
 	}
+	return guildID, err
+}
 
-	return tx.Commit()
+// GetGuildRoleTx returns a member's role in a guild as part of a transaction
+func (r *Repository) GetGuildRoleTx(ctx context.Context, tx *sqlx.Tx, guildID, userID uuid.UUID) (models.GuildRole, error) {
+	var role models.GuildRole
+	err := tx.QueryRowContext(ctx,
+		`SELECT role FROM guild_members WHERE guild_id = $1 AND user_id = $2`,
+		guildID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("not a member of this guild")
+	}
+	return role, err
+}
+
+// LockGuildTx locks a guild row until tx ends, serializing changes to its
+// members and cities
+func (r *Repository) LockGuildTx(ctx context.Context, tx *sqlx.Tx, guildID uuid.UUID) error {
+	var id uuid.UUID
+	err := tx.QueryRowContext(ctx,
+		`SELECT id FROM guilds WHERE id = $1 FOR UPDATE`, guildID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("guild not found")
+	}
+	return err
 }
 
 func (r *Repository) getGuildTreasury(ctx context.Context, guildID uuid.UUID) (map[models.ResourceType]int64, error) {
@@ -420,28 +683,7 @@ func (r *Repository) getGuildTreasury(ctx context.Context, guildID uuid.UUID) (m
 	return treasury, rows.Err()
 }
 
-// Helper functions
-
-func getDefaultProduction(buildingType models.BuildingType) []models.BuildingProduction {
-	switch buildingType {
-	case models.BuildingFarm:
-		return []models.BuildingProduction{
-			{ResourceType: models.ResourceFood, Rate: 100},
-		}
-	case models.BuildingMine:
-		return []models.BuildingProduction{
-			{ResourceType: models.ResourceGold, Rate: 50},
-			{ResourceType: models.ResourceStone, Rate: 75},
-		}
-	case models.BuildingLumberMill:
-		return []models.BuildingProduction{
-			{ResourceType: models.ResourceWood, Rate: 80},
-		}
-	case models.BuildingPowerPlant:
-		return []models.BuildingProduction{
-			{ResourceType: models.ResourceEnergy, Rate: 60},
-		}
-	default:
-		return nil
-	}
-}
\ No newline at end of file
+// Transaction executes a function within a database transaction
+func (r *Repository) Transaction(ctx context.Context, fn func(*sqlx.Tx) error) error {
+	return r.db.TransactionContext(ctx, fn)
+}
@@ -0,0 +1,180 @@
+package game
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ton-empire/backend/internal/common/config"
+	"github.com/ton-empire/backend/pkg/logger"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+const (
+	defaultSchedulerInterval = 5 * time.Second
+	upgradeBatchSize         = 100
+)
+
+// DueUpgrade is a building whose upgrade timer has run out
+type DueUpgrade struct {
+	Building *models.Building
+	OwnerID  uuid.UUID
+}
+
+// Scheduler runs the game service's periodic background jobs
+type Scheduler struct {
+	service   *Service
+	interval  time.Duration
+	batchSize int
+	// When the next point of the exchange's price history starts
+	nextExchangePrices time.Time
+}
+
+func NewScheduler(service *Service, cfg config.SchedulerConfig) *Scheduler {
+	scheduler := &Scheduler{
+		service:   service,
+		interval:  cfg.Interval,
+		batchSize: cfg.BatchSize,
+	}
+
+	if scheduler.interval <= 0 {
+		scheduler.interval = defaultSchedulerInterval
+	}
+	if scheduler.batchSize <= 0 {
+		scheduler.batchSize = upgradeBatchSize
+	}
+
+	return scheduler
+}
+
+// Run executes scheduled jobs until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.completeUpgrades(ctx)
+			s.completeTraining(ctx)
+			s.endGuildWars(ctx)
+			s.scheduleWaves(ctx)
+			s.resolveWaves(ctx)
+			s.arriveCaravans(ctx)
+			s.openExchangePrices(ctx)
+		}
+	}
+}
+
+func (s *Scheduler) completeUpgrades(ctx context.Context) {
+	// Keep draining while full batches come back so a backlog clears
+	// within a single tick
+	for {
+		completed, err := s.service.CompleteDueUpgrades(ctx, s.batchSize)
+		if err != nil {
+			logger.Errorf("Failed to complete due upgrades: %v", err)
+			return
+		}
+		if completed > 0 {
+			logger.Infof("Completed %d building upgrades", completed)
+		}
+		if completed < s.batchSize || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (s *Scheduler) completeTraining(ctx context.Context) {
+	for {
+		completed, err := s.service.CompleteDueTraining(ctx, s.batchSize)
+		if err != nil {
+			logger.Errorf("Failed to complete due training: %v", err)
+			return
+		}
+		if completed > 0 {
+			logger.Infof("Completed %d training orders", completed)
+		}
+		if completed < s.batchSize || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (s *Scheduler) endGuildWars(ctx context.Context) {
+	for {
+		ended, err := s.service.EndDueGuildWars(ctx, s.batchSize)
+		if err != nil {
+			logger.Errorf("Failed to end due guild wars: %v", err)
+			return
+		}
+		if ended > 0 {
+			logger.Infof("Ended %d guild wars", ended)
+		}
+		if ended < s.batchSize || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (s *Scheduler) scheduleWaves(ctx context.Context) {
+	for {
+		announced, err := s.service.ScheduleWaves(ctx, s.batchSize)
+		if err != nil {
+			logger.Errorf("Failed to schedule waves: %v", err)
+			return
+		}
+		if announced > 0 {
+			logger.Infof("Announced %d waves", announced)
+		}
+		if announced < s.batchSize || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (s *Scheduler) resolveWaves(ctx context.Context) {
+	for {
+		resolved, err := s.service.ResolveDueWaves(ctx, s.batchSize)
+		if err != nil {
+			logger.Errorf("Failed to resolve due waves: %v", err)
+			return
+		}
+		if resolved > 0 {
+			logger.Infof("Resolved %d waves", resolved)
+		}
+		if resolved < s.batchSize || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (s *Scheduler) arriveCaravans(ctx context.Context) {
+	for {
+		arrived, err := s.service.ArriveDueCaravans(ctx, s.batchSize)
+		if err != nil {
+			logger.Errorf("Failed to unload due caravans: %v", err)
+			return
+		}
+		if arrived > 0 {
+			logger.Infof("Unloaded %d caravans", arrived)
+		}
+		if arrived < s.batchSize || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (s *Scheduler) openExchangePrices(ctx context.Context) {
+	if time.Now().Before(s.nextExchangePrices) {
+		return
+	}
+
+	next, err := s.service.OpenExchangePrices(ctx)
+	if err != nil {
+		logger.Errorf("Failed to open exchange prices: %v", err)
+		return
+	}
+	s.nextExchangePrices = next
+}
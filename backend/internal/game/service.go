@@ -2,32 +2,43 @@ package game
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"math"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/ton-empire/backend/internal/common/events"
 	"github.com/ton-empire/backend/pkg/models"
 	"github.com/ton-empire/backend/pkg/logger"
 )
 
 type Service struct {
-	repo *Repository
+	repo    *Repository
+	catalog *CatalogStore
+	events  *events.Publisher
 }
 
-func NewService(repo *Repository) *Service {
+func NewService(repo *Repository, catalog *CatalogStore, publisher *events.Publisher) *Service {
 	return &Service{
-		repo: repo,
+		repo:    repo,
+		catalog: catalog,
+		events:  publisher,
 	}
 }
 
+// GetCatalog returns the active building catalog
+func (s *Service) GetCatalog() *Catalog {
+	return s.catalog.Get()
+}
+
 // District operations
 
 func (s *Service) GetUserDistrict(ctx context.Context, userID uuid.UUID) (*models.District, error) {
 	district, err := s.repo.GetDistrictByUserID(ctx, userID)
 	if err != nil {
 		// If no district exists, create one
-		if err.Error() == "district not found" {
+		if errors.Is(err, ErrDistrictNotFound) {
 			return s.createStarterDistrict(ctx, userID)
 		}
 		return nil, fmt.Errorf("failed to get district: %w", err)
@@ -36,13 +47,18 @@ func (s *Service) GetUserDistrict(ctx context.Context, userID uuid.UUID) (*model
 }
 
 func (s *Service) createStarterDistrict(ctx context.Context, userID uuid.UUID) (*models.District, error) {
-	// For MVP, create a district in a default city
-	// In full version, this would involve city/guild selection
-	
+	guildID, err := s.repo.GetUserGuildID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get guild: %w", err)
+	}
+	registeredAt, err := s.repo.GetUserCreatedAt(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
 	district := &models.District{
 		ID:         uuid.New(),
 		OwnerID:    userID,
-		CityID:     uuid.New(), // Default city for now
 		Name:       "Starter District",
 		Population: 100,
 		Efficiency: 100.0,
@@ -57,28 +73,53 @@ func (s *Service) createStarterDistrict(ctx context.Context, userID uuid.UUID) (
 		UpdatedAt: time.Now(),
 	}
 
-	if err := s.repo.CreateDistrict(ctx, district); err != nil {
-		return nil, fmt.Errorf("failed to create starter district: %w", err)
-	}
-
 	// Create starter buildings
 	townHall := &models.Building{
 		ID:         uuid.New(),
 		DistrictID: district.ID,
 		Type:       models.BuildingTownHall,
 		Level:      1,
-		Health:     100,
-		MaxHealth:  100,
-		Position:   models.Position{X: 5, Y: 5},
+		Position:   starterTownHallPosition,
 		IsActive:   true,
 		CreatedAt:  time.Now(),
 		UpdatedAt:  time.Now(),
 	}
+	townHall.MaxHealth = s.catalog.Get().MaxHealth(townHall)
+	townHall.Health = townHall.MaxHealth
 
-	if err := s.repo.CreateBuilding(ctx, townHall); err != nil {
-		logger.Errorf("Failed to create starter town hall: %v", err)
+	var city *City
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		// Guild members settle in a city of their guild, everyone else and
+		// members whose guild cities are full in an NPC city
+		if guildID != nil {
+			if city, err = s.openCityTx(ctx, tx, guildID); err != nil {
+				return err
+			}
+		}
+		if city == nil {
+			if city, err = s.openCityTx(ctx, tx, nil); err != nil {
+				return err
+			}
+		}
+		district.CityID = city.ID
+
+		if err := s.repo.CreateDistrictTx(ctx, tx, district); err != nil {
+			return err
+		}
+
+		// New players are shielded for the beginner period
+		if beginnerUntil := registeredAt.Add(s.catalog.Get().Shield.Beginner); beginnerUntil.After(time.Now()) {
+			if _, err := s.grantShieldTx(ctx, tx, district.ID, beginnerUntil); err != nil {
+				return err
+			}
+		}
+		return s.repo.CreateBuildingTx(ctx, tx, townHall, nil)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create starter district: %w", err)
 	}
 
+	s.notifyCityUpdate(ctx, city.ID)
 	return district, nil
 }
 
@@ -99,44 +140,62 @@ func (s *Service) CreateBuilding(ctx context.Context, userID uuid.UUID, req Crea
 		return nil, fmt.Errorf("district not found: %w", err)
 	}
 
-	// Validate building placement
-	if err := s.validateBuildingPlacement(ctx, district.ID, req.Position); err != nil {
+	catalog := s.catalog.Get()
+	spec, err := catalog.Building(req.Type)
+	if err != nil {
 		return nil, err
 	}
 
-	// Check resource requirements
-	cost := getBuildingCost(req.Type, 1)
-	if !s.hasEnoughResources(district.Resources, cost) {
-		return nil, fmt.Errorf("insufficient resources")
-	}
-
 	// Create building
 	building := &models.Building{
 		ID:         uuid.New(),
 		DistrictID: district.ID,
 		Type:       req.Type,
 		Level:      1,
-		Health:     100,
-		MaxHealth:  100,
 		Position:   req.Position,
 		IsActive:   true,
 		CreatedAt:  time.Now(),
 		UpdatedAt:  time.Now(),
 	}
+	building.MaxHealth = catalog.MaxHealth(building)
+	building.Health = building.MaxHealth
 
-	// Deduct resources
-	for resourceType, amount := range cost {
-		district.Resources[resourceType] -= amount
-	}
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
 
-	// Save building
-	if err := s.repo.CreateBuilding(ctx, building); err != nil {
-		return nil, fmt.Errorf("failed to create building: %w", err)
-	}
+		// Validate building placement
+		if err := s.validateBuildingPlacementTx(ctx, tx, district.ID, req.Position); err != nil {
+			return err
+		}
+
+		// Check prerequisites and Town Hall limits
+		buildings, err := s.repo.GetBuildingsByDistrictTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		if err := checkConstruction(spec, req.Type, buildings); err != nil {
+			return err
+		}
+
+		// Check resource requirements
+		cost, err := catalog.Cost(req.Type, 1)
+		if err != nil {
+			return err
+		}
+		if _, err := s.spendResourcesTx(ctx, tx, district.ID, cost, LedgerReasonConstruction, buildingSource(building.ID)); err != nil {
+			return err
+		}
 
-	// Update district resources
-	if err := s.repo.UpdateDistrictResources(ctx, district.ID, district.Resources); err != nil {
-		return nil, fmt.Errorf("failed to update resources: %w", err)
+		// Save building
+		if err := s.repo.CreateBuildingTx(ctx, tx, building, catalog.Production(building.Type, building.Level)); err != nil {
+			return fmt.Errorf("failed to create building: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	logger.Infof("Building created: %s at (%d,%d) in district %s", 
@@ -152,53 +211,191 @@ func (s *Service) UpgradeBuilding(ctx context.Context, userID uuid.UUID, buildin
 		return nil, fmt.Errorf("district not found: %w", err)
 	}
 
-	// Get buildings to verify ownership
-	buildings, err := s.repo.GetBuildingsByDistrict(ctx, district.ID)
+	catalog := s.catalog.Get()
+	var building *models.Building
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+
+		// Get buildings to verify ownership
+		buildings, err := s.repo.GetBuildingsByDistrictTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+
+		building = findBuilding(buildings, buildingID)
+		if building == nil {
+			return fmt.Errorf("building not found")
+		}
+
+		// Check if already upgrading
+		if isUpgrading(building) {
+			return fmt.Errorf("building is already upgrading")
+		}
+		if !building.IsActive {
+			return fmt.Errorf("building is damaged, repair it first")
+		}
+		if err := s.checkNotQueued(ctx, tx, district.ID, building.ID); err != nil {
+			return err
+		}
+
+		spec, err := catalog.Building(building.Type)
+		if err != nil {
+			return err
+		}
+		if building.Level >= spec.MaxLevel {
+			return fmt.Errorf("building is already at max level")
+		}
+		if err := catalog.checkLevelCap(building, building.Level+1, buildings); err != nil {
+			return err
+		}
+
+		// Check for a free builder
+		if countUpgrading(buildings) >= catalog.BuilderSlots(townHallLevel(buildings)) {
+			return fmt.Errorf("no free builder slots, queue the upgrade instead")
+		}
+
+		// Check resource requirements
+		cost, err := catalog.Cost(building.Type, building.Level+1)
+		if err != nil {
+			return err
+		}
+		if _, err := s.spendResourcesTx(ctx, tx, district.ID, cost, LedgerReasonUpgrade, buildingSource(building.ID)); err != nil {
+			return err
+		}
+
+		// Start upgrade
+		upgradeDuration, err := catalog.Duration(building.Type, building.Level+1)
+		if err != nil {
+			return err
+		}
+		upgradeEndAt := time.Now().Add(upgradeDuration)
+		building.UpgradeEndAt = &upgradeEndAt
+
+		// Update building
+		if err := s.repo.UpdateBuildingTx(ctx, tx, building); err != nil {
+			return fmt.Errorf("failed to update building: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	return building, nil
+}
+
+// DemolishBuilding removes a building and refunds part of everything that
+// was spent constructing and upgrading it
+func (s *Service) DemolishBuilding(ctx context.Context, userID uuid.UUID, buildingID uuid.UUID) (*DemolishResult, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	catalog := s.catalog.Get()
 	var building *models.Building
-	for _, b := range buildings {
-		if b.ID == buildingID {
-			building = b
-			break
+	var result *DemolishResult
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
 		}
-	}
 
-	if building == nil {
-		return nil, fmt.Errorf("building not found")
-	}
+		buildings, err := s.repo.GetBuildingsByDistrictTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		building = findBuilding(buildings, buildingID)
+		if building == nil {
+			return fmt.Errorf("building not found")
+		}
+		if err := checkBuildingMovable(building); err != nil {
+			return err
+		}
+		if err := s.checkNotQueued(ctx, tx, district.ID, building.ID); err != nil {
+			return err
+		}
 
-	// Check if already upgrading
-	if building.UpgradeEndAt != nil && building.UpgradeEndAt.After(time.Now()) {
-		return nil, fmt.Errorf("building is already upgrading")
-	}
+		refund := make(map[models.ResourceType]int64)
+		for level := 1; level <= building.Level; level++ {
+			cost, err := catalog.Cost(building.Type, level)
+			if err != nil {
+				break
+			}
+			for resourceType, amount := range cost {
+				refund[resourceType] += int64(float64(amount) * catalog.DemolishRefund)
+			}
+		}
 
-	// Check resource requirements
-	cost := getBuildingCost(building.Type, building.Level+1)
-	if !s.hasEnoughResources(district.Resources, cost) {
-		return nil, fmt.Errorf("insufficient resources")
-	}
+		if err := s.repo.DeleteBuildingTx(ctx, tx, building.ID); err != nil {
+			return fmt.Errorf("failed to delete building: %w", err)
+		}
 
-	// Start upgrade
-	upgradeDuration := getUpgradeDuration(building.Type, building.Level+1)
-	upgradeEndAt := time.Now().Add(upgradeDuration)
-	building.UpgradeEndAt = &upgradeEndAt
+		resources, err := s.changeResourcesTx(ctx, tx, district.ID, refund, LedgerReasonDemolish, buildingSource(building.ID))
+		if err != nil {
+			return err
+		}
 
-	// Deduct resources
-	for resourceType, amount := range cost {
-		district.Resources[resourceType] -= amount
+		result = &DemolishResult{
+			Refund:         refund,
+			TotalResources: resources,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Update building
-	if err := s.repo.UpdateBuilding(ctx, building); err != nil {
-		return nil, fmt.Errorf("failed to update building: %w", err)
+	logger.Infof("Building demolished: %s at (%d,%d) in district %s",
+		building.Type, building.Position.X, building.Position.Y, district.ID)
+
+	return result, nil
+}
+
+// MoveBuilding relocates a building to another free cell of the district
+func (s *Service) MoveBuilding(ctx context.Context, userID uuid.UUID, buildingID uuid.UUID, req MoveBuildingRequest) (*models.Building, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
 	}
 
-	// Update district resources
-	if err := s.repo.UpdateDistrictResources(ctx, district.ID, district.Resources); err != nil {
-		return nil, fmt.Errorf("failed to update resources: %w", err)
+	var building *models.Building
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+
+		buildings, err := s.repo.GetBuildingsByDistrictTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		building = findBuilding(buildings, buildingID)
+		if building == nil {
+			return fmt.Errorf("building not found")
+		}
+		if err := checkBuildingMovable(building); err != nil {
+			return err
+		}
+
+		if err := s.validateBuildingPlacementTx(ctx, tx, district.ID, req.Position); err != nil {
+			return err
+		}
+
+		// Adjacency bonuses change with the layout, so production up to now is
+		// collected with the old one
+		if _, err := s.collectResourcesTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+
+		building.Position = req.Position
+		if err := s.repo.UpdateBuildingPositionTx(ctx, tx, building); err != nil {
+			return fmt.Errorf("failed to move building: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return building, nil
@@ -211,40 +408,89 @@ func (s *Service) CollectResources(ctx context.Context, userID uuid.UUID) (*Coll
 		return nil, fmt.Errorf("district not found: %w", err)
 	}
 
+	// Complete finished upgrades the scheduler hasn't picked up yet
+	if _, err := s.completeUpgrades(ctx, district.ID, upgradeBatchSize); err != nil {
+		logger.Errorf("Failed to complete upgrades for district %s: %v", district.ID, err)
+	}
+
+	var result *CollectResult
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+
+		result, err = s.collectResourcesTx(ctx, tx, district.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// collectResourcesTx credits production and settles upkeep up to now for a
+// district locked by tx. Everything is read after the lock is taken, so
+// concurrent collections can't count the same hours twice.
+func (s *Service) collectResourcesTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID) (*CollectResult, error) {
+	district, err := s.repo.GetDistrictByIDTx(ctx, tx, districtID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
 	// Get all buildings
-	buildings, err := s.repo.GetBuildingsByDistrict(ctx, district.ID)
+	buildings, err := s.repo.GetBuildingsByDistrictTx(ctx, tx, district.ID)
+	if err != nil {
+		return nil, err
+	}
+	productionByBuilding, err := s.repo.GetDistrictProductionTx(ctx, tx, district.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get production: %w", err)
+	}
+	gridSize, err := s.repo.GetDistrictGridSizeTx(ctx, tx, district.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	collected := make(map[models.ResourceType]int64)
+	catalog := s.catalog.Get()
+	capacity := catalog.StorageCapacity(buildings)
+	produced := make(map[models.ResourceType]int64)
 	now := time.Now()
 
+	// Production is scaled by the efficiency the district had since the
+	// last collection
+	efficiency := district.Efficiency / 100
+
+	// and by how well each building is staffed. When the population shrank
+	// below the assigned jobs, every building loses workers evenly.
+	workers, err := s.repo.GetWorkersTx(ctx, tx, district.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workers: %w", err)
+	}
+	employed := 0
+	for _, count := range workers {
+		employed += count
+	}
+	employment := 1.0
+	if employed > district.Population {
+		employment = float64(district.Population) / float64(employed)
+	}
+
 	// Calculate resources from each building
 	for _, building := range buildings {
 		if !building.IsActive {
 			continue
 		}
 
-		// Check if upgrade completed
-		if building.UpgradeEndAt != nil && building.UpgradeEndAt.Before(now) {
-			building.Level++
-			building.UpgradeEndAt = nil
-			building.MaxHealth = float64(100 + (building.Level-1)*20)
-			building.Health = building.MaxHealth
-			
-			if err := s.repo.UpdateBuilding(ctx, building); err != nil {
-				logger.Errorf("Failed to complete upgrade for building %s: %v", building.ID, err)
-			}
-		}
-
-		// Get production data
-		production, err := s.repo.GetBuildingProduction(ctx, building.ID)
-		if err != nil {
-			logger.Errorf("Failed to get production for building %s: %v", building.ID, err)
+		production := productionByBuilding[building.ID]
+		if len(production) == 0 {
 			continue
 		}
 
+		staffing := staffingFactor(catalog.Workers(building.Type, building.Level),
+			workers[building.ID], employment, catalog.Population.UnstaffedRate)
+		adjacency, _ := catalog.adjacencyBonus(district.ID, gridSize, building.Type, building.Position, buildings, uuid.Nil)
+
 		// Calculate resources
 		for _, prod := range production {
 			if prod.LastCollected.IsZero() {
@@ -252,29 +498,149 @@ func (s *Service) CollectResources(ctx context.Context, userID uuid.UUID) (*Coll
 			}
 			
 			duration := now.Sub(prod.LastCollected).Hours()
-			amount := int64(float64(prod.Rate) * duration * (float64(building.Level) * 1.2))
-			
-			collected[prod.ResourceType] += amount
-			district.Resources[prod.ResourceType] += amount
+			rate := catalog.ProductionRate(building.Type, building.Level, prod.ResourceType)
+			produced[prod.ResourceType] += int64(float64(rate) * duration * efficiency * staffing * (1 + adjacency))
 		}
 
 		// Update last collected time
-		if err := s.repo.UpdateProductionCollected(ctx, building.ID); err != nil {
-			logger.Errorf("Failed to update collection time for building %s: %v", building.ID, err)
+		if err := s.repo.UpdateProductionCollectedTx(ctx, tx, building.ID, now); err != nil {
+			return nil, fmt.Errorf("failed to update collection time for building %s: %w", building.ID, err)
+		}
+	}
+
+	// Settle upkeep from stock and fresh production
+	lastUpkeepAt, err := s.repo.GetDistrictUpkeepTimeTx(ctx, tx, district.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upkeep time: %w", err)
+	}
+	troops, err := s.repo.GetTroopsTx(ctx, tx, district.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get troops: %w", err)
+	}
+	hours := now.Sub(lastUpkeepAt).Hours()
+	upkeep := settleUpkeep(district.Resources, produced,
+		catalog.UpkeepRate(buildings, district.Population, troops), hours,
+		catalog.Upkeep.MinEfficiency)
+
+	// Population only grows while the district pays its upkeep in full
+	housing := catalog.HousingCapacity(buildings)
+	if upkeep.Efficiency >= 100 || district.Population > housing {
+		district.Population = growPopulation(district.Population, housing, hours, catalog.Population.GrowthPerHour)
+	}
+
+	collected := make(map[models.ResourceType]int64)
+	overflow := make(map[models.ResourceType]int64)
+	for _, resourceType := range resourceTypes {
+		net := produced[resourceType] - upkeep.Consumed[resourceType]
+
+		// Production beyond the storage capacity is lost. Upkeep is always
+		// charged, even from a district over its capacity.
+		if free := max(capacity[resourceType]-district.Resources[resourceType], 0); net > free {
+			overflow[resourceType] = net - free
 		}
+
+		collected[resourceType] = produced[resourceType] - overflow[resourceType]
 	}
 
-	// Update district resources
-	if err := s.repo.UpdateDistrictResources(ctx, district.ID, district.Resources); err != nil {
-		return nil, fmt.Errorf("failed to update resources: %w", err)
+	// Production and upkeep are recorded as separate ledger entries
+	source := LedgerSource{Type: LedgerSourceDistrict, ID: district.ID}
+	if _, err := s.changeResourcesTx(ctx, tx, district.ID, collected, LedgerReasonProduction, source); err != nil {
+		return nil, err
+	}
+	district.Resources, err = s.spendResourcesTx(ctx, tx, district.ID, upkeep.Consumed, LedgerReasonUpkeep, source)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.UpdateDistrictUpkeepTx(ctx, tx, district.ID, district.Population, upkeep.Efficiency, now); err != nil {
+		return nil, fmt.Errorf("failed to update efficiency: %w", err)
 	}
 
 	return &CollectResult{
 		Collected:      collected,
+		Upkeep:         upkeep.Consumed,
+		Overflow:       overflow,
+		Capacity:       capacity,
+		Efficiency:     upkeep.Efficiency,
+		Population:     district.Population,
 		TotalResources: district.Resources,
 	}, nil
 }
 
+// CompleteDueUpgrades finishes up to limit upgrades whose timer has run out
+// across all districts and returns how many were completed
+func (s *Service) CompleteDueUpgrades(ctx context.Context, limit int) (int, error) {
+	return s.completeUpgrades(ctx, uuid.Nil, limit)
+}
+
+func (s *Service) completeUpgrades(ctx context.Context, districtID uuid.UUID, limit int) (int, error) {
+	var completed []*DueUpgrade
+
+	err := s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		due, err := s.repo.LockDueUpgrades(ctx, tx, districtID, time.Now(), limit)
+		if err != nil {
+			return err
+		}
+
+		catalog := s.catalog.Get()
+		for _, upgrade := range due {
+			building := upgrade.Building
+			applyUpgrade(building, catalog)
+			if err := s.repo.UpdateBuildingTx(ctx, tx, building); err != nil {
+				return fmt.Errorf("failed to complete upgrade for building %s: %w", building.ID, err)
+			}
+
+			production := catalog.Production(building.Type, building.Level)
+			if err := s.repo.SetBuildingProductionTx(ctx, tx, building.ID, production); err != nil {
+				return fmt.Errorf("failed to update production for building %s: %w", building.ID, err)
+			}
+		}
+
+		completed = due
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	districts := make(map[uuid.UUID]uuid.UUID)
+	for _, upgrade := range completed {
+		s.notifyUpgradeComplete(ctx, upgrade)
+		districts[upgrade.Building.DistrictID] = upgrade.OwnerID
+	}
+
+	// Freed builders pick up queued upgrades
+	for districtID, ownerID := range districts {
+		s.startQueuedUpgrades(ctx, districtID, ownerID)
+	}
+
+	return len(completed), nil
+}
+
+func (s *Service) notifyUpgradeComplete(ctx context.Context, upgrade *DueUpgrade) {
+	building := upgrade.Building
+
+	var productionRate int64
+	for _, prod := range s.catalog.Get().Production(building.Type, building.Level) {
+		productionRate += prod.Rate
+	}
+
+	err := s.events.PublishToUser(ctx, upgrade.OwnerID, EventBuildingUpgradeComplete, map[string]interface{}{
+		"id":    building.ID,
+		"type":  building.Type,
+		"level": building.Level,
+		"new_stats": map[string]interface{}{
+			"max_health":      building.MaxHealth,
+			"production_rate": productionRate,
+		},
+	})
+	if err != nil {
+		logger.Errorf("Failed to publish upgrade completion for building %s: %v", building.ID, err)
+	}
+
+	// Completing an upgrade restores health
+	s.notifyBuildingUpdate(ctx, upgrade.OwnerID, building)
+}
+
 // Guild operations
 
 func (s *Service) CreateGuild(ctx context.Context, userID uuid.UUID, req CreateGuildRequest) (*models.Guild, error) {
@@ -311,6 +677,7 @@ func (s *Service) CreateGuild(ctx context.Context, userID uuid.UUID, req CreateG
 	}
 
 	logger.Infof("Guild created: %s [%s] by user %s", guild.Name, guild.Tag, userID)
+	s.notifyGuildMembership(ctx, userID, &guild.ID)
 	return guild, nil
 }
 
@@ -326,48 +693,165 @@ func (s *Service) JoinGuild(ctx context.Context, userID uuid.UUID, guildID uuid.
 		return fmt.Errorf("guild is full")
 	}
 
-	// Add user as citizen
-	if err := s.repo.JoinGuild(ctx, guildID, userID, models.GuildRoleCitizen); err != nil {
-		return fmt.Errorf("failed to join guild: %w", err)
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil && !errors.Is(err, ErrDistrictNotFound) {
+		return fmt.Errorf("failed to get district: %w", err)
 	}
 
+	var city *City
+	var previousCityID uuid.UUID
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockGuildTx(ctx, tx, guildID); err != nil {
+			return err
+		}
+		current, err := s.repo.GetUserGuildIDTx(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		if current != nil {
+			return fmt.Errorf("already in a guild")
+		}
+
+		// Add user as citizen
+		if err := s.repo.JoinGuildTx(ctx, tx, guildID, userID, models.GuildRoleCitizen); err != nil {
+			return fmt.Errorf("failed to join guild: %w", err)
+		}
+
+		// A district created later settles in a guild city right away. An
+		// existing one moves in if a guild city has a free slot.
+		if district == nil {
+			return nil
+		}
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+		existing, err := s.repo.GetDistrictByIDTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		previousCityID = existing.CityID
+		if city, err = s.openCityTx(ctx, tx, &guildID); err != nil || city == nil {
+			return err
+		}
+		return s.repo.SetDistrictCityTx(ctx, tx, district.ID, city.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notifyGuildMembership(ctx, userID, &guildID)
+	if city != nil {
+		logger.Infof("District %s moved to city %s of guild %s", district.ID, city.ID, guildID)
+		s.notifyCityUpdate(ctx, previousCityID, city.ID)
+	}
 	return nil
 }
 
 func (s *Service) LeaveGuild(ctx context.Context, userID uuid.UUID) error {
-	// Get user's district to find guild
-	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	guildID, err := s.repo.GetUserGuildID(ctx, userID)
 	if err != nil {
 		return fmt.Errorf("user not found: %w", err)
 	}
+	if guildID == nil {
+		return fmt.Errorf("not in a guild")
+	}
+
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil && !errors.Is(err, ErrDistrictNotFound) {
+		return fmt.Errorf("failed to get district: %w", err)
+	}
+
+	var previousCity, city *City
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockGuildTx(ctx, tx, *guildID); err != nil {
+			return err
+		}
+		role, err := s.repo.GetGuildRoleTx(ctx, tx, *guildID, userID)
+		if err != nil {
+			return err
+		}
+		if role == models.GuildRoleEmperor {
+			return fmt.Errorf("the emperor can't leave the guild")
+		}
+
+		if err := s.repo.LeaveGuildTx(ctx, tx, *guildID, userID); err != nil {
+			return fmt.Errorf("failed to leave guild: %w", err)
+		}
 
-	// For MVP, we need to get user's guild ID from user table
-	// This would be improved with proper user service integration
-	
-	// Placeholder guild ID
-	guildID := uuid.New() // This should come from user data
+		// Guild cities only hold members, so the district moves to an NPC city
+		if district == nil {
+			return nil
+		}
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+		current, err := s.repo.GetDistrictByIDTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		previousCity, err = s.repo.GetCityTx(ctx, tx, current.CityID)
+		if err != nil {
+			return err
+		}
+		if previousCity.GuildID == nil || *previousCity.GuildID != *guildID {
+			return nil
+		}
 
-	if err := s.repo.LeaveGuild(ctx, guildID, userID); err != nil {
-		return fmt.Errorf("failed to leave guild: %w", err)
+		if city, err = s.openCityTx(ctx, tx, nil); err != nil {
+			return err
+		}
+		return s.repo.SetDistrictCityTx(ctx, tx, district.ID, city.ID)
+	})
+	if err != nil {
+		return err
 	}
 
+	s.notifyGuildMembership(ctx, userID, nil)
+	if city != nil {
+		s.notifyCityUpdate(ctx, previousCity.ID, city.ID)
+	}
 	return nil
 }
 
 // Helper functions
 
 func (s *Service) validateBuildingPlacement(ctx context.Context, districtID uuid.UUID, position models.Position) error {
-	// Check bounds (10x10 grid for MVP)
-	if position.X < 0 || position.X >= 10 || position.Y < 0 || position.Y >= 10 {
-		return fmt.Errorf("position out of bounds")
+	gridSize, err := s.repo.GetDistrictGridSize(ctx, districtID)
+	if err != nil {
+		return err
 	}
-
-	// Check if position is occupied
 	buildings, err := s.repo.GetBuildingsByDistrict(ctx, districtID)
 	if err != nil {
 		return err
 	}
+	return s.checkPlacement(districtID, gridSize, buildings, position)
+}
 
+// validateBuildingPlacementTx validates a placement as part of a transaction
+func (s *Service) validateBuildingPlacementTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID, position models.Position) error {
+	gridSize, err := s.repo.GetDistrictGridSizeTx(ctx, tx, districtID)
+	if err != nil {
+		return err
+	}
+	buildings, err := s.repo.GetBuildingsByDistrictTx(ctx, tx, districtID)
+	if err != nil {
+		return err
+	}
+	return s.checkPlacement(districtID, gridSize, buildings, position)
+}
+
+func (s *Service) checkPlacement(districtID uuid.UUID, gridSize int, buildings []*models.Building, position models.Position) error {
+	// Check bounds against the district's purchased land
+	if !inGrid(position, gridSize) {
+		return fmt.Errorf("position out of bounds")
+	}
+
+	// Terrain can't be built on
+	if terrain := s.catalog.Get().terrainAt(districtID, gridSize, position); terrain != "" {
+		return fmt.Errorf("position is blocked by %s", terrain)
+	}
+
+	// Check if position is occupied
 	for _, building := range buildings {
 		if building.Position.X == position.X && building.Position.Y == position.Y {
 			return fmt.Errorf("position already occupied")
@@ -377,81 +861,69 @@ func (s *Service) validateBuildingPlacement(ctx context.Context, districtID uuid
 	return nil
 }
 
-func (s *Service) hasEnoughResources(available, required map[models.ResourceType]int64) bool {
-	for resourceType, amount := range required {
-		if available[resourceType] < amount {
-			return false
+// isUpgrading reports whether a building has an upgrade in progress. An
+// upgrade whose timer ran out stays in progress until the scheduler completes it.
+func isUpgrading(building *models.Building) bool {
+	return building.UpgradeEndAt != nil
+}
+
+// checkNotQueued rejects changes to a building waiting in the construction queue
+func (s *Service) checkNotQueued(ctx context.Context, tx *sqlx.Tx, districtID, buildingID uuid.UUID) error {
+	queue, err := s.repo.GetQueueTx(ctx, tx, districtID)
+	if err != nil {
+		return err
+	}
+	for _, entry := range queue {
+		if entry.BuildingID == buildingID {
+			return fmt.Errorf("building is queued for upgrade")
 		}
 	}
-	return true
+	return nil
 }
 
-func getBuildingCost(buildingType models.BuildingType, level int) map[models.ResourceType]int64 {
-	baseCosts := map[models.BuildingType]map[models.ResourceType]int64{
-		models.BuildingHouse: {
-			models.ResourceWood:  100,
-			models.ResourceStone: 50,
-			models.ResourceGold:  25,
-		},
-		models.BuildingFarm: {
-			models.ResourceWood: 150,
-			models.ResourceGold: 50,
-		},
-		models.BuildingMine: {
-			models.ResourceWood:  200,
-			models.ResourceStone: 100,
-			models.ResourceGold:  100,
-		},
-		models.BuildingLumberMill: {
-			models.ResourceStone: 150,
-			models.ResourceGold:  75,
-		},
-		models.BuildingPowerPlant: {
-			models.ResourceStone: 300,
-			models.ResourceGold:  200,
-		},
-		models.BuildingBarracks: {
-			models.ResourceWood:  250,
-			models.ResourceStone: 250,
-			models.ResourceGold:  150,
-		},
-		models.BuildingWall: {
-			models.ResourceStone: 500,
-			models.ResourceWood:  100,
-		},
-		models.BuildingMarket: {
-			models.ResourceWood:  200,
-			models.ResourceStone: 200,
-			models.ResourceGold:  300,
-		},
+// checkBuildingMovable rejects demolishing or moving buildings that must stay in place
+func checkBuildingMovable(building *models.Building) error {
+	if building.Type == models.BuildingTownHall {
+		return fmt.Errorf("town hall cannot be demolished or moved")
 	}
+	if isUpgrading(building) {
+		return fmt.Errorf("building is upgrading")
+	}
+	return nil
+}
 
-	cost := make(map[models.ResourceType]int64)
-	if baseCost, ok := baseCosts[buildingType]; ok {
-		multiplier := math.Pow(1.5, float64(level-1))
-		for resourceType, amount := range baseCost {
-			cost[resourceType] = int64(float64(amount) * multiplier)
+// checkPrerequisites verifies the district owns the buildings a spec requires
+func checkPrerequisites(spec *BuildingSpec, buildings []*models.Building) error {
+	for _, prerequisite := range spec.Prerequisites {
+		satisfied := false
+		for _, building := range buildings {
+			if building.Type == prerequisite.Building && building.Level >= prerequisite.Level {
+				satisfied = true
+				break
+			}
+		}
+		if !satisfied {
+			return &Error{
+				Code:    ErrCodePrerequisiteMissing,
+				Message: fmt.Sprintf("requires %s level %d", prerequisite.Building, prerequisite.Level),
+				Details: map[string]interface{}{
+					"building":       prerequisite.Building,
+					"required_level": prerequisite.Level,
+				},
+			}
 		}
 	}
-
-	return cost
+	return nil
 }
 
-func getUpgradeDuration(buildingType models.BuildingType, level int) time.Duration {
-	baseMinutes := map[models.BuildingType]int{
-		models.BuildingHouse:      5,
-		models.BuildingFarm:       10,
-		models.BuildingMine:       15,
-		models.BuildingLumberMill: 12,
-		models.BuildingPowerPlant: 20,
-		models.BuildingBarracks:   25,
-		models.BuildingWall:       30,
-		models.BuildingMarket:     15,
-		models.BuildingTownHall:   60,
-	}
-
-	minutes := baseMinutes[buildingType] * level
-	return time.Duration(minutes) * time.Minute
+// applyUpgrade raises a building to its next level and restores it to the
+// new maximum health
+func applyUpgrade(building *models.Building, catalog *Catalog) {
+	building.Level++
+	building.UpgradeEndAt = nil
+	building.MaxHealth = catalog.MaxHealth(building)
+	building.Health = building.MaxHealth
+	building.IsActive = true
 }
 
 // Request/Response types
@@ -461,6 +933,10 @@ type CreateBuildingRequest struct {
 	Position models.Position     `json:"position" binding:"required"`
 }
 
+type MoveBuildingRequest struct {
+	Position models.Position `json:"position" binding:"required"`
+}
+
 type CreateGuildRequest struct {
 	Name        string `json:"name" binding:"required,min=3,max=50"`
 	Tag         string `json:"tag" binding:"required,min=2,max=5"`
@@ -468,6 +944,18 @@ type CreateGuildRequest struct {
 }
 
 type CollectResult struct {
-	Collected      map[models.ResourceType]int64 `json:"collected"`
+	Collected map[models.ResourceType]int64 `json:"collected"`
+	Upkeep    map[models.ResourceType]int64 `json:"upkeep"`
+	// Production lost because storage was full
+	Overflow map[models.ResourceType]int64 `json:"overflow"`
+	Capacity map[models.ResourceType]int64 `json:"capacity"`
+	// Efficiency percentage applied to production until the next collection
+	Efficiency     float64                       `json:"efficiency"`
+	Population     int                           `json:"population"`
 	TotalResources map[models.ResourceType]int64 `json:"total_resources"`
-}
\ No newline at end of file
+}
+
+type DemolishResult struct {
+	Refund         map[models.ResourceType]int64 `json:"refund"`
+	TotalResources map[models.ResourceType]int64 `json:"total_resources"`
+}
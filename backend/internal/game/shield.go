@@ -0,0 +1,137 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// Shield operations
+
+// GetShield returns the shield of a user's district
+func (s *Service) GetShield(ctx context.Context, userID uuid.UUID) (*Shield, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	until, err := s.repo.GetShield(ctx, district.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shield: %w", err)
+	}
+	return newShield(until, time.Now()), nil
+}
+
+// ActivateShield uses a shield item, adding its duration to the shield of
+// the user's district
+func (s *Service) ActivateShield(ctx context.Context, userID uuid.UUID, req ActivateShieldRequest) (*Shield, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	catalog := s.catalog.Get()
+	item, err := catalog.Item(req.Item)
+	if err != nil {
+		return nil, err
+	}
+	if item.Shield <= 0 {
+		return nil, fmt.Errorf("item %s isn't a shield", req.Item)
+	}
+
+	var shield *Shield
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+
+		current, err := s.repo.GetShieldTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		limit := now.Add(catalog.Shield.MaxDuration)
+		if current != nil && !current.Before(limit) {
+			return fmt.Errorf("your shield already lasts as long as it can")
+		}
+
+		if err := s.repo.ConsumeItemTx(ctx, tx, userID, req.Item, 1); err != nil {
+			return err
+		}
+
+		start := now
+		if current != nil && current.After(now) {
+			start = *current
+		}
+		until, err := s.grantShieldTx(ctx, tx, district.ID, start.Add(item.Shield))
+		if err != nil {
+			return err
+		}
+
+		shield = newShield(until, now)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return shield, nil
+}
+
+// GrantBeginnerShields shields the districts of players still within the
+// catalog's beginner period who never had a shield, such as those who
+// registered before shields existed. It returns how many it shielded.
+func (s *Service) GrantBeginnerShields(ctx context.Context) (int64, error) {
+	granted, err := s.repo.GrantBeginnerShields(ctx, s.catalog.Get().Shield.Beginner)
+	if err != nil {
+		return 0, fmt.Errorf("failed to grant beginner shields: %w", err)
+	}
+	return granted, nil
+}
+
+// grantShieldTx shields a district locked by tx until a time, keeping a
+// longer shield it already has. Shields are capped at the catalog's max
+// duration.
+func (s *Service) grantShieldTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID, until time.Time) (*time.Time, error) {
+	current, err := s.repo.GetShieldTx(ctx, tx, districtID)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && current.After(until) {
+		return current, nil
+	}
+
+	if limit := time.Now().Add(s.catalog.Get().Shield.MaxDuration); until.After(limit) {
+		until = limit
+	}
+	if err := s.repo.SetShieldTx(ctx, tx, districtID, &until); err != nil {
+		return nil, fmt.Errorf("failed to set shield: %w", err)
+	}
+	return &until, nil
+}
+
+func newShield(until *time.Time, now time.Time) *Shield {
+	if !isShielded(until, now) {
+		return &Shield{}
+	}
+	return &Shield{Active: true, Until: until}
+}
+
+func isShielded(until *time.Time, now time.Time) bool {
+	return until != nil && until.After(now)
+}
+
+// Shield protects a district from attacks until it expires or its owner
+// attacks someone
+type Shield struct {
+	Active bool       `json:"active"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+type ActivateShieldRequest struct {
+	Item string `json:"item" binding:"required"`
+}
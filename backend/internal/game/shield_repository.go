@@ -0,0 +1,70 @@
+package game
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// Shield operations
+
+// GetShield returns until when a district is shielded, or nil
+func (r *Repository) GetShield(ctx context.Context, districtID uuid.UUID) (*time.Time, error) {
+	return getShield(ctx, r.db, districtID)
+}
+
+// GetShieldTx reads the shield of a district as part of a transaction
+func (r *Repository) GetShieldTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID) (*time.Time, error) {
+	return getShield(ctx, tx, districtID)
+}
+
+func getShield(ctx context.Context, q sqlx.QueryerContext, districtID uuid.UUID) (*time.Time, error) {
+	var until *time.Time
+	err := q.QueryRowxContext(ctx,
+		`SELECT shield_until FROM districts WHERE id = $1`, districtID).Scan(&until)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("district not found")
+	}
+	return until, err
+}
+
+// SetShieldTx shields a district until a time, or drops its shield when
+// until is nil, as part of a transaction
+func (r *Repository) SetShieldTx(ctx context.Context, tx *sqlx.Tx, districtID uuid.UUID, until *time.Time) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE districts SET shield_until = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`,
+		until, districtID)
+	return err
+}
+
+// GrantBeginnerShields shields the districts of players who registered less
+// than beginner ago until the period ends, unless the district was shielded
+// before. It returns how many districts it shielded.
+func (r *Repository) GrantBeginnerShields(ctx context.Context, beginner time.Duration) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE districts d
+		SET shield_until = u.created_at + $1 * INTERVAL '1 second', updated_at = CURRENT_TIMESTAMP
+		FROM users u
+		WHERE u.id = d.owner_id
+		  AND d.shield_until IS NULL
+		  AND u.created_at + $1 * INTERVAL '1 second' > CURRENT_TIMESTAMP`,
+		beginner.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetUserCreatedAt returns when a user registered
+func (r *Repository) GetUserCreatedAt(ctx context.Context, userID uuid.UUID) (time.Time, error) {
+	var createdAt time.Time
+	err := r.db.QueryRowContext(ctx, `SELECT created_at FROM users WHERE id = $1`, userID).Scan(&createdAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, fmt.Errorf("user not found")
+	}
+	return createdAt, err
+}
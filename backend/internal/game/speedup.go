@@ -0,0 +1,134 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/ton-empire/backend/pkg/logger"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// Speed-up operations
+
+// GetInventory returns the items a user owns
+func (s *Service) GetInventory(ctx context.Context, userID uuid.UUID) (map[string]int, error) {
+	return s.repo.GetInventory(ctx, userID)
+}
+
+// SpeedUpUpgrade cuts the remaining time of a running upgrade. Boosters cut
+// their own duration each; without a booster the upgrade finishes instantly
+// for the catalog resource price. The price is computed from the remaining
+// time under the district lock, so it's paid exactly once.
+func (s *Service) SpeedUpUpgrade(ctx context.Context, userID uuid.UUID, buildingID uuid.UUID, req SpeedUpRequest) (*SpeedUpResult, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+
+	catalog := s.catalog.Get()
+	result := &SpeedUpResult{
+		Cost: map[models.ResourceType]int64{},
+	}
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+
+		buildings, err := s.repo.GetBuildingsByDistrictTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		building := findBuilding(buildings, buildingID)
+		if building == nil {
+			return fmt.Errorf("building not found")
+		}
+		if !isUpgrading(building) {
+			return fmt.Errorf("building is not upgrading")
+		}
+
+		now := time.Now()
+		remaining := building.UpgradeEndAt.Sub(now)
+		if remaining <= 0 {
+			return fmt.Errorf("upgrade is already finished")
+		}
+
+		upgradeEndAt := now
+		if req.Item != "" {
+			item, err := catalog.Item(req.Item)
+			if err != nil {
+				return err
+			}
+			if item.SpeedUp <= 0 {
+				return fmt.Errorf("item %s can't speed up upgrades", req.Item)
+			}
+
+			// Don't burn more boosters than the upgrade needs
+			quantity := req.Quantity
+			if quantity < 1 {
+				quantity = 1
+			}
+			if needed := int((remaining + item.SpeedUp - 1) / item.SpeedUp); quantity > needed {
+				quantity = needed
+			}
+
+			if err := s.repo.ConsumeItemTx(ctx, tx, userID, req.Item, quantity); err != nil {
+				return err
+			}
+
+			if cut := item.SpeedUp * time.Duration(quantity); cut < remaining {
+				upgradeEndAt = building.UpgradeEndAt.Add(-cut)
+			}
+			result.ItemsUsed = quantity
+		} else {
+			cost := map[models.ResourceType]int64{
+				catalog.SpeedUp.Resource: catalog.SpeedUpCost(remaining),
+			}
+
+			if _, err := s.spendResourcesTx(ctx, tx, district.ID, cost, LedgerReasonSpeedUp, buildingSource(building.ID)); err != nil {
+				return err
+			}
+			result.Cost = cost
+		}
+
+		building.UpgradeEndAt = &upgradeEndAt
+		if err := s.repo.UpdateBuildingTx(ctx, tx, building); err != nil {
+			return fmt.Errorf("failed to update building: %w", err)
+		}
+
+		result.Building = building
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !result.Building.UpgradeEndAt.After(time.Now()) {
+		// Finished upgrades are completed right away instead of on the next
+		// scheduler tick
+		if _, err := s.completeUpgrades(ctx, district.ID, upgradeBatchSize); err != nil {
+			logger.Errorf("Failed to complete upgrades for district %s: %v", district.ID, err)
+		}
+		if building, err := s.repo.GetBuildingByID(ctx, buildingID); err == nil {
+			result.Building = building
+		}
+	} else {
+		s.notifyBuildingUpdate(ctx, userID, result.Building)
+	}
+
+	return result, nil
+}
+
+type SpeedUpRequest struct {
+	// Booster item to use; empty finishes the upgrade for resources
+	Item     string `json:"item"`
+	Quantity int    `json:"quantity"`
+}
+
+type SpeedUpResult struct {
+	Building  *models.Building              `json:"building"`
+	Cost      map[models.ResourceType]int64 `json:"cost"`
+	ItemsUsed int                           `json:"items_used"`
+}
@@ -0,0 +1,46 @@
+package game
+
+import (
+	"math"
+
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// UpkeepResult is the outcome of settling a district's upkeep
+type UpkeepResult struct {
+	Consumed map[models.ResourceType]int64
+	// Efficiency percentage the district runs at until upkeep is settled again
+	Efficiency float64
+}
+
+// settleUpkeep charges hours of upkeep at the given hourly rates against the
+// stock plus what was produced in the meantime. When a resource runs out, the
+// district's efficiency drops to the share of its upkeep that could be paid,
+// but not below minEfficiency. A district that pays in full runs at 100%.
+func settleUpkeep(stock, produced, rates map[models.ResourceType]int64, hours, minEfficiency float64) *UpkeepResult {
+	result := &UpkeepResult{
+		Consumed:   make(map[models.ResourceType]int64, len(rates)),
+		Efficiency: 100,
+	}
+	if hours <= 0 {
+		return result
+	}
+
+	coverage := 1.0
+	for resourceType, rate := range rates {
+		needed := int64(float64(rate) * hours)
+		if needed <= 0 {
+			continue
+		}
+
+		available := stock[resourceType] + produced[resourceType]
+		if needed > available {
+			coverage = math.Min(coverage, float64(max(available, 0))/float64(needed))
+			needed = max(available, 0)
+		}
+		result.Consumed[resourceType] = needed
+	}
+
+	result.Efficiency = math.Max(math.Round(coverage*10000)/100, minEfficiency)
+	return result
+}
@@ -0,0 +1,522 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/ton-empire/backend/internal/common/events"
+	"github.com/ton-empire/backend/pkg/logger"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// Wave operations
+
+// GetCityWave returns the wave coming for a city, or the last one, with the
+// reinforcements sent against it and the defense the city has right now
+func (s *Service) GetCityWave(ctx context.Context, cityID uuid.UUID) (*CityWave, error) {
+	city, err := s.repo.GetCity(ctx, cityID)
+	if err != nil {
+		return nil, err
+	}
+
+	cityWave, _, err := s.getCityWave(ctx, city)
+	return cityWave, err
+}
+
+// SendReinforcements sends troops from a user's district to help a city of
+// their guild against the wave coming for it. Surviving troops return home
+// once the wave landed.
+func (s *Service) SendReinforcements(ctx context.Context, userID, cityID uuid.UUID, req ReinforceRequest) (*CityWave, error) {
+	district, err := s.repo.GetDistrictByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("district not found: %w", err)
+	}
+	guildID, err := s.repo.GetUserGuildID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get guild: %w", err)
+	}
+
+	catalog := s.catalog.Get()
+	for troopType, count := range req.Troops {
+		if _, err := catalog.Troop(troopType); err != nil {
+			return nil, err
+		}
+		if count < 1 {
+			return nil, fmt.Errorf("send at least one %s", troopType)
+		}
+	}
+
+	err = s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		wave, err := s.repo.LockPendingWaveTx(ctx, tx, cityID)
+		if err != nil {
+			return err
+		}
+		if wave == nil || !time.Now().Before(wave.LandsAt) {
+			return fmt.Errorf("no wave is coming for this city")
+		}
+
+		city, err := s.repo.GetCityTx(ctx, tx, cityID)
+		if err != nil {
+			return err
+		}
+		if city.GuildID == nil || guildID == nil || *city.GuildID != *guildID {
+			return fmt.Errorf("you can only reinforce cities of your guild")
+		}
+
+		if err := s.repo.LockDistrictTx(ctx, tx, district.ID); err != nil {
+			return err
+		}
+		current, err := s.repo.GetDistrictByIDTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		if current.CityID == cityID {
+			return fmt.Errorf("your troops already defend this city")
+		}
+
+		troops, err := s.repo.GetTroopsTx(ctx, tx, district.ID)
+		if err != nil {
+			return err
+		}
+		for troopType, count := range req.Troops {
+			if troops[troopType] < count {
+				return fmt.Errorf("you have only %d %s", troops[troopType], catalog.Troops[troopType].Name)
+			}
+		}
+
+		if err := s.repo.RemoveTroopsTx(ctx, tx, district.ID, req.Troops); err != nil {
+			return fmt.Errorf("failed to update troops: %w", err)
+		}
+		if err := s.repo.AddReinforcementsTx(ctx, tx, wave.ID, district.ID, req.Troops); err != nil {
+			return fmt.Errorf("failed to send reinforcements: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetCityWave(ctx, cityID)
+}
+
+// ScheduleWaves announces a wave for up to limit inhabited cities whose
+// last wave is an interval away from the next landing, and returns how many
+// were announced
+func (s *Service) ScheduleWaves(ctx context.Context, limit int) (int, error) {
+	catalog := s.catalog.Get()
+	var announced []*Wave
+	var cities []*City
+
+	err := s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		now := time.Now()
+		due, err := s.repo.LockCitiesDueWave(ctx, tx, now.Add(-(catalog.Waves.Interval - catalog.Waves.Warning)), limit)
+		if err != nil {
+			return err
+		}
+
+		for _, city := range due {
+			wave := &Wave{
+				ID:          uuid.New(),
+				CityID:      city.ID,
+				Power:       catalog.Waves.BasePower + catalog.Waves.DistrictPower*float64(city.Districts),
+				AnnouncedAt: now,
+				LandsAt:     now.Add(catalog.Waves.Warning),
+				Reward:      map[models.ResourceType]int64{},
+			}
+			if err := s.repo.CreateWaveTx(ctx, tx, wave); err != nil {
+				return fmt.Errorf("failed to announce wave for city %s: %w", city.ID, err)
+			}
+			announced = append(announced, wave)
+		}
+
+		cities = due
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, wave := range announced {
+		s.notifyWaveIncoming(ctx, cities[i], wave)
+	}
+
+	return len(announced), nil
+}
+
+// ResolveDueWaves resolves up to limit waves that have landed and returns
+// how many were resolved. Every wave is resolved in a transaction of its
+// own, as it locks all districts of its city.
+func (s *Service) ResolveDueWaves(ctx context.Context, limit int) (int, error) {
+	resolved := 0
+	for resolved < limit {
+		result, err := s.resolveWave(ctx)
+		if err != nil {
+			return resolved, err
+		}
+		if result == nil {
+			break
+		}
+		resolved++
+
+		logger.Infof("Wave %s on city %s resolved, held: %t", result.report.Wave.ID, result.city.ID, *result.report.Wave.Held)
+		s.notifyWaveResult(ctx, result)
+	}
+	return resolved, nil
+}
+
+func (s *Service) resolveWave(ctx context.Context) (*resolvedWave, error) {
+	var result *resolvedWave
+	catalog := s.catalog.Get()
+
+	err := s.repo.Transaction(ctx, func(tx *sqlx.Tx) error {
+		now := time.Now()
+		wave, err := s.repo.LockDueWave(ctx, tx, now)
+		if err != nil || wave == nil {
+			return err
+		}
+
+		city, err := s.repo.GetCityTx(ctx, tx, wave.CityID)
+		if err != nil {
+			return err
+		}
+		reinforcements, err := s.repo.GetReinforcementsTx(ctx, tx, wave.ID)
+		if err != nil {
+			return err
+		}
+
+		// Lock every district taking part, then read the defense of the
+		// city's districts again now that it can't change
+		defenders, err := s.repo.GetCityDefendersTx(ctx, tx, city.ID, catalog.Battle.Defense, catalog.troopWeights())
+		if err != nil {
+			return err
+		}
+		districtIDs := make([]uuid.UUID, 0, len(defenders)+len(reinforcements))
+		for _, defender := range defenders {
+			districtIDs = append(districtIDs, defender.DistrictID)
+		}
+		for _, reinforcement := range reinforcements {
+			districtIDs = append(districtIDs, reinforcement.DistrictID)
+		}
+		if err := s.lockDistrictsTx(ctx, tx, districtIDs...); err != nil {
+			return err
+		}
+		if defenders, err = s.repo.GetCityDefendersTx(ctx, tx, city.ID, catalog.Battle.Defense, catalog.troopWeights()); err != nil {
+			return err
+		}
+		residents := len(defenders)
+
+		for _, reinforcement := range reinforcements {
+			attack, _ := catalog.BattlePower(nil, reinforcement.Troops)
+			defenders = append(defenders, &WaveDefender{
+				DistrictID:  reinforcement.DistrictID,
+				OwnerID:     reinforcement.OwnerID,
+				Defense:     attack,
+				Reinforcing: true,
+			})
+		}
+
+		defense := city.Defense
+		for _, defender := range defenders {
+			defense += defender.Defense
+		}
+		held := defense >= wave.Power
+		wave.Defense = &defense
+		wave.Held = &held
+		wave.Reward = map[models.ResourceType]int64{}
+		wave.ResolvedAt = &now
+
+		// Troops fall in proportion to how strong the wave was against the
+		// defense; reinforcements that survive go home
+		losses := catalog.Waves.TroopLosses
+		if defense > 0 {
+			losses *= min(wave.Power/defense, 1)
+		}
+		for _, defender := range defenders[:residents] {
+			troops, err := s.repo.GetTroopsTx(ctx, tx, defender.DistrictID)
+			if err != nil {
+				return err
+			}
+			survivors, lost := troopCasualties(troops, losses)
+			if err := s.repo.RemoveTroopsTx(ctx, tx, defender.DistrictID, fallenTroops(troops, survivors)); err != nil {
+				return fmt.Errorf("failed to update troops: %w", err)
+			}
+			defender.TroopsLost = lost
+		}
+		for i, reinforcement := range reinforcements {
+			survivors, lost := troopCasualties(reinforcement.Troops, losses)
+			for troopType, count := range survivors {
+				if count == 0 {
+					continue
+				}
+				if err := s.repo.AddTroopsTx(ctx, tx, reinforcement.DistrictID, troopType, count); err != nil {
+					return fmt.Errorf("failed to return reinforcements: %w", err)
+				}
+			}
+			defenders[residents+i].TroopsLost = lost
+		}
+
+		result = &resolvedWave{
+			report:  &WaveReport{Wave: wave, Defenders: defenders},
+			city:    city,
+			damaged: map[uuid.UUID][]*models.Building{},
+		}
+		if held {
+			err = s.shareWaveRewardTx(ctx, tx, wave, defenders, residents)
+		} else {
+			err = s.waveDamageTx(ctx, tx, result, defenders[:residents])
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := s.repo.ResolveWaveTx(ctx, tx, wave); err != nil {
+			return fmt.Errorf("failed to resolve wave %s: %w", wave.ID, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// shareWaveRewardTx shares the reward of a held wave among its defenders by
+// the defense each brought, as much as their storage allows
+func (s *Service) shareWaveRewardTx(ctx context.Context, tx *sqlx.Tx, wave *Wave, defenders []*WaveDefender, residents int) error {
+	catalog := s.catalog.Get()
+
+	total := 0.0
+	for _, defender := range defenders {
+		total += defender.Defense
+	}
+	if total <= 0 {
+		return nil
+	}
+
+	source := LedgerSource{Type: LedgerSourceWave, ID: wave.ID}
+	for _, defender := range defenders {
+		if defender.Defense <= 0 {
+			continue
+		}
+
+		buildings, err := s.repo.GetBuildingsByDistrictTx(ctx, tx, defender.DistrictID)
+		if err != nil {
+			return err
+		}
+		resources, err := s.repo.GetDistrictResourcesTx(ctx, tx, defender.DistrictID)
+		if err != nil {
+			return err
+		}
+		capacity := catalog.StorageCapacity(buildings)
+
+		reward := map[models.ResourceType]int64{}
+		for resourceType, amount := range catalog.Waves.Reward {
+			share := int64(math.Floor(float64(amount) * float64(residents) * defender.Defense / total))
+			share = min(share, max(capacity[resourceType]-resources[resourceType], 0))
+			if share > 0 {
+				reward[resourceType] = share
+				wave.Reward[resourceType] += share
+			}
+		}
+		if _, err := s.changeResourcesTx(ctx, tx, defender.DistrictID, reward, LedgerReasonWaveReward, source); err != nil {
+			return err
+		}
+		defender.Reward = reward
+	}
+
+	return nil
+}
+
+// waveDamageTx damages every standing building of the districts of a city
+// that fell to a wave
+func (s *Service) waveDamageTx(ctx context.Context, tx *sqlx.Tx, result *resolvedWave, residents []*WaveDefender) error {
+	catalog := s.catalog.Get()
+
+	for _, defender := range residents {
+		buildings, err := s.repo.GetBuildingsByDistrictTx(ctx, tx, defender.DistrictID)
+		if err != nil {
+			return err
+		}
+		for _, building := range buildings {
+			if building.Health <= 0 {
+				continue
+			}
+			setHealth(building, building.Health-building.MaxHealth*catalog.Waves.Damage, catalog.Repair.InactiveBelow)
+			if err := s.repo.UpdateBuildingTx(ctx, tx, building); err != nil {
+				return fmt.Errorf("failed to update building: %w", err)
+			}
+			result.report.Wave.BuildingsDamaged++
+			result.damaged[defender.OwnerID] = append(result.damaged[defender.OwnerID], building)
+		}
+	}
+
+	return nil
+}
+
+// getCityWave returns the wave of a city and the districts defending it
+func (s *Service) getCityWave(ctx context.Context, city *City) (*CityWave, []*WaveDefender, error) {
+	catalog := s.catalog.Get()
+	defenders, err := s.repo.GetCityDefenders(ctx, city.ID, catalog.Battle.Defense, catalog.troopWeights())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get city defense: %w", err)
+	}
+	wave, err := s.repo.GetLatestWave(ctx, city.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get wave: %w", err)
+	}
+
+	cityWave := &CityWave{
+		CityID:         city.ID,
+		Wave:           wave,
+		Defense:        city.Defense,
+		Reinforcements: []*Reinforcement{},
+	}
+	for _, defender := range defenders {
+		cityWave.Defense += defender.Defense
+	}
+	if wave != nil && wave.ResolvedAt == nil {
+		reinforcements, err := s.repo.GetReinforcements(ctx, wave.ID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get reinforcements: %w", err)
+		}
+		for _, reinforcement := range reinforcements {
+			attack, _ := catalog.BattlePower(nil, reinforcement.Troops)
+			cityWave.Defense += attack
+			cityWave.Reinforcements = append(cityWave.Reinforcements, reinforcement)
+		}
+	}
+
+	return cityWave, defenders, nil
+}
+
+// notifyWaveIncoming warns the players of a city, and the guild of a guild
+// city, that a wave is coming
+func (s *Service) notifyWaveIncoming(ctx context.Context, city *City, wave *Wave) {
+	cityWave, defenders, err := s.getCityWave(ctx, city)
+	if err != nil {
+		logger.Errorf("Failed to get wave of city %s: %v", city.ID, err)
+		return
+	}
+
+	for _, defender := range defenders {
+		if err := s.events.PublishToUser(ctx, defender.OwnerID, EventWaveIncoming, cityWave); err != nil {
+			logger.Errorf("Failed to publish wave %s: %v", wave.ID, err)
+		}
+	}
+	if city.GuildID != nil {
+		if err := s.events.PublishToRoom(ctx, events.GuildRoomPrefix+city.GuildID.String(), EventWaveIncoming, cityWave); err != nil {
+			logger.Errorf("Failed to publish wave %s: %v", wave.ID, err)
+		}
+	}
+}
+
+// notifyWaveResult tells everyone who defended against a wave how it went
+func (s *Service) notifyWaveResult(ctx context.Context, result *resolvedWave) {
+	notified := map[uuid.UUID]bool{}
+	for _, defender := range result.report.Defenders {
+		if notified[defender.OwnerID] {
+			continue
+		}
+		notified[defender.OwnerID] = true
+		if err := s.events.PublishToUser(ctx, defender.OwnerID, EventWaveResult, result.report); err != nil {
+			logger.Errorf("Failed to publish result of wave %s: %v", result.report.Wave.ID, err)
+		}
+	}
+	if result.city.GuildID != nil {
+		if err := s.events.PublishToRoom(ctx, events.GuildRoomPrefix+result.city.GuildID.String(), EventWaveResult, result.report); err != nil {
+			logger.Errorf("Failed to publish result of wave %s: %v", result.report.Wave.ID, err)
+		}
+	}
+
+	for ownerID, buildings := range result.damaged {
+		for _, building := range buildings {
+			s.notifyBuildingUpdate(ctx, ownerID, building)
+		}
+	}
+}
+
+// troopCasualties returns the troops left after losing a share of each
+// type, and how many fell
+func troopCasualties(troops map[string]int, share float64) (map[string]int, int) {
+	survivors := make(map[string]int, len(troops))
+	lost := 0
+	for troopType, count := range troops {
+		fallen := int(math.Round(float64(count) * share))
+		survivors[troopType] = count - fallen
+		lost += fallen
+	}
+	return survivors, lost
+}
+
+// fallenTroops returns how many troops of each type fell between two counts
+// of an army
+func fallenTroops(before, after map[string]int) map[string]int {
+	fallen := make(map[string]int, len(before))
+	for troopType, count := range before {
+		fallen[troopType] = count - after[troopType]
+	}
+	return fallen
+}
+
+type resolvedWave struct {
+	report *WaveReport
+	city   *City
+	// Buildings the wave damaged, by owner
+	damaged map[uuid.UUID][]*models.Building
+}
+
+// Wave is an NPC attack on a city. Its outcome is set once it landed.
+type Wave struct {
+	ID               uuid.UUID                     `json:"id"`
+	CityID           uuid.UUID                     `json:"city_id"`
+	Power            float64                       `json:"power"`
+	AnnouncedAt      time.Time                     `json:"announced_at"`
+	LandsAt          time.Time                     `json:"lands_at"`
+	Defense          *float64                      `json:"defense,omitempty"`
+	Held             *bool                         `json:"held,omitempty"`
+	BuildingsDamaged int                           `json:"buildings_damaged"`
+	Reward           map[models.ResourceType]int64 `json:"reward"`
+	ResolvedAt       *time.Time                    `json:"resolved_at,omitempty"`
+}
+
+// Reinforcement is the troops a district sent to help a city against a wave
+type Reinforcement struct {
+	DistrictID uuid.UUID      `json:"district_id"`
+	OwnerID    uuid.UUID      `json:"owner_id"`
+	Troops     map[string]int `json:"troops"`
+}
+
+// CityWave is the wave threatening a city, or the last one, with the
+// city's defense against it
+type CityWave struct {
+	CityID         uuid.UUID        `json:"city_id"`
+	Wave           *Wave            `json:"wave"`
+	Defense        float64          `json:"defense"`
+	Reinforcements []*Reinforcement `json:"reinforcements"`
+}
+
+// WaveDefender is a district that defended a city against a wave, living
+// there or reinforcing it
+type WaveDefender struct {
+	DistrictID  uuid.UUID                     `json:"district_id"`
+	OwnerID     uuid.UUID                     `json:"owner_id"`
+	Defense     float64                       `json:"defense"`
+	Reinforcing bool                          `json:"reinforcing"`
+	TroopsLost  int                           `json:"troops_lost"`
+	Reward      map[models.ResourceType]int64 `json:"reward,omitempty"`
+}
+
+// WaveReport is the outcome of a wave for everyone who defended against it
+type WaveReport struct {
+	Wave      *Wave           `json:"wave"`
+	Defenders []*WaveDefender `json:"defenders"`
+}
+
+type ReinforceRequest struct {
+	Troops map[string]int `json:"troops" binding:"required,min=1"`
+}
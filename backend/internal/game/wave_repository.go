@@ -0,0 +1,245 @@
+package game
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// Wave operations
+
+const waveColumns = `
+	id, city_id, power, announced_at, lands_at, defense, held,
+	buildings_damaged, reward, resolved_at`
+
+// LockCitiesDueWave returns inhabited cities without a pending wave whose
+// last wave landed before lastLanded, locked until tx ends. Rows locked by
+// another game-service replica are skipped.
+func (r *Repository) LockCitiesDueWave(ctx context.Context, tx *sqlx.Tx, lastLanded time.Time, limit int) ([]*City, error) {
+	query := `
+		SELECT ` + cityColumns + `
+		FROM cities c
+		WHERE EXISTS (SELECT 1 FROM districts d WHERE d.city_id = c.id)
+		  AND NOT EXISTS (
+		      SELECT 1 FROM waves w
+		      WHERE w.city_id = c.id AND (w.resolved_at IS NULL OR w.lands_at > $1)
+		  )
+		ORDER BY c.created_at, c.id
+		LIMIT $2
+		FOR UPDATE OF c SKIP LOCKED`
+
+	var cities []*City
+	if err := tx.SelectContext(ctx, &cities, query, lastLanded, limit); err != nil {
+		return nil, err
+	}
+	return cities, nil
+}
+
+// CreateWaveTx stores an announced wave as part of a transaction
+func (r *Repository) CreateWaveTx(ctx context.Context, tx *sqlx.Tx, wave *Wave) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO waves (id, city_id, power, announced_at, lands_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		wave.ID, wave.CityID, wave.Power, wave.AnnouncedAt, wave.LandsAt)
+	return err
+}
+
+// GetLatestWave returns the pending or last wave of a city, or nil
+func (r *Repository) GetLatestWave(ctx context.Context, cityID uuid.UUID) (*Wave, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT `+waveColumns+`
+		FROM waves
+		WHERE city_id = $1
+		ORDER BY lands_at DESC
+		LIMIT 1`,
+		cityID)
+	wave, err := scanWave(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return wave, err
+}
+
+// LockPendingWaveTx returns the wave coming for a city, locked until tx
+// ends, or nil. Waves are locked before districts.
+func (r *Repository) LockPendingWaveTx(ctx context.Context, tx *sqlx.Tx, cityID uuid.UUID) (*Wave, error) {
+	row := tx.QueryRowContext(ctx, `
+		SELECT `+waveColumns+`
+		FROM waves
+		WHERE city_id = $1 AND resolved_at IS NULL
+		FOR UPDATE`,
+		cityID)
+	wave, err := scanWave(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return wave, err
+}
+
+// LockDueWave returns a wave that landed before now but hasn't been
+// resolved, locked until tx ends, or nil. Rows locked by another
+// game-service replica are skipped, so every wave is resolved exactly once.
+func (r *Repository) LockDueWave(ctx context.Context, tx *sqlx.Tx, now time.Time) (*Wave, error) {
+	row := tx.QueryRowContext(ctx, `
+		SELECT `+waveColumns+`
+		FROM waves
+		WHERE resolved_at IS NULL AND lands_at <= $1
+		ORDER BY lands_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED`,
+		now)
+	wave, err := scanWave(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return wave, err
+}
+
+// ResolveWaveTx stores the outcome of a landed wave as part of a transaction
+func (r *Repository) ResolveWaveTx(ctx context.Context, tx *sqlx.Tx, wave *Wave) error {
+	reward, err := json.Marshal(wave.Reward)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE waves
+		SET defense = $1, held = $2, buildings_damaged = $3, reward = $4, resolved_at = $5
+		WHERE id = $6`,
+		wave.Defense, wave.Held, wave.BuildingsDamaged, reward, wave.ResolvedAt, wave.ID)
+	return err
+}
+
+// GetCityDefenders returns the districts of a city with their defense: the
+// level of each active building weighted by weights plus its troops
+// weighted by troopWeights
+func (r *Repository) GetCityDefenders(ctx context.Context, cityID uuid.UUID, weights map[models.BuildingType]float64, troopWeights map[string]float64) ([]*WaveDefender, error) {
+	return getCityDefenders(ctx, r.db, cityID, weights, troopWeights)
+}
+
+// GetCityDefendersTx reads the defenders of a city as part of a transaction
+func (r *Repository) GetCityDefendersTx(ctx context.Context, tx *sqlx.Tx, cityID uuid.UUID, weights map[models.BuildingType]float64, troopWeights map[string]float64) ([]*WaveDefender, error) {
+	return getCityDefenders(ctx, tx, cityID, weights, troopWeights)
+}
+
+func getCityDefenders(ctx context.Context, q sqlx.QueryerContext, cityID uuid.UUID, weights map[models.BuildingType]float64, troopWeights map[string]float64) ([]*WaveDefender, error) {
+	types, values := powerWeights(weights, troopWeights)
+	rows, err := q.QueryContext(ctx, `
+		WITH weights (type, weight) AS (
+		    SELECT * FROM unnest($1::text[], $2::float8[])
+		)
+		SELECT d.id, d.owner_id,
+		       COALESCE((SELECT SUM(b.level * w.weight)
+		                 FROM buildings b
+		                 JOIN weights w ON w.type = 'building:' || b.type
+		                 WHERE b.district_id = d.id AND b.is_active), 0)
+		     + COALESCE((SELECT SUM(t.count * w.weight)
+		                 FROM district_troops t
+		                 JOIN weights w ON w.type = 'troop:' || t.troop_type
+		                 WHERE t.district_id = d.id), 0)
+		FROM districts d
+		WHERE d.city_id = $3
+		ORDER BY d.id`,
+		pq.Array(types), pq.Array(values), cityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var defenders []*WaveDefender
+	for rows.Next() {
+		var defender WaveDefender
+		if err := rows.Scan(&defender.DistrictID, &defender.OwnerID, &defender.Defense); err != nil {
+			return nil, err
+		}
+		defenders = append(defenders, &defender)
+	}
+
+	return defenders, rows.Err()
+}
+
+// GetReinforcements returns the troops sent to help against a wave, by
+// sending district
+func (r *Repository) GetReinforcements(ctx context.Context, waveID uuid.UUID) ([]*Reinforcement, error) {
+	return getReinforcements(ctx, r.db, waveID)
+}
+
+// GetReinforcementsTx reads the reinforcements of a wave as part of a
+// transaction
+func (r *Repository) GetReinforcementsTx(ctx context.Context, tx *sqlx.Tx, waveID uuid.UUID) ([]*Reinforcement, error) {
+	return getReinforcements(ctx, tx, waveID)
+}
+
+func getReinforcements(ctx context.Context, q sqlx.QueryerContext, waveID uuid.UUID) ([]*Reinforcement, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT r.district_id, d.owner_id, r.troop_type, r.count
+		FROM wave_reinforcements r
+		JOIN districts d ON d.id = r.district_id
+		WHERE r.wave_id = $1
+		ORDER BY r.district_id, r.troop_type`,
+		waveID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reinforcements []*Reinforcement
+	for rows.Next() {
+		var districtID, ownerID uuid.UUID
+		var troopType string
+		var count int
+		if err := rows.Scan(&districtID, &ownerID, &troopType, &count); err != nil {
+			return nil, err
+		}
+
+		// Rows come sorted by district, so a district's troops are adjacent
+		if n := len(reinforcements); n == 0 || reinforcements[n-1].DistrictID != districtID {
+			reinforcements = append(reinforcements, &Reinforcement{
+				DistrictID: districtID,
+				OwnerID:    ownerID,
+				Troops:     make(map[string]int),
+			})
+		}
+		reinforcements[len(reinforcements)-1].Troops[troopType] = count
+	}
+
+	return reinforcements, rows.Err()
+}
+
+// AddReinforcementsTx records troops a district sent to help against a wave
+// as part of a transaction
+func (r *Repository) AddReinforcementsTx(ctx context.Context, tx *sqlx.Tx, waveID, districtID uuid.UUID, troops map[string]int) error {
+	for troopType, count := range troops {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO wave_reinforcements (wave_id, district_id, troop_type, count)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (wave_id, district_id, troop_type) DO UPDATE
+			SET count = wave_reinforcements.count + EXCLUDED.count`,
+			waveID, districtID, troopType, count)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanWave(row interface{ Scan(...interface{}) error }) (*Wave, error) {
+	var wave Wave
+	var reward []byte
+	err := row.Scan(&wave.ID, &wave.CityID, &wave.Power, &wave.AnnouncedAt, &wave.LandsAt,
+		&wave.Defense, &wave.Held, &wave.BuildingsDamaged, &reward, &wave.ResolvedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(reward, &wave.Reward); err != nil {
+		return nil, fmt.Errorf("failed to decode wave reward: %w", err)
+	}
+	return &wave, nil
+}
@@ -0,0 +1,229 @@
+package game
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/ton-empire/backend/internal/common/events"
+	"github.com/ton-empire/backend/pkg/logger"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// World map operations
+
+// GetWorld returns the cities and points of interest inside a viewport of the
+// world map, along with the region rooms to join for live updates of it
+func (s *Service) GetWorld(ctx context.Context, viewport WorldViewport) (*WorldView, error) {
+	catalog := s.catalog.Get()
+	world := catalog.World
+	if viewport.W < 1 || viewport.H < 1 || viewport.W > world.MaxViewport || viewport.H > world.MaxViewport {
+		return nil, fmt.Errorf("viewport width and height must be between 1 and %d", world.MaxViewport)
+	}
+
+	// Clip the viewport to the world bounds
+	minX, minY := max(viewport.X, 0), max(viewport.Y, 0)
+	maxX, maxY := min(viewport.X+viewport.W, world.Width)-1, min(viewport.Y+viewport.H, world.Height)-1
+	if minX > maxX || minY > maxY {
+		return nil, fmt.Errorf("viewport is outside the world")
+	}
+
+	cities, err := s.repo.GetCitiesInArea(ctx, minX, minY, maxX, maxY)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cities: %w", err)
+	}
+
+	taken := make(map[models.Position]bool, len(cities))
+	for _, city := range cities {
+		city.Slots = catalog.CitySlots(city)
+		taken[city.Position] = true
+	}
+	if cities == nil {
+		cities = []*City{}
+	}
+
+	pois := []*PointOfInterest{}
+	for x := minX; x <= maxX; x++ {
+		for y := minY; y <= maxY; y++ {
+			position := models.Position{X: x, Y: y}
+			if taken[position] {
+				continue
+			}
+			if poi := catalog.poiAt(position); poi != nil {
+				pois = append(pois, poi)
+			}
+		}
+	}
+
+	return &WorldView{
+		X:                minX,
+		Y:                minY,
+		W:                maxX - minX + 1,
+		H:                maxY - minY + 1,
+		Cities:           cities,
+		PointsOfInterest: pois,
+		Regions:          catalog.regionRooms(minX, minY, maxX, maxY),
+	}, nil
+}
+
+// placeCityTx picks the free spot of the city grid closest to the center of
+// the world. Cities off the grid, such as those placed with another
+// city_spacing, take the spot nearest to them. Placement is serialized until
+// tx ends, so the spot stays free for the city created in it.
+func (s *Service) placeCityTx(ctx context.Context, tx *sqlx.Tx) (models.Position, error) {
+	if err := s.repo.LockWorldTx(ctx, tx); err != nil {
+		return models.Position{}, fmt.Errorf("failed to lock world map: %w", err)
+	}
+	taken, err := s.repo.GetCityPositionsTx(ctx, tx)
+	if err != nil {
+		return models.Position{}, fmt.Errorf("failed to get city positions: %w", err)
+	}
+
+	catalog := s.catalog.Get()
+	world := catalog.World
+	spacing := world.CitySpacing
+	centerX := world.Width / 2 / spacing * spacing
+	centerY := world.Height / 2 / spacing * spacing
+
+	occupied := make(map[models.Position]bool, len(taken))
+	for position := range taken {
+		occupied[models.Position{
+			X: snapToGrid(position.X, centerX, spacing),
+			Y: snapToGrid(position.Y, centerY, spacing),
+		}] = true
+	}
+
+	// Walk square rings around the center, nearest first
+	rings := max(world.Width, world.Height)/spacing + 1
+	for ring := 0; ring <= rings; ring++ {
+		for dx := -ring; dx <= ring; dx++ {
+			for dy := -ring; dy <= ring; dy++ {
+				if max(abs(dx), abs(dy)) != ring {
+					continue
+				}
+
+				position := models.Position{X: centerX + dx*spacing, Y: centerY + dy*spacing}
+				if position.X < 0 || position.Y < 0 || position.X >= world.Width || position.Y >= world.Height {
+					continue
+				}
+				if occupied[position] || catalog.poiAt(position) != nil {
+					continue
+				}
+				return position, nil
+			}
+		}
+	}
+
+	return models.Position{}, fmt.Errorf("world map is full")
+}
+
+// snapToGrid returns the coordinate of the grid line through origin every
+// spacing cells that is nearest to v
+func snapToGrid(v, origin, spacing int) int {
+	return origin + int(math.Round(float64(v-origin)/float64(spacing)))*spacing
+}
+
+// notifyCityUpdate sends the current state of cities to the clients watching
+// their regions of the world map
+func (s *Service) notifyCityUpdate(ctx context.Context, cityIDs ...uuid.UUID) {
+	catalog := s.catalog.Get()
+	for _, cityID := range cityIDs {
+		city, err := s.repo.GetCity(ctx, cityID)
+		if err != nil {
+			logger.Errorf("Failed to get city %s for update: %v", cityID, err)
+			continue
+		}
+		city.Slots = catalog.CitySlots(city)
+
+		if err := s.events.PublishToRoom(ctx, catalog.regionRoom(city.Position), EventCityUpdate, city); err != nil {
+			logger.Errorf("Failed to publish city update for %s: %v", cityID, err)
+		}
+	}
+}
+
+// poiAt returns the point of interest on a world map cell, or nil. Points of
+// interest are derived from the cell, so they never have to be stored.
+func (c *Catalog) poiAt(position models.Position) *PointOfInterest {
+	if len(c.World.PointsOfInterest) == 0 {
+		return nil
+	}
+
+	h := fnv.New64a()
+	binary.Write(h, binary.BigEndian, int32(position.X))
+	binary.Write(h, binary.BigEndian, int32(position.Y))
+	sum := h.Sum64()
+	roll := float64(sum%1000000) / 1000000
+
+	poiTypes := make([]string, 0, len(c.World.PointsOfInterest))
+	for poiType := range c.World.PointsOfInterest {
+		poiTypes = append(poiTypes, poiType)
+	}
+	sort.Strings(poiTypes)
+
+	for _, poiType := range poiTypes {
+		if roll < c.World.PointsOfInterest[poiType] {
+			return &PointOfInterest{
+				Type:     poiType,
+				Level:    int(sum/1000000%uint64(c.World.MaxPOILevel)) + 1,
+				Position: position,
+			}
+		}
+		roll -= c.World.PointsOfInterest[poiType]
+	}
+	return nil
+}
+
+// regionRoom returns the WebSocket room of the world map region a position
+// is in
+func (c *Catalog) regionRoom(position models.Position) string {
+	return fmt.Sprintf("%s%d:%d", events.RegionRoomPrefix, position.X/c.World.RegionSize, position.Y/c.World.RegionSize)
+}
+
+// regionRooms returns the rooms of every region overlapping a rectangle
+func (c *Catalog) regionRooms(minX, minY, maxX, maxY int) []string {
+	size := c.World.RegionSize
+	var rooms []string
+	for x := minX / size; x <= maxX/size; x++ {
+		for y := minY / size; y <= maxY/size; y++ {
+			rooms = append(rooms, c.regionRoom(models.Position{X: x * size, Y: y * size}))
+		}
+	}
+	return rooms
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// PointOfInterest is an NPC site on the world map
+type PointOfInterest struct {
+	Type     string          `json:"type"`
+	Level    int             `json:"level"`
+	Position models.Position `json:"position"`
+}
+
+type WorldViewport struct {
+	X int
+	Y int
+	W int
+	H int
+}
+
+// WorldView is the content of a viewport clipped to the world bounds
+type WorldView struct {
+	X                int                `json:"x"`
+	Y                int                `json:"y"`
+	W                int                `json:"w"`
+	H                int                `json:"h"`
+	Cities           []*City            `json:"cities"`
+	PointsOfInterest []*PointOfInterest `json:"points_of_interest"`
+	Regions          []string           `json:"regions"`
+}
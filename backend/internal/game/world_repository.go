@@ -0,0 +1,54 @@
+package game
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/ton-empire/backend/pkg/models"
+)
+
+// World map operations
+
+// worldLockKey is the advisory lock serializing city placement
+const worldLockKey int64 = 0x776f726c64
+
+// GetCitiesInArea returns the cities inside a rectangle of the world map,
+// edges included
+func (r *Repository) GetCitiesInArea(ctx context.Context, minX, minY, maxX, maxY int) ([]*City, error) {
+	query := `
+		SELECT ` + cityColumns + `
+		FROM cities c
+		WHERE point(c.x, c.y) <@ box(point($1, $2), point($3, $4))`
+
+	var cities []*City
+	if err := r.db.SelectContext(ctx, &cities, query, minX, minY, maxX, maxY); err != nil {
+		return nil, err
+	}
+	return cities, nil
+}
+
+// GetCityPositionsTx returns where every city is as part of a transaction
+func (r *Repository) GetCityPositionsTx(ctx context.Context, tx *sqlx.Tx) (map[models.Position]bool, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT x, y FROM cities`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	positions := make(map[models.Position]bool)
+	for rows.Next() {
+		var position models.Position
+		if err := rows.Scan(&position.X, &position.Y); err != nil {
+			return nil, err
+		}
+		positions[position] = true
+	}
+
+	return positions, rows.Err()
+}
+
+// LockWorldTx serializes placing cities on the world map until tx ends
+func (r *Repository) LockWorldTx(ctx context.Context, tx *sqlx.Tx) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, worldLockKey)
+	return err
+}
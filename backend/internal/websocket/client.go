@@ -3,10 +3,12 @@ package websocket
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/ton-empire/backend/internal/common/events"
 	"github.com/ton-empire/backend/pkg/logger"
 )
 
@@ -22,6 +24,9 @@ const (
 
 	// Maximum message size allowed from peer
 	maxMessageSize = 512 * 1024 // 512KB
+
+	// Maximum region rooms a client can watch at once
+	maxRegionRooms = 25
 )
 
 var Upgrader = websocket.Upgrader{
@@ -80,6 +85,10 @@ func (c *Client) ReadPump() {
 			// Pong received, connection is alive
 			continue
 
+		case MessageTypeJoinRoom, MessageTypeLeaveRoom:
+			c.handleRoomRequest(&message)
+			continue
+
 		case MessageTypeChatGuild, MessageTypeChatDistrict:
 			// Validate user has access to the room
 			var msgData struct {
@@ -103,6 +112,11 @@ func (c *Client) ReadPump() {
 			// Notifications are server->client only
 			c.sendError("Cannot send notifications")
 			continue
+
+		case MessageTypeGuildUpdate, MessageTypeGuildMembership:
+			// Guild updates are server->client only
+			c.sendError("Cannot send guild updates")
+			continue
 		}
 
 		// Send message to hub for processing
@@ -110,6 +124,42 @@ func (c *Client) ReadPump() {
 	}
 }
 
+// handleRoomRequest joins or leaves a world map region room. Other rooms
+// are joined by the server on the client's behalf.
+func (c *Client) handleRoomRequest(message *Message) {
+	var msgData struct {
+		RoomID string `json:"room_id"`
+	}
+	if err := json.Unmarshal(message.Data, &msgData); err != nil {
+		c.sendError("Invalid message format")
+		return
+	}
+	if !strings.HasPrefix(msgData.RoomID, events.RegionRoomPrefix) {
+		c.sendError("Only region rooms can be joined")
+		return
+	}
+
+	if message.Type == MessageTypeLeaveRoom {
+		c.Hub.LeaveRoom(c, msgData.RoomID)
+		return
+	}
+
+	c.mu.RLock()
+	regions := 0
+	for roomID := range c.Rooms {
+		if strings.HasPrefix(roomID, events.RegionRoomPrefix) {
+			regions++
+		}
+	}
+	c.mu.RUnlock()
+
+	if regions >= maxRegionRooms {
+		c.sendError("Too many region rooms")
+		return
+	}
+	c.Hub.JoinRoom(c, msgData.RoomID)
+}
+
 // WritePump pumps messages from the hub to the websocket connection
 func (c *Client) WritePump() {
 	ticker := time.NewTicker(pingPeriod)
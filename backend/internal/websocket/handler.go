@@ -1,26 +1,36 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/ton-empire/backend/internal/common/events"
 	"github.com/ton-empire/backend/internal/common/middleware"
+	"github.com/ton-empire/backend/internal/common/proxy"
 	"github.com/ton-empire/backend/pkg/logger"
 )
 
 
+// guildLookupTimeout bounds how long a connection waits for the user service
+// to tell the guild of its user
+const guildLookupTimeout = 3 * time.Second
+
 // Handler handles WebSocket connections
 type Handler struct {
-	hub *Hub
+	hub   *Hub
+	proxy *proxy.ServiceProxy
 }
 
 // NewHandler creates a new WebSocket handler
-func NewHandler(hub *Hub) *Handler {
+func NewHandler(hub *Hub, serviceProxy *proxy.ServiceProxy) *Handler {
 	return &Handler{
-		hub: hub,
+		hub:   hub,
+		proxy: serviceProxy,
 	}
 }
 
@@ -33,6 +43,14 @@ func (h *Handler) HandleWebSocket(c *gin.Context) {
 		return
 	}
 
+	// Look up the user's guild before upgrading, while the request is still
+	// a plain HTTP one. A failed lookup only skips the guild room; the user
+	// joins it with the next guild_membership event.
+	guildID, err := h.getGuildID(c.Request.Context(), c.GetHeader(middleware.AuthorizationHeader))
+	if err != nil {
+		logger.Errorf("Failed to get guild of user %s: %v", userID, err)
+	}
+
 	// Upgrade HTTP connection to WebSocket
 	conn, err := Upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -51,18 +69,17 @@ func (h *Handler) HandleWebSocket(c *gin.Context) {
 	go client.ReadPump()
 
 	// Auto-join user to their district and guild rooms
-	h.autoJoinRooms(client, userID)
+	h.autoJoinRooms(client, userID, guildID)
 
 	logger.Infof("WebSocket connection established for user %s", userID)
 }
 
 // autoJoinRooms automatically joins user to relevant rooms
-func (h *Handler) autoJoinRooms(client *Client, userID uuid.UUID) {
+func (h *Handler) autoJoinRooms(client *Client, userID uuid.UUID, guildID *uuid.UUID) {
 	// Join personal room (for direct messages)
 	h.hub.JoinRoom(client, "user:"+userID.String())
 
-	// TODO: Query user's district and guild from database
-	// For now, we'll use placeholder room IDs
+	// TODO: Query user's district from database
 	
 	// Join district room
 	// districtID := getUserDistrictID(userID)
@@ -71,10 +88,40 @@ func (h *Handler) autoJoinRooms(client *Client, userID uuid.UUID) {
 	// }
 
 	// Join guild room
-	// guildID := getUserGuildID(userID)
-	// if guildID != "" {
-	//     h.hub.JoinRoom(client, "guild:"+guildID)
-	// }
+	h.joinGuildRoom(client, guildID)
+}
+
+// getGuildID asks the user service which guild the user of an
+// authorization header belongs to
+func (h *Handler) getGuildID(ctx context.Context, authorization string) (*uuid.UUID, error) {
+	ctx, cancel := context.WithTimeout(ctx, guildLookupTimeout)
+	defer cancel()
+
+	user, err := h.proxy.GetCurrentUser(ctx, authorization)
+	if err != nil {
+		return nil, err
+	}
+	return user.GuildID, nil
+}
+
+// joinGuildRoom moves a client into the room of a guild, leaving the room
+// of any guild it was in before. A nil guildID only leaves.
+func (h *Handler) joinGuildRoom(client *Client, guildID *uuid.UUID) {
+	var previous []string
+	client.mu.RLock()
+	for roomID := range client.Rooms {
+		if strings.HasPrefix(roomID, events.GuildRoomPrefix) {
+			previous = append(previous, roomID)
+		}
+	}
+	client.mu.RUnlock()
+
+	for _, roomID := range previous {
+		h.hub.LeaveRoom(client, roomID)
+	}
+	if guildID != nil {
+		h.hub.JoinRoom(client, events.GuildRoomPrefix+guildID.String())
+	}
 }
 
 // NotifyResourceUpdate sends resource update notification to a user
@@ -120,7 +167,7 @@ func (h *Handler) NotifyGuildEvent(guildID string, eventType string, eventData i
 	}
 
 	data, _ := json.Marshal(map[string]interface{}{
-		"room_id":    "guild:" + guildID,
+		"room_id":    events.GuildRoomPrefix + guildID,
 		"event_type": eventType,
 		"event_data": eventData,
 	})
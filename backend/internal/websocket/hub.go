@@ -19,6 +19,8 @@ const (
 	MessageTypeDisconnect MessageType = "disconnect"
 	MessageTypePing       MessageType = "ping"
 	MessageTypePong       MessageType = "pong"
+	MessageTypeJoinRoom   MessageType = "join_room"
+	MessageTypeLeaveRoom  MessageType = "leave_room"
 	
 	// Game events
 	MessageTypeResourceUpdate   MessageType = "resource_update"
@@ -27,6 +29,18 @@ const (
 	MessageTypeGuildUpdate      MessageType = "guild_update"
 	MessageTypeBattleEvent      MessageType = "battle_event"
 	MessageTypeNotification     MessageType = "notification"
+
+	MessageTypeBuildingUpgradeComplete MessageType = "building_upgrade_complete"
+	MessageTypeCityUpdate              MessageType = "city_update"
+	MessageTypeBattleStarted           MessageType = "battle_started"
+	MessageTypeBattleEnded             MessageType = "battle_ended"
+	MessageTypeTrainingComplete        MessageType = "training_complete"
+	MessageTypeGuildMembership         MessageType = "guild_membership"
+	MessageTypeWaveIncoming            MessageType = "wave_incoming"
+	MessageTypeWaveResult              MessageType = "wave_result"
+	MessageTypeMarketTradeCompleted    MessageType = "market_trade_completed"
+	MessageTypeCaravanDeparted         MessageType = "caravan_departed"
+	MessageTypeCaravanArrived          MessageType = "caravan_arrived"
 	
 	// Chat messages
 	MessageTypeChatGuild    MessageType = "chat_guild"
@@ -157,7 +171,7 @@ func (h *Hub) unregisterClient(client *Client) {
 
 func (h *Hub) handleMessage(message *Message) {
 	switch message.Type {
-	case MessageTypeChatGuild, MessageTypeChatDistrict:
+	case MessageTypeChatGuild, MessageTypeChatDistrict, MessageTypeGuildUpdate:
 		// Broadcast to room
 		h.broadcastToRoom(message)
 	case MessageTypeResourceUpdate, MessageTypeBuildingUpdate, MessageTypeDistrictUpdate:
@@ -199,8 +213,12 @@ func (h *Hub) broadcastToRoom(message *Message) {
 		return
 	}
 
+	h.sendToRoom(msgData.RoomID, message)
+}
+
+func (h *Hub) sendToRoom(roomID string, message *Message) {
 	h.mu.RLock()
-	room, exists := h.rooms[msgData.RoomID]
+	room, exists := h.rooms[roomID]
 	h.mu.RUnlock()
 
 	if !exists {
@@ -244,6 +262,20 @@ func (h *Hub) sendToUser(userID uuid.UUID, message *Message) {
 	}
 }
 
+// userClients returns the connections of a user
+func (h *Hub) userClients(userID uuid.UUID) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var clients []*Client
+	for _, client := range h.clients {
+		if client.UserID == userID {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
 func (h *Hub) pingClients() {
 	msg := &Message{
 		ID:        uuid.New().String(),
@@ -295,6 +327,9 @@ func (h *Hub) JoinRoom(client *Client, roomID string) {
 
 // LeaveRoom removes a client from a room
 func (h *Hub) LeaveRoom(client *Client, roomID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
 	h.removeFromRoom(client, roomID)
 }
 
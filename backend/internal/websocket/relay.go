@@ -0,0 +1,84 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ton-empire/backend/internal/common/cache"
+	"github.com/ton-empire/backend/internal/common/events"
+	"github.com/ton-empire/backend/pkg/logger"
+)
+
+// RelayEvents forwards game events published by backend services to
+// connected clients until ctx is cancelled
+func (h *Handler) RelayEvents(ctx context.Context, redisCache *cache.RedisCache) {
+	pubsub := redisCache.Subscribe(ctx, events.Channel)
+	defer pubsub.Close()
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case payload, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			var event events.Event
+			if err := json.Unmarshal([]byte(payload.Payload), &event); err != nil {
+				logger.Errorf("Failed to unmarshal game event: %v", err)
+				continue
+			}
+
+			h.dispatchEvent(&event)
+		}
+	}
+}
+
+// dispatchEvent delivers an event to its target user or room. Events for
+// guild rooms are wrapped in guild updates.
+func (h *Handler) dispatchEvent(event *events.Event) {
+	if MessageType(event.Type) == MessageTypeGuildMembership {
+		h.moveToGuildRoom(event)
+	}
+
+	msg := &Message{
+		ID:        uuid.New().String(),
+		Type:      MessageType(event.Type),
+		UserID:    event.UserID,
+		Timestamp: time.Now(),
+		Data:      event.Data,
+	}
+
+	switch {
+	case event.UserID != uuid.Nil:
+		h.hub.sendToUser(event.UserID, msg)
+	case strings.HasPrefix(event.RoomID, events.GuildRoomPrefix):
+		h.NotifyGuildEvent(strings.TrimPrefix(event.RoomID, events.GuildRoomPrefix), event.Type, event.Data)
+	case event.RoomID != "":
+		h.hub.sendToRoom(event.RoomID, msg)
+	default:
+		h.hub.broadcastToAll(msg)
+	}
+}
+
+// moveToGuildRoom moves the connections of a user who joined or left a
+// guild to the room of their new guild
+func (h *Handler) moveToGuildRoom(event *events.Event) {
+	var membership struct {
+		GuildID *uuid.UUID `json:"guild_id"`
+	}
+	if err := json.Unmarshal(event.Data, &membership); err != nil {
+		logger.Errorf("Failed to unmarshal guild membership of user %s: %v", event.UserID, err)
+		return
+	}
+
+	for _, client := range h.hub.userClients(event.UserID) {
+		h.joinGuildRoom(client, membership.GuildID)
+	}
+}
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_buildings_upgrade_end_at;
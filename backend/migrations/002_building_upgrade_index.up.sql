@@ -0,0 +1,2 @@
+-- Speeds up the scheduler lookup of buildings whose upgrade has finished
+CREATE INDEX idx_buildings_upgrade_end_at ON buildings(upgrade_end_at) WHERE upgrade_end_at IS NOT NULL;
@@ -0,0 +1,13 @@
+-- Construction queue: upgrades waiting for a free builder slot
+CREATE TABLE construction_queue (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    district_id UUID NOT NULL REFERENCES districts(id) ON DELETE CASCADE,
+    building_id UUID NOT NULL REFERENCES buildings(id) ON DELETE CASCADE,
+    target_level INTEGER NOT NULL CHECK (target_level >= 2),
+    position INTEGER NOT NULL,
+    cost JSONB NOT NULL DEFAULT '{}', -- resources reserved when the entry was queued
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(building_id)
+);
+
+CREATE INDEX idx_construction_queue_district_id ON construction_queue(district_id, position);
@@ -0,0 +1,2 @@
+-- Upkeep is charged for the time since it was last settled
+ALTER TABLE districts ADD COLUMN last_upkeep_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
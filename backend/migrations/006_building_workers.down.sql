@@ -0,0 +1 @@
+ALTER TABLE buildings DROP COLUMN IF EXISTS workers;
@@ -0,0 +1,2 @@
+-- Citizens assigned to work in a building
+ALTER TABLE buildings ADD COLUMN workers INTEGER NOT NULL DEFAULT 0 CHECK (workers >= 0);
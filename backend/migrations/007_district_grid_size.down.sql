@@ -0,0 +1 @@
+ALTER TABLE districts DROP COLUMN IF EXISTS grid_size;
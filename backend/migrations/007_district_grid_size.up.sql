@@ -0,0 +1,2 @@
+-- Width and height of a district's buildable grid, grown by land expansions
+ALTER TABLE districts ADD COLUMN grid_size INTEGER NOT NULL DEFAULT 10 CHECK (grid_size >= 1);
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS resource_ledger_append_only ON resource_ledger;
+DROP FUNCTION IF EXISTS reject_ledger_change();
+DROP TABLE IF EXISTS resource_ledger;
@@ -0,0 +1,28 @@
+-- Append-only record of every credit and debit of district resources.
+-- Entries are kept for good, including those of users and districts that
+-- were deleted, so district_id and user_id aren't foreign keys.
+CREATE TABLE resource_ledger (
+    id BIGSERIAL PRIMARY KEY,
+    district_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    resource_type VARCHAR(20) NOT NULL,
+    delta BIGINT NOT NULL,
+    balance BIGINT NOT NULL,
+    reason VARCHAR(50) NOT NULL,
+    source_type VARCHAR(50) NOT NULL DEFAULT '',
+    source_id UUID,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_resource_ledger_district ON resource_ledger(district_id, id DESC);
+CREATE INDEX idx_resource_ledger_user_time ON resource_ledger(user_id, created_at);
+
+CREATE OR REPLACE FUNCTION reject_ledger_change() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'resource_ledger is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER resource_ledger_append_only
+    BEFORE UPDATE OR DELETE ON resource_ledger
+    FOR EACH ROW EXECUTE FUNCTION reject_ledger_change();
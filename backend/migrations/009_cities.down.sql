@@ -0,0 +1 @@
+ALTER TABLE cities ALTER COLUMN guild_id SET NOT NULL;
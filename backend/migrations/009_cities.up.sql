@@ -0,0 +1,2 @@
+-- NPC cities belong to no guild. They hold the districts of guildless players.
+ALTER TABLE cities ALTER COLUMN guild_id DROP NOT NULL;
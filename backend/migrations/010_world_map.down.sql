@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_cities_location;
+ALTER TABLE cities DROP CONSTRAINT IF EXISTS cities_position_key;
+ALTER TABLE cities DROP COLUMN IF EXISTS y;
+ALTER TABLE cities DROP COLUMN IF EXISTS x;
@@ -0,0 +1,22 @@
+-- Position of every city on the world map
+ALTER TABLE cities ADD COLUMN x INTEGER;
+ALTER TABLE cities ADD COLUMN y INTEGER;
+
+-- Existing cities are laid out row by row on a grid of 10 cells, the
+-- world.city_spacing of config/catalog.yaml at the time. With another
+-- spacing, new cities take the grid spots nearest to the existing ones.
+UPDATE cities SET x = placed.x, y = placed.y
+FROM (
+    SELECT id,
+           ((ROW_NUMBER() OVER (ORDER BY created_at, id) - 1) % 100) * 10 AS x,
+           ((ROW_NUMBER() OVER (ORDER BY created_at, id) - 1) / 100) * 10 AS y
+    FROM cities
+) placed
+WHERE cities.id = placed.id;
+
+ALTER TABLE cities ALTER COLUMN x SET NOT NULL;
+ALTER TABLE cities ALTER COLUMN y SET NOT NULL;
+ALTER TABLE cities ADD CONSTRAINT cities_position_key UNIQUE (x, y);
+
+-- Spatial index for viewport queries
+CREATE INDEX idx_cities_location ON cities USING gist (point(x, y));
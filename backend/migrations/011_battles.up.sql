@@ -0,0 +1,19 @@
+-- PvP battles, resolved as soon as they are started
+CREATE TABLE battles (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    attacker_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    defender_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    attacker_district_id UUID NOT NULL REFERENCES districts(id) ON DELETE CASCADE,
+    defender_district_id UUID NOT NULL REFERENCES districts(id) ON DELETE CASCADE,
+    attack DOUBLE PRECISION NOT NULL,
+    defense DOUBLE PRECISION NOT NULL,
+    winner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    loot JSONB NOT NULL DEFAULT '{}', -- resources the attacker took
+    buildings_damaged INTEGER NOT NULL DEFAULT 0,
+    buildings_destroyed INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (attacker_id <> defender_id)
+);
+
+CREATE INDEX idx_battles_attacker ON battles(attacker_id, created_at DESC);
+CREATE INDEX idx_battles_defender ON battles(defender_id, created_at DESC);
@@ -0,0 +1,5 @@
+ALTER TABLE battles DROP COLUMN IF EXISTS log;
+ALTER TABLE battles DROP COLUMN IF EXISTS setup;
+ALTER TABLE battles DROP COLUMN IF EXISTS destruction;
+ALTER TABLE battles DROP COLUMN IF EXISTS ticks;
+ALTER TABLE battles DROP COLUMN IF EXISTS seed;
@@ -0,0 +1,7 @@
+-- Everything needed to replay a battle: the seed and input of the
+-- simulation and its compactly encoded event log
+ALTER TABLE battles ADD COLUMN seed BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE battles ADD COLUMN ticks INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE battles ADD COLUMN destruction DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE battles ADD COLUMN setup JSONB;
+ALTER TABLE battles ADD COLUMN log BYTEA;
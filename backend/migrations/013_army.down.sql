@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS troop_training;
+DROP TABLE IF EXISTS district_troops;
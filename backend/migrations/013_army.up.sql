@@ -0,0 +1,22 @@
+-- Trained troops of every district
+CREATE TABLE district_troops (
+    district_id UUID NOT NULL REFERENCES districts(id) ON DELETE CASCADE,
+    troop_type VARCHAR(50) NOT NULL,
+    count INTEGER NOT NULL DEFAULT 0 CHECK (count >= 0),
+    PRIMARY KEY (district_id, troop_type)
+);
+
+-- Troops in training at the Barracks; orders are trained one after another
+CREATE TABLE troop_training (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    district_id UUID NOT NULL REFERENCES districts(id) ON DELETE CASCADE,
+    troop_type VARCHAR(50) NOT NULL,
+    count INTEGER NOT NULL CHECK (count > 0),
+    cost JSONB NOT NULL DEFAULT '{}', -- resources paid when the order was placed
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    completes_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_troop_training_district_id ON troop_training(district_id, completes_at);
+CREATE INDEX idx_troop_training_completes_at ON troop_training(completes_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_districts_shield_until;
+ALTER TABLE districts DROP COLUMN IF EXISTS shield_until;
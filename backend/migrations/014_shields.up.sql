@@ -0,0 +1,8 @@
+-- Districts can't be attacked while shielded
+ALTER TABLE districts ADD COLUMN shield_until TIMESTAMP WITH TIME ZONE;
+
+-- Players who registered within the beginner period (shield.beginner in
+-- config/catalog.yaml) are shielded by the game service when it starts, so
+-- the period isn't duplicated here
+
+CREATE INDEX idx_districts_shield_until ON districts(shield_until);
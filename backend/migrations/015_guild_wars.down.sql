@@ -0,0 +1,2 @@
+ALTER TABLE battles DROP COLUMN IF EXISTS guild_war_id;
+DROP TABLE IF EXISTS guild_wars;
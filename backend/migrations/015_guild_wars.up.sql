@@ -0,0 +1,24 @@
+-- Wars between guilds: a preparation window after the declaration, then a
+-- fixed-length war in which battles between members score points
+CREATE TABLE guild_wars (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    attacker_guild_id UUID NOT NULL REFERENCES guilds(id) ON DELETE CASCADE,
+    defender_guild_id UUID NOT NULL REFERENCES guilds(id) ON DELETE CASCADE,
+    declared_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    attacker_score INTEGER NOT NULL DEFAULT 0,
+    defender_score INTEGER NOT NULL DEFAULT 0,
+    winner_guild_id UUID REFERENCES guilds(id) ON DELETE SET NULL, -- NULL for a draw
+    reward JSONB NOT NULL DEFAULT '{}', -- resources paid into the winner's treasury
+    declared_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ends_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ended_at TIMESTAMP WITH TIME ZONE, -- set once the war is settled
+    CHECK (attacker_guild_id <> defender_guild_id)
+);
+
+CREATE INDEX idx_guild_wars_attacker ON guild_wars(attacker_guild_id, declared_at DESC);
+CREATE INDEX idx_guild_wars_defender ON guild_wars(defender_guild_id, declared_at DESC);
+CREATE INDEX idx_guild_wars_ends_at ON guild_wars(ends_at) WHERE ended_at IS NULL;
+
+-- The war a battle scored points in
+ALTER TABLE battles ADD COLUMN guild_war_id UUID REFERENCES guild_wars(id) ON DELETE SET NULL;
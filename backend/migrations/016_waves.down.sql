@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS wave_reinforcements;
+DROP TABLE IF EXISTS waves;
@@ -0,0 +1,31 @@
+-- NPC waves attacking cities. A wave is announced before it lands and
+-- resolved when it does.
+CREATE TABLE waves (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    city_id UUID NOT NULL REFERENCES cities(id) ON DELETE CASCADE,
+    power DOUBLE PRECISION NOT NULL,
+    announced_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    lands_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    -- Outcome, set once the wave landed
+    defense DOUBLE PRECISION,
+    held BOOLEAN,
+    buildings_damaged INTEGER NOT NULL DEFAULT 0,
+    reward JSONB NOT NULL DEFAULT '{}', -- resources shared among the defenders
+    resolved_at TIMESTAMP WITH TIME ZONE
+);
+
+-- A city faces one wave at a time
+CREATE UNIQUE INDEX idx_waves_pending_city ON waves(city_id) WHERE resolved_at IS NULL;
+CREATE INDEX idx_waves_lands_at ON waves(lands_at) WHERE resolved_at IS NULL;
+CREATE INDEX idx_waves_city ON waves(city_id, lands_at DESC);
+
+-- Troops guildmates sent to help a city against a wave
+CREATE TABLE wave_reinforcements (
+    wave_id UUID NOT NULL REFERENCES waves(id) ON DELETE CASCADE,
+    district_id UUID NOT NULL REFERENCES districts(id) ON DELETE CASCADE,
+    troop_type VARCHAR(50) NOT NULL,
+    count INTEGER NOT NULL CHECK (count > 0),
+    PRIMARY KEY (wave_id, district_id, troop_type)
+);
+
+CREATE INDEX idx_wave_reinforcements_district ON wave_reinforcements(district_id);
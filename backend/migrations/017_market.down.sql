@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS market_trades;
+DROP TABLE IF EXISTS market_orders;
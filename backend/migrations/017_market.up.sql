@@ -0,0 +1,31 @@
+-- Limit orders of the player market. Every resource but gold is traded for
+-- gold; what an order offers is held in escrow until it fills or is cancelled.
+CREATE TABLE market_orders (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    district_id UUID NOT NULL REFERENCES districts(id) ON DELETE CASCADE,
+    side VARCHAR(4) NOT NULL CHECK (side IN ('buy', 'sell')),
+    resource_type VARCHAR(20) NOT NULL CHECK (resource_type IN ('wood', 'stone', 'food', 'energy')),
+    price BIGINT NOT NULL CHECK (price > 0), -- gold per unit
+    quantity BIGINT NOT NULL CHECK (quantity > 0),
+    filled BIGINT NOT NULL DEFAULT 0 CHECK (filled >= 0 AND filled <= quantity),
+    status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'filled', 'cancelled')),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_market_orders_book ON market_orders(resource_type, side, price, created_at) WHERE status = 'open';
+CREATE INDEX idx_market_orders_district ON market_orders(district_id, created_at DESC);
+
+-- Fills between a buy and a sell order
+CREATE TABLE market_trades (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    resource_type VARCHAR(20) NOT NULL,
+    price BIGINT NOT NULL,
+    quantity BIGINT NOT NULL CHECK (quantity > 0),
+    fee BIGINT NOT NULL DEFAULT 0, -- gold the seller paid
+    buy_order_id UUID NOT NULL REFERENCES market_orders(id) ON DELETE CASCADE,
+    sell_order_id UUID NOT NULL REFERENCES market_orders(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX idx_market_trades_resource ON market_trades(resource_type, created_at DESC);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS exchange_prices;
+DROP TABLE IF EXISTS exchange_trades;
@@ -0,0 +1,26 @@
+-- Trades with the NPC exchange. The net volume bought over a rolling window
+-- sets the exchange's prices; the volume per day caps what a player trades.
+CREATE TABLE exchange_trades (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    district_id UUID NOT NULL REFERENCES districts(id) ON DELETE CASCADE,
+    side VARCHAR(4) NOT NULL CHECK (side IN ('buy', 'sell')), -- from the player's side
+    resource_type VARCHAR(20) NOT NULL CHECK (resource_type IN ('wood', 'stone', 'food', 'energy')),
+    quantity BIGINT NOT NULL CHECK (quantity > 0),
+    price DOUBLE PRECISION NOT NULL, -- gold per unit
+    gold BIGINT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX idx_exchange_trades_resource ON exchange_trades(resource_type, created_at);
+CREATE INDEX idx_exchange_trades_district ON exchange_trades(district_id, created_at DESC);
+
+-- Price history of the exchange, one row per resource and interval
+CREATE TABLE exchange_prices (
+    resource_type VARCHAR(20) NOT NULL,
+    bucket TIMESTAMP WITH TIME ZONE NOT NULL,
+    open DOUBLE PRECISION NOT NULL,
+    high DOUBLE PRECISION NOT NULL,
+    low DOUBLE PRECISION NOT NULL,
+    close DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (resource_type, bucket)
+);
@@ -0,0 +1,20 @@
+-- Caravans carrying resources from one district to another. Where they left
+-- from and go to is kept, so they can be tracked even if a district moves.
+CREATE TABLE caravans (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    sender_district_id UUID NOT NULL REFERENCES districts(id) ON DELETE CASCADE,
+    receiver_district_id UUID NOT NULL REFERENCES districts(id) ON DELETE CASCADE,
+    cargo JSONB NOT NULL,
+    from_x INTEGER NOT NULL,
+    from_y INTEGER NOT NULL,
+    to_x INTEGER NOT NULL,
+    to_y INTEGER NOT NULL,
+    departed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    arrives_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    arrived_at TIMESTAMP WITH TIME ZONE,
+    CHECK (sender_district_id <> receiver_district_id)
+);
+
+CREATE INDEX idx_caravans_due ON caravans(arrives_at) WHERE arrived_at IS NULL;
+CREATE INDEX idx_caravans_sender ON caravans(sender_district_id, departed_at DESC);
+CREATE INDEX idx_caravans_receiver ON caravans(receiver_district_id, departed_at DESC);
@@ -0,0 +1 @@
+ALTER TABLE caravans DROP COLUMN IF EXISTS returned;
@@ -0,0 +1,3 @@
+-- What a caravan brought back to its sender because the receiver had no
+-- room to store it. Set when the caravan arrives.
+ALTER TABLE caravans ADD COLUMN returned JSONB;